@@ -0,0 +1,46 @@
+// Command schemagen generates Go types from the CUE response schemas, so that consumers of
+// /query results and the typed query API do not have to duplicate the schemas by hand.
+//
+//	go run ./cmd/schemagen -schemas pkg/llm/validation/schemas -out pkg/llm/validation/schematypes/schematypes_gen.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	validation "github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+)
+
+func main() {
+	if err := run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	schemas := flags.String("schemas", "pkg/llm/validation/schemas", "directory of the CUE schema files")
+	out := flags.String("out", "", "file to write the Go types to, stdout if empty")
+	pkgName := flags.String("package", "schematypes", "package name of the generated file")
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+
+	source, err := validation.GenerateGoTypes(os.DirFS(*schemas), *pkgName)
+	if err != nil {
+		return fmt.Errorf("generating types: %w", err)
+	}
+
+	if *out == "" {
+		_, err := os.Stdout.Write(source)
+		return err
+	}
+
+	if err := os.WriteFile(*out, source, 0o644); err != nil {
+		return fmt.Errorf("writing types: %w", err)
+	}
+
+	return nil
+}
@@ -1,10 +1,18 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
+	"github.com/yreinhar/llm-go-blueprint/pkg/cache"
 	"github.com/yreinhar/llm-go-blueprint/pkg/handlers"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/guard"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/rag"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/redact"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tools"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
 	"github.com/yreinhar/llm-go-blueprint/pkg/middleware"
 	"github.com/yreinhar/llm-go-blueprint/pkg/routes"
 	"github.com/yreinhar/llm-go-blueprint/pkg/service"
@@ -21,9 +29,20 @@ type Server struct {
 type ServerOption func(*serverConfig)
 
 type serverConfig struct {
-	model           string
-	responseSchemas []string
-	promptTemplates []string
+	model            string
+	modelDefinitions map[string]model.Definition
+	responseSchemas  []string
+	requestSchemas   []string
+	promptTemplates  []string
+	cache            cache.Config
+	retrieval        rag.Config
+	inputGuards      guard.InputConfig
+	redaction        redact.Config
+	requests         validation.RequestConfig
+	tools            *tools.Registry
+	maxToolSteps     int
+	tasks            map[string]string
+	defaultTask      string
 }
 
 // WithModel sets the model for the query service
@@ -33,6 +52,13 @@ func WithModel(model string) ServerOption {
 	}
 }
 
+// WithModelDefinitions adds model definitions or replaces the built-in ones by their logical name
+func WithModelDefinitions(definitions map[string]model.Definition) ServerOption {
+	return func(c *serverConfig) {
+		c.modelDefinitions = definitions
+	}
+}
+
 // WithResponseSchemas sets the response schemas for validation
 func WithResponseSchemas(schemas []string) ServerOption {
 	return func(c *serverConfig) {
@@ -40,6 +66,22 @@ func WithResponseSchemas(schemas []string) ServerOption {
 	}
 }
 
+// WithTasks sets the tasks served by /query with the response schema of each, and the task of
+// payloads without one
+func WithTasks(tasks map[string]string, defaultTask string) ServerOption {
+	return func(c *serverConfig) {
+		c.tasks = tasks
+		c.defaultTask = defaultTask
+	}
+}
+
+// WithRequestValidation sets the size limit of request bodies and the schemas of the template variables by task
+func WithRequestValidation(config validation.RequestConfig) ServerOption {
+	return func(c *serverConfig) {
+		c.requests = config
+	}
+}
+
 // WithPromptTemplates sets the prompt templates
 func WithPromptTemplates(templates []string) ServerOption {
 	return func(c *serverConfig) {
@@ -47,9 +89,48 @@ func WithPromptTemplates(templates []string) ServerOption {
 	}
 }
 
+// WithCache enables the response cache for identical prompt requests
+func WithCache(config cache.Config) ServerOption {
+	return func(c *serverConfig) {
+		c.cache = config
+	}
+}
+
+// WithRetrieval enables templates with a retrieval block and ingests the configured documents
+func WithRetrieval(config rag.Config) ServerOption {
+	return func(c *serverConfig) {
+		c.retrieval = config
+	}
+}
+
+// WithInputGuards checks the user input with the configured guards before the prompt is built
+func WithInputGuards(config guard.InputConfig) ServerOption {
+	return func(c *serverConfig) {
+		c.inputGuards = config
+	}
+}
+
+// WithRedaction handles personal data in prompts and responses and masks it in transcripts
+func WithRedaction(config redact.Config) ServerOption {
+	return func(c *serverConfig) {
+		c.redaction = config
+	}
+}
+
+// WithTools sets the handlers of the tools declared in the prompt templates and the maximum rounds
+// of tool calls per request
+func WithTools(registry *tools.Registry, maxSteps int) ServerOption {
+	return func(c *serverConfig) {
+		c.tools = registry
+		c.maxToolSteps = maxSteps
+	}
+}
+
 const (
 	personResponseSchema = "schemas/personResponse.cue"
+	queryRequestSchema   = "schemas/queryRequest.cue"
 	promptTestTemplate   = "prompts/promptTemplateDefault.yaml"
+	chatTemplate         = "prompts/promptTemplateChat.yaml"
 )
 
 // NewServer creates a new server instance with all required dependencies.
@@ -59,7 +140,8 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 	cfg := &serverConfig{
 		model:           "LlamaLocal",
 		responseSchemas: []string{personResponseSchema},
-		promptTemplates: []string{promptTestTemplate},
+		requestSchemas:  []string{queryRequestSchema},
+		promptTemplates: []string{promptTestTemplate, chatTemplate},
 	}
 
 	// Apply options
@@ -67,22 +149,102 @@ func NewServer(opts ...ServerOption) (*Server, error) {
 		opt(cfg)
 	}
 
+	// Every server has its own models, servers of one process do not share their definitions.
+	definitions := model.NewDefinitions(cfg.modelDefinitions)
+
+	responseCache, err := cache.New(cfg.cache)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create response cache: %w", err)
+	}
+
+	serviceOpts := []service.Option{service.WithModelDefinitions(definitions)}
+	if responseCache != nil {
+		serviceOpts = append(serviceOpts, service.WithResponseCache(responseCache, cfg.cache.TTL.Std()))
+	}
+	if cfg.cache.Semantic.Embedder != "" {
+		embedder, err := definitions.NewEmbedder(cfg.cache.Semantic.Embedder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create embedder: %w", err)
+		}
+		serviceOpts = append(serviceOpts, service.WithSemanticCache(cache.NewSemantic(embedder, cfg.cache.Semantic)))
+	}
+
+	requestValidator, err := validation.NewRequestSchemaValidator(cfg.requestSchemas, cfg.requests)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request validator: %w", err)
+	}
+	handlerOpts := []handlers.HandlerOption{handlers.WithRequestValidator(requestValidator)}
+	if len(cfg.tasks) > 0 {
+		if _, ok := cfg.tasks[cfg.defaultTask]; !ok {
+			return nil, fmt.Errorf("default task %q is not one of the tasks", cfg.defaultTask)
+		}
+		handlerOpts = append(handlerOpts, handlers.WithTasks(cfg.tasks, cfg.defaultTask))
+	}
+	if cfg.retrieval.Embedder != "" {
+		store, err := NewRetrievalStore(cfg.retrieval, definitions)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.retrieval.Documents != "" {
+			if _, err := store.IngestDir(context.Background(), cfg.retrieval.Documents); err != nil {
+				return nil, fmt.Errorf("failed to ingest documents: %w", err)
+			}
+		}
+		serviceOpts = append(serviceOpts, service.WithRetriever(store))
+		handlerOpts = append(handlerOpts, handlers.WithDocumentStore(store))
+	}
+
+	redactor, err := redact.New(cfg.redaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create redactor: %w", err)
+	}
+	serviceOpts = append(serviceOpts, service.WithRedactor(redactor))
+
+	inputGuards, err := guard.NewInputGuards(cfg.inputGuards, definitions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create input guards: %w", err)
+	}
+	if len(inputGuards) > 0 {
+		serviceOpts = append(serviceOpts, service.WithInputGuards(guard.NewInputChain(inputGuards...)))
+	}
+
+	if cfg.tools != nil {
+		serviceOpts = append(serviceOpts, service.WithTools(cfg.tools, cfg.maxToolSteps))
+	}
+
 	// Create query service with configuration
 	queryService, err := service.NewQueryService(
 		cfg.model,
 		cfg.responseSchemas,
 		cfg.promptTemplates,
+		serviceOpts...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create query service: %w", err)
 	}
 
 	return &Server{
-		handler: handlers.NewHandler(queryService),
+		handler: handlers.NewHandler(queryService, handlerOpts...),
 		mux:     http.NewServeMux(),
 	}, nil
 }
 
+// NewRetrievalStore opens the vector index of the retrieval config with its embedding model of the
+// definitions.
+func NewRetrievalStore(config rag.Config, definitions *model.Definitions) (*rag.Store, error) {
+	embedder, err := definitions.NewEmbedder(config.Embedder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedder: %w", err)
+	}
+
+	store, err := rag.Open(config.Index, embedder, config.Chunking)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open retrieval index: %w", err)
+	}
+
+	return store, nil
+}
+
 // Handler returns the configured http.Handler with all routes and middleware applied.
 // It sets up the routes and wraps the handler with logging middleware.
 func (s *Server) Handler() http.Handler {
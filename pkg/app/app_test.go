@@ -1,12 +1,22 @@
 package app
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+	"github.com/yreinhar/llm-go-blueprint/pkg/service"
 )
 
 // MockQueryService implements the QueryService interface for testing
@@ -14,9 +24,21 @@ type MockQueryService struct {
 	mock.Mock
 }
 
-func (m *MockQueryService) ProcessPrompt(prompt, schemaType, task string) (string, error) {
+func (m *MockQueryService) ProcessPrompt(ctx context.Context, prompt, schemaType, task string, opts ...service.QueryOption) (*service.Result, error) {
 	args := m.Called(prompt, schemaType, task)
-	return args.String(0), args.Error(1)
+	result, _ := args.Get(0).(*service.Result)
+	return result, args.Error(1)
+}
+
+func (m *MockQueryService) Backends() []model.BackendStatus {
+	args := m.Called()
+	backends, _ := args.Get(0).([]model.BackendStatus)
+	return backends
+}
+
+func (m *MockQueryService) InvalidateCache(task string) int {
+	args := m.Called(task)
+	return args.Int(0)
 }
 
 func TestCreateServer(t *testing.T) {
@@ -61,6 +83,11 @@ func TestServer(t *testing.T) {
 				path:           "/hello",
 				expectedStatus: http.StatusOK,
 			},
+			{
+				name:           "readiness path returns 200",
+				path:           "/readyz",
+				expectedStatus: http.StatusOK,
+			},
 		}
 
 		for _, tc := range testCases {
@@ -74,3 +101,140 @@ func TestServer(t *testing.T) {
 		}
 	})
 }
+
+// withChatBackend registers a model named name whose backend always answers with the content.
+func withChatBackend(t *testing.T, name, content string) ServerOption {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"choices": [{"message": {"role": "assistant", "content": %q}}]}`, content)
+	}))
+	t.Cleanup(backend.Close)
+
+	return WithModelDefinitions(map[string]model.Definition{
+		name: {
+			ModelName: "llama-3-1b-chat",
+			BaseURL:   backend.URL,
+			Transport: transport.Config{Retry: transport.RetryConfig{MaxAttempts: 1}},
+		},
+	})
+}
+
+func postQuery(handler http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/query", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestServerQueryTask(t *testing.T) {
+	opts := []ServerOption{
+		withChatBackend(t, "TaskLocal", `{"answer": "Sunny"}`),
+		WithModel("TaskLocal"),
+		WithPromptTemplates([]string{promptTestTemplate, "prompts/promptTemplateWeather.yaml"}),
+		WithResponseSchemas([]string{personResponseSchema, "schemas/answerResponse.cue", "schemas/weatherArguments.cue"}),
+		WithTasks(map[string]string{"chat": "personResponse", "weather": "answerResponse"}, "chat"),
+	}
+	server, err := NewServer(opts...)
+	require.NoError(t, err)
+	handler := server.Handler()
+
+	rr := postQuery(handler, `{"prompt": "Weather in Berlin?", "task": "weather"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `Sunny`)
+
+	rr = postQuery(handler, `{"prompt": "Weather in Berlin?", "task": "travel"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Unknown task: travel")
+
+	_, err = NewServer(append(opts, WithTasks(map[string]string{"weather": "answerResponse"}, "chat"))...)
+	assert.EqualError(t, err, `default task "chat" is not one of the tasks`)
+}
+
+func TestServerQueryOutputGuards(t *testing.T) {
+	template := filepath.Join(t.TempDir(), "strict.yaml")
+	require.NoError(t, os.WriteFile(template, []byte(`
+model: "llama-3-1b-chat"
+task: "strict"
+roles:
+  developer:
+    content: "Answer briefly."
+outputGuards:
+  banned:
+    terms: ["Acme"]
+`), 0o644))
+
+	server, err := NewServer(
+		withChatBackend(t, "GuardedLocal", `{"answer": "Buy Acme"}`),
+		WithModel("GuardedLocal"),
+		WithPromptTemplates([]string{promptTestTemplate, template}),
+		WithResponseSchemas([]string{personResponseSchema, "schemas/answerResponse.cue"}),
+		WithTasks(map[string]string{"chat": "personResponse", "strict": "answerResponse"}, "chat"),
+	)
+	require.NoError(t, err)
+
+	// The output guards of the template of the requested task run on the response.
+	rr := postQuery(server.Handler(), `{"prompt": "What should I buy?", "task": "strict"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"output_blocked"`)
+}
+
+func TestServerQueryPromptLength(t *testing.T) {
+	server, err := NewServer(
+		withChatBackend(t, "LengthLocal", `{"name": "Ada", "age": 36}`),
+		WithModel("LengthLocal"),
+		WithRequestValidation(validation.RequestConfig{
+			Variables: map[string]string{"chat": "schemas/chatVariables.cue"},
+		}),
+	)
+	require.NoError(t, err)
+	handler := server.Handler()
+
+	// The variables schema of the task is the only limit of the prompt length.
+	rr := postQuery(handler, `{"prompt": "`+strings.Repeat("a", 8000)+`", "task": "chat", "noCache": true}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = postQuery(handler, `{"prompt": "`+strings.Repeat("a", 8001)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"constraint":"strings.MaxRunes(8000)"`)
+	assert.Contains(t, rr.Body.String(), `chatVariables`)
+}
+
+func TestServerQueryPromptTooLong(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the model must not be called")
+	}))
+	t.Cleanup(backend.Close)
+
+	server, err := NewServer(
+		WithModelDefinitions(map[string]model.Definition{
+			"TinyLocal": {ModelName: "llama-3-1b-chat", BaseURL: backend.URL, ContextLength: 520},
+		}),
+		WithModel("TinyLocal"),
+	)
+	require.NoError(t, err)
+
+	rr := postQuery(server.Handler(), `{"prompt": "Who is Ada?"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"prompt_too_long"`)
+	assert.Contains(t, rr.Body.String(), `"availableTokens":8`)
+	assert.Contains(t, rr.Body.String(), `"requiredTokens":`)
+}
+
+func TestServersHaveTheirOwnModels(t *testing.T) {
+	ada, err := NewServer(withChatBackend(t, "SharedLocal", `{"name": "Ada", "age": 36}`), WithModel("SharedLocal"))
+	require.NoError(t, err)
+	bob, err := NewServer(withChatBackend(t, "SharedLocal", `{"name": "Bob", "age": 40}`), WithModel("SharedLocal"))
+	require.NoError(t, err)
+
+	// The second server does not replace the model of the first one.
+	rr := postQuery(ada.Handler(), `{"prompt": "Who?"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "Ada")
+
+	rr = postQuery(bob.Handler(), `{"prompt": "Who?"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "Bob")
+
+	_, err = model.GetDefinition("SharedLocal")
+	assert.Error(t, err, "servers do not change the default definitions")
+}
@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/config"
+)
+
+// Cache is the common interface for all response caches.
+type Cache interface {
+	// Get returns the value stored for the key if it exists and has not expired.
+	Get(key string) ([]byte, bool)
+	// Set stores the value for the key. A ttl <= 0 keeps the value until it is evicted.
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+const (
+	TypeMemory = "memory"
+	TypeDisk   = "disk"
+
+	defaultCapacity = 1000
+)
+
+// Config selects and configures a cache.
+type Config struct {
+	Type     string          `yaml:"type"`     // memory or disk; empty disables the cache
+	Capacity int             `yaml:"capacity"` // optional; maximum entries, default 1000
+	Dir      string          `yaml:"dir"`      // disk only; directory holding the entries
+	TTL      config.Duration `yaml:"ttl"`      // optional; time an entry is served, default no expiry
+
+	// Semantic also serves responses of similar prompts, independent of the exact match cache.
+	Semantic SemanticConfig `yaml:"semantic"`
+}
+
+// New creates the cache described by the config. It returns nil if no cache is configured.
+func New(cfg Config) (Cache, error) {
+	if cfg.Type == "" {
+		return nil, nil
+	}
+
+	capacity := cfg.Capacity
+	if capacity <= 0 {
+		capacity = defaultCapacity
+	}
+	switch cfg.Type {
+	case TypeMemory:
+		return NewLRU(capacity), nil
+	case TypeDisk:
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("disk cache requires a directory")
+		}
+		return NewDisk(cfg.Dir, capacity)
+	default:
+		return nil, fmt.Errorf("unknown cache type: %s", cfg.Type)
+	}
+}
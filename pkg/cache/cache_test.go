@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCache(t *testing.T) {
+	c, err := New(Config{})
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	c, err = New(Config{Type: TypeMemory})
+	assert.NoError(t, err)
+	assert.IsType(t, &LRU{}, c)
+
+	_, err = New(Config{Type: TypeDisk})
+	assert.Error(t, err)
+
+	_, err = New(Config{Type: "redis"})
+	assert.Error(t, err)
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU(2)
+	c.Set("a", []byte("1"), 0)
+	c.Set("b", []byte("2"), 0)
+
+	// Reading a makes b the least recently used entry.
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	c.Set("c", []byte("3"), 0)
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	value, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+}
+
+func TestLRUExpires(t *testing.T) {
+	c := NewLRU(2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set("a", []byte("1"), time.Minute)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	now = now.Add(2 * time.Minute)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDisk(dir, 10)
+	require.NoError(t, err)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set("key with / slashes", []byte(`{"a":1}`), time.Minute)
+
+	// A new instance reads the entries of the previous one.
+	reopened, err := NewDisk(dir, 10)
+	require.NoError(t, err)
+	value, ok := reopened.Get("key with / slashes")
+	assert.True(t, ok)
+	assert.Equal(t, []byte(`{"a":1}`), value)
+
+	now = now.Add(2 * time.Minute)
+	_, ok = c.Get("key with / slashes")
+	assert.False(t, ok)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDiskCacheCorruptEntry(t *testing.T) {
+	c, err := NewDisk(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(c.path("a"), []byte("not json"), 0o644))
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+
+	_, err = os.Stat(filepath.Join(c.dir, filepath.Base(c.path("a"))))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDisk(dir, 2)
+	require.NoError(t, err)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set("a", []byte("a"), 0)
+	now = now.Add(time.Second)
+	c.Set("b", []byte("b"), 0)
+
+	// Reading "a" makes "b" the least recently used entry.
+	now = now.Add(time.Second)
+	_, ok := c.Get("a")
+	require.True(t, ok)
+	now = now.Add(time.Second)
+	c.Set("c", []byte("c"), 0)
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+
+	// Reopening with a smaller capacity evicts the entries of the previous run.
+	_, err = NewDisk(dir, 1)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	_, err = NewDisk(dir, 0)
+	assert.EqualError(t, err, "disk cache capacity must be positive: 0")
+}
+
+func TestDiskCacheKeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("keep"), 0o644))
+
+	c, err := NewDisk(dir, 1)
+	require.NoError(t, err)
+	c.Set("a", []byte("a"), 0)
+	c.Set("b", []byte("b"), 0)
+
+	_, err = os.Stat(filepath.Join(dir, "notes.txt"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "README"))
+	assert.NoError(t, err)
+	_, ok := c.Get("b")
+	assert.True(t, ok)
+	assert.Equal(t, 1, c.entries)
+}
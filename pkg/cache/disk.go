@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	diskTempPrefix  = ".tmp-"
+	diskEntrySuffix = ".entry"
+)
+
+// Disk is a cache that stores every entry as a file. Entries survive restarts of the application.
+// Once it holds more than capacity entries, the least recently used entries are removed. Only files
+// named like entries are counted and removed, other files in the directory are left alone.
+type Disk struct {
+	dir      string
+	capacity int
+
+	mu      sync.Mutex
+	entries int
+
+	now func() time.Time
+}
+
+type diskEntry struct {
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Value     []byte    `json:"value"`
+}
+
+// NewDisk creates a cache in the given directory that holds up to capacity entries, the directory
+// is created if it does not exist.
+func NewDisk(dir string, capacity int) (*Disk, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("disk cache capacity must be positive: %d", capacity)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	c := &Disk{
+		dir:      dir,
+		capacity: capacity,
+		now:      time.Now,
+	}
+	// Entries of a previous run count against the capacity as well.
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+func (c *Disk) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry diskEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Warnf("Removing corrupt cache entry %s: %v", key, err)
+		os.Remove(c.path(key))
+		return nil, false
+	}
+
+	if !entry.ExpiresAt.IsZero() && c.now().After(entry.ExpiresAt) {
+		os.Remove(c.path(key))
+		return nil, false
+	}
+
+	// The modification time tracks the last use, eviction removes the oldest files first.
+	now := c.now()
+	os.Chtimes(c.path(key), now, now)
+
+	return entry.Value, true
+}
+
+func (c *Disk) Set(key string, value []byte, ttl time.Duration) {
+	entry := diskEntry{Value: value}
+	if ttl > 0 {
+		entry.ExpiresAt = c.now().Add(ttl)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Warnf("Failed to encode cache entry %s: %v", key, err)
+		return
+	}
+
+	// Write to a temporary file first so that readers never see a partial entry.
+	tmp, err := os.CreateTemp(c.dir, diskTempPrefix+"*")
+	if err != nil {
+		log.Warnf("Failed to write cache entry %s: %v", key, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		log.Warnf("Failed to write cache entry %s: %v", key, err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		log.Warnf("Failed to write cache entry %s: %v", key, err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err = os.Stat(c.path(key))
+	added := os.IsNotExist(err)
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		log.Warnf("Failed to write cache entry %s: %v", key, err)
+		return
+	}
+	now := c.now()
+	os.Chtimes(c.path(key), now, now)
+
+	if added {
+		c.entries++
+	}
+	if c.entries > c.capacity {
+		c.evict()
+	}
+}
+
+// evict removes the least recently used entries above the capacity. The entries are counted from
+// the directory, which also corrects the count if entries expired or another process shares the
+// directory. Must hold c.mu.
+func (c *Disk) evict() {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Warnf("Failed to read cache directory %s: %v", c.dir, err)
+		return
+	}
+
+	type file struct {
+		name    string
+		modTime time.Time
+	}
+	var files []file
+	for _, e := range dirEntries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), diskEntrySuffix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{name: e.Name(), modTime: info.ModTime()})
+	}
+
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	for len(files) > c.capacity {
+		if err := os.Remove(filepath.Join(c.dir, files[0].name)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to evict cache entry %s: %v", files[0].name, err)
+		}
+		files = files[1:]
+	}
+	c.entries = len(files)
+}
+
+// path returns the file of the key. Keys are hashed so that any key is a valid file name.
+func (c *Disk) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+diskEntrySuffix)
+}
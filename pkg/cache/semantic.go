@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/config"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/vectorindex"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+var semanticCacheRequests = metrics.NewCounter("semantic_cache_requests_total")
+
+const (
+	defaultSemanticThreshold  = 0.95
+	defaultSemanticMaxEntries = 10000
+)
+
+// SemanticConfig configures the semantic cache.
+type SemanticConfig struct {
+	Embedder   string             `yaml:"embedder"`   // logical name of the embedding model; empty disables the cache
+	Threshold  float64            `yaml:"threshold"`  // optional; minimum cosine similarity of a hit, default 0.95
+	Thresholds map[string]float64 `yaml:"thresholds"` // optional; threshold per task
+	MaxEntries int                `yaml:"maxEntries"` // optional; oldest entries are evicted beyond, default 10000
+	TTL        config.Duration    `yaml:"ttl"`        // optional; time an entry is served, default no expiry
+}
+
+// SemanticScope is what a response depends on besides the prompt. Entries are only compared
+// within the same scope.
+type SemanticScope struct {
+	Task            string
+	ResponseSchema  string
+	Model           string
+	TemplateVersion string
+	// Context identifies the passages retrieved for the prompt, responses to the same question
+	// must not be served once the documents changed.
+	Context string
+}
+
+func (s SemanticScope) metadata() map[string]string {
+	return map[string]string{
+		"task":            s.Task,
+		"schema":          s.ResponseSchema,
+		"model":           s.Model,
+		"templateVersion": s.TemplateVersion,
+		"context":         s.Context,
+	}
+}
+
+// Semantic is a cache that serves a response for a prompt that is similar, not only identical, to
+// a previous one. Entries are only compared within the same scope.
+type Semantic struct {
+	embedder   model.Embedder
+	threshold  float64
+	thresholds map[string]float64
+	maxEntries int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	index   *vectorindex.Index
+	entries map[string]*list.Element
+	order   *list.List
+	nextID  uint64
+
+	now func() time.Time
+}
+
+type semanticEntry struct {
+	id        string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewSemantic creates a semantic cache that embeds prompts with the given embedder.
+func NewSemantic(embedder model.Embedder, cfg SemanticConfig) *Semantic {
+	threshold := cfg.Threshold
+	if threshold <= 0 {
+		threshold = defaultSemanticThreshold
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultSemanticMaxEntries
+	}
+
+	return &Semantic{
+		embedder:   embedder,
+		threshold:  threshold,
+		thresholds: cfg.Thresholds,
+		maxEntries: maxEntries,
+		ttl:        cfg.TTL.Std(),
+		index:      vectorindex.NewIndex(),
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// Embed returns the embedding of the prompt. It is passed to Lookup and, on a miss, to Store.
+func (c *Semantic) Embed(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := c.embedder.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, fmt.Errorf("embedding prompt: %w", err)
+	}
+
+	return vectors[0], nil
+}
+
+// Lookup returns the cached response of the most similar prompt of the scope if its similarity
+// reaches the threshold of the task.
+func (c *Semantic) Lookup(scope SemanticScope, vector []float32) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	inScope := vectorindex.MetadataEquals(scope.metadata())
+	filter := func(item vectorindex.Item) bool {
+		if !inScope(item) {
+			return false
+		}
+		element, ok := c.entries[item.ID]
+		if !ok {
+			return false
+		}
+		entry := element.Value.(*semanticEntry)
+		return entry.expiresAt.IsZero() || !now.After(entry.expiresAt)
+	}
+
+	matches, err := c.index.Search(vector, 1, filter)
+	if err != nil || len(matches) == 0 || matches[0].Score < c.thresholdOf(scope.Task) {
+		semanticCacheRequests.Inc(scope.Task, "miss")
+		return nil, false
+	}
+	semanticCacheRequests.Inc(scope.Task, "hit")
+
+	return c.entries[matches[0].ID].Value.(*semanticEntry).value, true
+}
+
+// Store caches the response of the prompt with the given embedding.
+func (c *Semantic) Store(scope SemanticScope, vector []float32, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	id := strconv.FormatUint(c.nextID, 10)
+
+	err := c.index.Add(vectorindex.Item{
+		ID:       id,
+		Vector:   vector,
+		Metadata: scope.metadata(),
+	})
+	if err != nil {
+		return fmt.Errorf("indexing prompt: %w", err)
+	}
+
+	entry := &semanticEntry{id: id, value: value}
+	if c.ttl > 0 {
+		entry.expiresAt = c.now().Add(c.ttl)
+	}
+	c.entries[id] = c.order.PushBack(entry)
+
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Front())
+	}
+
+	return nil
+}
+
+// InvalidateTask removes all entries of the task, e.g. after its prompt template changed. It
+// returns the number of removed entries.
+func (c *Semantic) InvalidateTask(task string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := c.index.Delete(vectorindex.MetadataEquals(map[string]string{"task": task}))
+	for _, id := range ids {
+		if element, ok := c.entries[id]; ok {
+			c.order.Remove(element)
+			delete(c.entries, id)
+		}
+	}
+
+	return len(ids)
+}
+
+func (c *Semantic) thresholdOf(task string) float64 {
+	if threshold, ok := c.thresholds[task]; ok {
+		return threshold
+	}
+
+	return c.threshold
+}
+
+func (c *Semantic) remove(element *list.Element) {
+	entry := element.Value.(*semanticEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.id)
+	c.index.Remove(entry.id)
+}
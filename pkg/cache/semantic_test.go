@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/config"
+)
+
+// fakeEmbedder returns fixed vectors per text.
+type fakeEmbedder map[string][]float32
+
+func (e fakeEmbedder) Name() string { return "fake" }
+
+func (e fakeEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	vectors := make([][]float32, len(input))
+	for i, text := range input {
+		vectors[i] = e[text]
+	}
+	return vectors, nil
+}
+
+func TestSemanticCache(t *testing.T) {
+	embedder := fakeEmbedder{
+		"Who is Ada?":         {1, 0, 0},
+		"Who was Ada?":        {0.99, 0.1, 0},
+		"What is the weather": {0, 1, 0},
+	}
+	c := NewSemantic(embedder, SemanticConfig{Threshold: 0.9})
+
+	stored, err := c.Embed(context.Background(), "Who is Ada?")
+	require.NoError(t, err)
+	require.NoError(t, c.Store(SemanticScope{Task: "chat", ResponseSchema: "personResponse"}, stored, []byte("ada")))
+
+	similar, err := c.Embed(context.Background(), "Who was Ada?")
+	require.NoError(t, err)
+	value, ok := c.Lookup(SemanticScope{Task: "chat", ResponseSchema: "personResponse"}, similar)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("ada"), value)
+
+	// Entries are only shared within the same scope.
+	for _, scope := range []SemanticScope{
+		{Task: "summary", ResponseSchema: "personResponse"},
+		{Task: "chat", ResponseSchema: "otherResponse"},
+		{Task: "chat", ResponseSchema: "personResponse", Model: "other"},
+		{Task: "chat", ResponseSchema: "personResponse", TemplateVersion: "v2"},
+		{Task: "chat", ResponseSchema: "personResponse", Context: "other passages"},
+	} {
+		_, ok = c.Lookup(scope, similar)
+		assert.False(t, ok, "%+v", scope)
+	}
+
+	unrelated, err := c.Embed(context.Background(), "What is the weather")
+	require.NoError(t, err)
+	_, ok = c.Lookup(SemanticScope{Task: "chat", ResponseSchema: "personResponse"}, unrelated)
+	assert.False(t, ok)
+}
+
+func TestSemanticCacheThresholdPerTask(t *testing.T) {
+	c := NewSemantic(fakeEmbedder{}, SemanticConfig{
+		Threshold:  0.9,
+		Thresholds: map[string]float64{"strict": 0.999},
+	})
+	require.NoError(t, c.Store(SemanticScope{Task: "chat", ResponseSchema: "s"}, []float32{1, 0}, []byte("chat")))
+	require.NoError(t, c.Store(SemanticScope{Task: "strict", ResponseSchema: "s"}, []float32{1, 0}, []byte("strict")))
+
+	query := []float32{0.99, 0.1}
+	_, ok := c.Lookup(SemanticScope{Task: "chat", ResponseSchema: "s"}, query)
+	assert.True(t, ok)
+	_, ok = c.Lookup(SemanticScope{Task: "strict", ResponseSchema: "s"}, query)
+	assert.False(t, ok)
+}
+
+func TestSemanticCacheExpiresAndEvicts(t *testing.T) {
+	c := NewSemantic(fakeEmbedder{}, SemanticConfig{MaxEntries: 1, TTL: config.Duration(time.Minute)})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	require.NoError(t, c.Store(SemanticScope{Task: "chat", ResponseSchema: "s"}, []float32{1, 0}, []byte("first")))
+	require.NoError(t, c.Store(SemanticScope{Task: "chat", ResponseSchema: "s"}, []float32{0, 1}, []byte("second")))
+
+	_, ok := c.Lookup(SemanticScope{Task: "chat", ResponseSchema: "s"}, []float32{1, 0})
+	assert.False(t, ok, "oldest entry is evicted")
+	_, ok = c.Lookup(SemanticScope{Task: "chat", ResponseSchema: "s"}, []float32{0, 1})
+	assert.True(t, ok)
+
+	now = now.Add(2 * time.Minute)
+	_, ok = c.Lookup(SemanticScope{Task: "chat", ResponseSchema: "s"}, []float32{0, 1})
+	assert.False(t, ok, "entry is expired")
+}
+
+func TestSemanticCacheInvalidateTask(t *testing.T) {
+	c := NewSemantic(fakeEmbedder{}, SemanticConfig{})
+	require.NoError(t, c.Store(SemanticScope{Task: "chat", ResponseSchema: "s"}, []float32{1, 0}, []byte("chat")))
+	require.NoError(t, c.Store(SemanticScope{Task: "chat", ResponseSchema: "t"}, []float32{1, 0}, []byte("chat")))
+	require.NoError(t, c.Store(SemanticScope{Task: "summary", ResponseSchema: "s"}, []float32{1, 0}, []byte("summary")))
+
+	assert.Equal(t, 2, c.InvalidateTask("chat"))
+
+	_, ok := c.Lookup(SemanticScope{Task: "chat", ResponseSchema: "s"}, []float32{1, 0})
+	assert.False(t, ok)
+	_, ok = c.Lookup(SemanticScope{Task: "summary", ResponseSchema: "s"}, []float32{1, 0})
+	assert.True(t, ok)
+}
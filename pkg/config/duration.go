@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that is written as a string like "500ms" or "2m" in YAML files.
+type Duration time.Duration
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface of gopkg.in/yaml.v2.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return fmt.Errorf("duration must be a string like \"500ms\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("parsing duration: %w", err)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// Std returns the duration as time.Duration.
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// Or returns the duration or the fallback if the duration is not set.
+func (d Duration) Or(fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+
+	return time.Duration(d)
+}
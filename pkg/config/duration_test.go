@@ -0,0 +1,24 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDurationUnmarshalYAML(t *testing.T) {
+	var cfg struct {
+		Timeout Duration `yaml:"timeout"`
+		Missing Duration `yaml:"missing"`
+	}
+
+	err := yaml.Unmarshal([]byte(`timeout: "1m30s"`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, cfg.Timeout.Std())
+	assert.Equal(t, time.Second, cfg.Missing.Or(time.Second))
+
+	err = yaml.Unmarshal([]byte(`timeout: "soon"`), &cfg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/rag"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+)
+
+const maxDocumentBytes = 32 << 20
+
+// DocumentStore manages the documents of the retrieval index.
+type DocumentStore interface {
+	Add(ctx context.Context, documents []rag.Document) ([]rag.DocumentInfo, error)
+	Remove(id string) (bool, error)
+	Documents() []rag.DocumentInfo
+}
+
+// DocumentsPayload lists documents of the retrieval index.
+type DocumentsPayload struct {
+	Documents []rag.DocumentInfo `json:"documents"`
+}
+
+// formats maps content types of uploaded documents to their format.
+var formats = map[string]string{
+	"text/plain":           rag.FormatText,
+	"text/markdown":        rag.FormatMarkdown,
+	"application/jsonl":    rag.FormatJSONL,
+	"application/x-ndjson": rag.FormatJSONL,
+}
+
+// ListDocumentsHandler lists the documents of the retrieval index.
+func (h *Handler) ListDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireDocuments(w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DocumentsPayload{Documents: h.documents.Documents()})
+}
+
+// AddDocumentsHandler indexes the documents of the request body. The format is taken from the
+// format query parameter, the content type or the extension of the id query parameter. Text and
+// markdown are stored under the id, JSONL holds one document per line.
+func (h *Handler) AddDocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireDocuments(w) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	format := documentFormat(r, id)
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDocumentBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeRequestTooLarge, fmt.Sprintf("Document exceeds %d bytes", maxBytesErr.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid document: "+err.Error())
+		return
+	}
+
+	documents, err := rag.ParseDocuments(id, format, data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid document: "+err.Error())
+		return
+	}
+
+	infos, err := h.documents.Add(r.Context(), documents)
+	if err != nil {
+		var upstreamErr *transport.UpstreamError
+		if errors.As(err, &upstreamErr) {
+			writeServiceError(w, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to ingest documents: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DocumentsPayload{Documents: infos})
+}
+
+// RemoveDocumentHandler removes a document from the retrieval index.
+func (h *Handler) RemoveDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.requireDocuments(w) {
+		return
+	}
+
+	id := r.PathValue("id")
+	removed, err := h.documents.Remove(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Failed to remove document: "+err.Error())
+		return
+	}
+	if !removed {
+		writeError(w, http.StatusNotFound, codeNotFound, "Unknown document: "+id)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) requireDocuments(w http.ResponseWriter) bool {
+	if h.documents == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "Retrieval is not configured")
+		return false
+	}
+	return true
+}
+
+func documentFormat(r *http.Request, id string) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		if format, ok := formats[mediaType]; ok {
+			return format
+		}
+	}
+	return rag.FormatOf(id)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/rag"
+)
+
+// fakeDocumentStore keeps the documents in memory and records the documents of the last Add.
+type fakeDocumentStore struct {
+	documents []rag.DocumentInfo
+	added     []rag.Document
+}
+
+func (s *fakeDocumentStore) Add(ctx context.Context, documents []rag.Document) ([]rag.DocumentInfo, error) {
+	s.added = documents
+
+	infos := make([]rag.DocumentInfo, len(documents))
+	for i, document := range documents {
+		infos[i] = rag.DocumentInfo{ID: document.ID, Chunks: 1, Status: "added"}
+		s.documents = append(s.documents, rag.DocumentInfo{ID: document.ID, Chunks: 1})
+	}
+
+	return infos, nil
+}
+
+func (s *fakeDocumentStore) Remove(id string) (bool, error) {
+	i := slices.IndexFunc(s.documents, func(info rag.DocumentInfo) bool { return info.ID == id })
+	if i < 0 {
+		return false, nil
+	}
+	s.documents = slices.Delete(s.documents, i, i+1)
+
+	return true, nil
+}
+
+func (s *fakeDocumentStore) Documents() []rag.DocumentInfo {
+	return s.documents
+}
+
+// newTestMux serves the document endpoints with the routes of the app.
+func newTestMux(queryService QueryService, opts ...HandlerOption) *http.ServeMux {
+	h := NewHandler(queryService, opts...)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /documents", h.ListDocumentsHandler)
+	mux.HandleFunc("POST /documents", h.AddDocumentsHandler)
+	mux.HandleFunc("DELETE /documents/{id...}", h.RemoveDocumentHandler)
+
+	return mux
+}
+
+func serve(mux *http.ServeMux, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func TestListDocumentsHandler(t *testing.T) {
+	store := &fakeDocumentStore{documents: []rag.DocumentInfo{{ID: "handbook/vacation.md", Hash: "abc", Chunks: 3}}}
+	mux := newTestMux(&fakeQueryService{}, WithDocumentStore(store))
+
+	recorder := serve(mux, httptest.NewRequest(http.MethodGet, "/documents", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var payload DocumentsPayload
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&payload))
+	assert.Equal(t, store.documents, payload.Documents)
+}
+
+func TestDocumentsHandlersWithoutRetrieval(t *testing.T) {
+	mux := newTestMux(&fakeQueryService{})
+
+	recorder := serve(mux, httptest.NewRequest(http.MethodGet, "/documents", nil))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, codeNotFound, decodeError(t, recorder).Code)
+}
+
+func TestAddDocumentsHandlerFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		documents   []rag.Document
+	}{
+		{
+			name:        "format parameter wins over content type",
+			target:      "/documents?id=notes.md&format=text",
+			contentType: "application/jsonl",
+			body:        "# Notes",
+			documents:   []rag.Document{{ID: "notes.md", Text: "# Notes"}},
+		},
+		{
+			name:        "content type",
+			target:      "/documents?id=handbook/vacation",
+			contentType: "text/markdown; charset=utf-8",
+			body:        "# Vacation",
+			documents:   []rag.Document{{ID: "handbook/vacation", Text: "# Vacation"}},
+		},
+		{
+			name:      "extension of the id",
+			target:    "/documents?id=notes.txt",
+			body:      "Some notes",
+			documents: []rag.Document{{ID: "notes.txt", Text: "Some notes"}},
+		},
+		{
+			name:        "jsonl",
+			target:      "/documents",
+			contentType: "application/x-ndjson",
+			body:        `{"id": "faq/1", "text": "First"}` + "\n" + `{"id": "faq/2", "text": "Second", "metadata": {"team": "ops"}}` + "\n",
+			documents: []rag.Document{
+				{ID: "faq/1", Text: "First"},
+				{ID: "faq/2", Text: "Second", Metadata: map[string]string{"team": "ops"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeDocumentStore{}
+			mux := newTestMux(&fakeQueryService{}, WithDocumentStore(store))
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			recorder := serve(mux, req)
+
+			require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+			assert.Equal(t, tt.documents, store.added)
+			var payload DocumentsPayload
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&payload))
+			assert.Len(t, payload.Documents, len(tt.documents))
+		})
+	}
+}
+
+func TestAddDocumentsHandlerInvalidDocument(t *testing.T) {
+	store := &fakeDocumentStore{}
+	mux := newTestMux(&fakeQueryService{}, WithDocumentStore(store))
+
+	// Without format, content type or extension the format is unknown.
+	recorder := serve(mux, httptest.NewRequest(http.MethodPost, "/documents?id=notes", strings.NewReader("Some notes")))
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, codeInvalidRequest, decodeError(t, recorder).Code)
+	assert.Nil(t, store.added)
+}
+
+func TestAddDocumentsHandlerTooLarge(t *testing.T) {
+	store := &fakeDocumentStore{}
+	mux := newTestMux(&fakeQueryService{}, WithDocumentStore(store))
+
+	body := bytes.NewReader(make([]byte, maxDocumentBytes+1))
+	recorder := serve(mux, httptest.NewRequest(http.MethodPost, "/documents?id=notes.txt", body))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	assert.Equal(t, codeRequestTooLarge, decodeError(t, recorder).Code)
+	assert.Nil(t, store.added)
+}
+
+func TestRemoveDocumentHandler(t *testing.T) {
+	store := &fakeDocumentStore{documents: []rag.DocumentInfo{{ID: "handbook/vacation.md", Chunks: 3}}}
+	mux := newTestMux(&fakeQueryService{}, WithDocumentStore(store))
+
+	// Ids may contain slashes.
+	recorder := serve(mux, httptest.NewRequest(http.MethodDelete, "/documents/handbook/vacation.md", nil))
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Empty(t, store.documents)
+
+	recorder = serve(mux, httptest.NewRequest(http.MethodDelete, "/documents/handbook/vacation.md", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	details := decodeError(t, recorder)
+	assert.Equal(t, codeNotFound, details.Code)
+	assert.Equal(t, "Unknown document: handbook/vacation.md", details.Message)
+}
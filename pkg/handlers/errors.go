@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/guard"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+)
+
+// Error codes of the error envelope.
+const (
+	codeInvalidRequest      = "invalid_request"
+	codeRequestTooLarge     = "request_too_large"
+	codePromptTooLong       = "prompt_too_long"
+	codeNotFound            = "not_found"
+	codeInputBlocked        = "input_blocked"
+	codeOutputBlocked       = "output_blocked"
+	codeInvalidResponse     = "invalid_response"
+	codeUpstreamUnavailable = "upstream_unavailable"
+	codeUpstreamError       = "upstream_error"
+	codeOverloaded          = "overloaded"
+	codeInternal            = "internal_error"
+)
+
+// ErrorPayload is the envelope of all error responses.
+type ErrorPayload struct {
+	Error ErrorDetails `json:"error"`
+}
+
+type ErrorDetails struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Verdicts are the guard verdicts that blocked the request.
+	Verdicts []guard.Verdict `json:"verdicts,omitempty"`
+	// Violations are the schema violations of an invalid request or response.
+	Violations []validation.Violation `json:"violations,omitempty"`
+	// RequiredTokens and AvailableTokens tell by how much a prompt exceeds the context window.
+	RequiredTokens  int `json:"requiredTokens,omitempty"`
+	AvailableTokens int `json:"availableTokens,omitempty"`
+}
+
+// writeError writes the error envelope with the given status.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeErrorDetails(w, status, ErrorDetails{
+		Code:    code,
+		Message: message,
+	})
+}
+
+// writeErrorDetails writes the error envelope with the given status and details.
+func writeErrorDetails(w http.ResponseWriter, status int, details ErrorDetails) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorPayload{
+		Error: details,
+	})
+}
+
+// writeRequestError writes the error of an invalid request. Schema violations are listed in the same
+// format as those of invalid responses.
+func writeRequestError(w http.ResponseWriter, status int, err error) {
+	code := codeInvalidRequest
+	if status == http.StatusRequestEntityTooLarge {
+		code = codeRequestTooLarge
+	}
+
+	details := ErrorDetails{
+		Code:    code,
+		Message: err.Error(),
+	}
+	var validationErr *validation.ValidationError
+	if errors.As(err, &validationErr) {
+		details.Violations = validationErr.Violations
+	}
+
+	writeErrorDetails(w, status, details)
+}
+
+// writeServiceError maps an error of the query service to its status and error code.
+func writeServiceError(w http.ResponseWriter, err error) {
+	var blockedErr *guard.BlockedError
+	if errors.As(err, &blockedErr) {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, ErrorDetails{
+			Code:     codeInputBlocked,
+			Message:  err.Error(),
+			Verdicts: blockedErr.Verdicts,
+		})
+		return
+	}
+
+	var outputBlockedErr *guard.OutputBlockedError
+	if errors.As(err, &outputBlockedErr) {
+		writeErrorDetails(w, http.StatusUnprocessableEntity, ErrorDetails{
+			Code:     codeOutputBlocked,
+			Message:  err.Error(),
+			Verdicts: outputBlockedErr.Verdicts,
+		})
+		return
+	}
+
+	// The model kept answering with responses that do not match the schema.
+	var validationErr *validation.ValidationError
+	if errors.As(err, &validationErr) {
+		writeErrorDetails(w, http.StatusBadGateway, ErrorDetails{
+			Code:       codeInvalidResponse,
+			Message:    err.Error(),
+			Violations: validationErr.Violations,
+		})
+		return
+	}
+
+	var unavailableErr *model.UnavailableError
+	if errors.As(err, &unavailableErr) {
+		setRetryAfter(w, unavailableErr.RetryAfter)
+		writeError(w, http.StatusServiceUnavailable, codeUpstreamUnavailable, err.Error())
+		return
+	}
+
+	var overloadedErr *model.OverloadedError
+	if errors.As(err, &overloadedErr) {
+		status := http.StatusServiceUnavailable
+		if errors.Is(err, model.ErrQueueFull) {
+			status = http.StatusTooManyRequests
+		}
+		setRetryAfter(w, overloadedErr.RetryAfter)
+		writeError(w, status, codeOverloaded, err.Error())
+		return
+	}
+
+	var upstreamErr *transport.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		status := http.StatusBadGateway
+		if upstreamErr.Kind == transport.KindTimeout {
+			status = http.StatusGatewayTimeout
+		}
+		if upstreamErr.RetryAfter > 0 {
+			setRetryAfter(w, upstreamErr.RetryAfter)
+		}
+		writeError(w, status, codeUpstreamError, err.Error())
+		return
+	}
+
+	// The prompt does not fit into the context window of the model, even after trimming it.
+	var budgetErr *prompt.BudgetError
+	if errors.As(err, &budgetErr) {
+		writeErrorDetails(w, http.StatusRequestEntityTooLarge, ErrorDetails{
+			Code:            codePromptTooLong,
+			Message:         err.Error(),
+			RequiredTokens:  budgetErr.Required,
+			AvailableTokens: budgetErr.Available,
+		})
+		return
+	}
+
+	writeError(w, http.StatusInternalServerError, codeInternal, "Failed to process prompt: "+err.Error())
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, at least one.
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+}
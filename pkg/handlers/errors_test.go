@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+)
+
+// decodeError decodes the error envelope of the recorded response.
+func decodeError(t *testing.T, recorder *httptest.ResponseRecorder) ErrorDetails {
+	t.Helper()
+
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var payload ErrorPayload
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&payload))
+
+	return payload.Error
+}
+
+func TestWriteServiceErrorRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		code       string
+		retryAfter string
+	}{
+		{
+			name:       "circuit open",
+			err:        &model.UnavailableError{Model: "local", RetryAfter: 1500 * time.Millisecond, Err: fmt.Errorf("circuit is open")},
+			status:     http.StatusServiceUnavailable,
+			code:       codeUpstreamUnavailable,
+			retryAfter: "2",
+		},
+		{
+			name:       "queue timeout",
+			err:        &model.OverloadedError{Model: "local", RetryAfter: 5 * time.Second, Err: model.ErrQueueTimeout},
+			status:     http.StatusServiceUnavailable,
+			code:       codeOverloaded,
+			retryAfter: "5",
+		},
+		{
+			name:       "queue full",
+			err:        fmt.Errorf("calling model: %w", &model.OverloadedError{Model: "local", RetryAfter: time.Second, Err: model.ErrQueueFull}),
+			status:     http.StatusTooManyRequests,
+			code:       codeOverloaded,
+			retryAfter: "1",
+		},
+		{
+			name:       "upstream asked to wait",
+			err:        &transport.UpstreamError{Kind: transport.KindRateLimited, StatusCode: 429, Attempts: 1, RetryAfter: 2 * time.Minute},
+			status:     http.StatusBadGateway,
+			code:       codeUpstreamError,
+			retryAfter: "120",
+		},
+		{
+			name:   "upstream timeout",
+			err:    &transport.UpstreamError{Kind: transport.KindTimeout, Attempts: 3},
+			status: http.StatusGatewayTimeout,
+			code:   codeUpstreamError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			writeServiceError(recorder, tt.err)
+
+			assert.Equal(t, tt.status, recorder.Code)
+			assert.Equal(t, tt.retryAfter, recorder.Header().Get("Retry-After"))
+			details := decodeError(t, recorder)
+			assert.Equal(t, tt.code, details.Code)
+			assert.Equal(t, tt.err.Error(), details.Message)
+		})
+	}
+}
+
+func TestWriteServiceErrorInvalidResponse(t *testing.T) {
+	err := fmt.Errorf("validating response: %w", &validation.ValidationError{
+		Schema: "personResponse",
+		Violations: []validation.Violation{
+			{Pointer: "/age", Value: float64(200), Constraint: "<=130", Message: "invalid value 200 (out of bound <=130)"},
+			{Pointer: "/name", Constraint: "string", Message: "field is required but not present"},
+		},
+	})
+
+	recorder := httptest.NewRecorder()
+	writeServiceError(recorder, err)
+
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Retry-After"))
+	details := decodeError(t, recorder)
+	assert.Equal(t, codeInvalidResponse, details.Code)
+	assert.Equal(t, err.Error(), details.Message)
+	assert.Equal(t, []validation.Violation{
+		{Pointer: "/age", Value: float64(200), Constraint: "<=130", Message: "invalid value 200 (out of bound <=130)"},
+		{Pointer: "/name", Constraint: "string", Message: "field is required but not present"},
+	}, details.Violations)
+}
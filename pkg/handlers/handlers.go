@@ -1,74 +1,262 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-)
 
-type RequestPayload struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-}
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/guard"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation/schematypes"
+	"github.com/yreinhar/llm-go-blueprint/pkg/service"
+)
 
 type ResponsePayload struct {
-	Response string `json:"response"`
+	Response      string   `json:"response"`
+	Model         string   `json:"model,omitempty"`
+	PromptVersion string   `json:"promptVersion,omitempty"`
+	Cached        bool     `json:"cached,omitempty"`
+	Citations     []string `json:"citations,omitempty"`
+	// InputVerdicts are the verdicts of the input guards that flagged the prompt.
+	InputVerdicts []guard.Verdict `json:"inputVerdicts,omitempty"`
+	// OutputVerdicts are the verdicts of the output guards that flagged the response.
+	OutputVerdicts []guard.Verdict `json:"outputVerdicts,omitempty"`
+	// Repairs are what was repaired in the model output before validation, e.g. fences.
+	Repairs []string `json:"repairs,omitempty"`
 }
 
 // QueryService defines the interface for processing model prompts.
 // Implementations handle the actual interaction with language models.
 type QueryService interface {
-	ProcessPrompt(prompt, schemaType, task string) (string, error)
+	ProcessPrompt(ctx context.Context, prompt, schemaType, task string, opts ...service.QueryOption) (*service.Result, error)
+	Backends() []model.BackendStatus
+	InvalidateCache(task string) int
 }
 
 // Handler manages HTTP request processing and coordinates with the query service.
 // It encapsulates all the dependencies needed for handling HTTP requests.
 type Handler struct {
 	queryService QueryService
+	documents    DocumentStore
+	requests     *validation.RequestSchemaValidator
+	tasks        map[string]string // response schema by task
+	defaultTask  string
+}
+
+// HandlerOption represents a handler configuration option
+type HandlerOption func(*Handler)
+
+// WithDocumentStore enables the endpoints that manage the documents of the retrieval index.
+func WithDocumentStore(store DocumentStore) HandlerOption {
+	return func(h *Handler) {
+		h.documents = store
+	}
+}
+
+// WithRequestValidator validates the size and schema of query payloads and the template variables of
+// the task before the query service is called.
+func WithRequestValidator(validator *validation.RequestSchemaValidator) HandlerOption {
+	return func(h *Handler) {
+		h.requests = validator
+	}
+}
+
+// WithTasks sets the tasks /query serves with the response schema of each, and the task of payloads
+// without one.
+func WithTasks(tasks map[string]string, defaultTask string) HandlerOption {
+	return func(h *Handler) {
+		h.tasks = tasks
+		h.defaultTask = defaultTask
+	}
 }
 
 const (
-	// schema type to validate the llm response against
+	// schema to validate the query payload against
+	querySchema = "queryRequest"
+	// task served without WithTasks and the schema type to validate its llm response against
 	schemaTypeToValidateAgainst = "personResponse"
 	task                        = "chat"
 )
 
 // NewHandler creates a new handler instance with the provided query service.
 // It initializes the handler with all required dependencies for processing requests.
-func NewHandler(queryService QueryService) *Handler {
-	return &Handler{
+func NewHandler(queryService QueryService, opts ...HandlerOption) *Handler {
+	h := &Handler{
 		queryService: queryService,
+		tasks:        map[string]string{task: schemaTypeToValidateAgainst},
+		defaultTask:  task,
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+
+	return h
 }
 
 // CallModelHandler handles the REST API call for calling a model.
 func (h *Handler) CallModelHandler(w http.ResponseWriter, r *http.Request) {
-	var payload RequestPayload
-	err := json.NewDecoder(r.Body).Decode(&payload)
-	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	payload, ok := h.decodeQuery(w, r)
+	if !ok {
 		return
 	}
 
-	payloadString := fmt.Sprintf("\n Model: %s \n Prompt: %s \n", payload.Model, payload.Prompt)
-
-	w.Write([]byte(payloadString))
+	var opts []service.QueryOption
+	if payload.SelectionKey != nil {
+		opts = append(opts, service.WithSelectionKey(*payload.SelectionKey))
+	}
+	if payload.NoCache != nil && *payload.NoCache {
+		opts = append(opts, service.WithCacheBypass())
+	}
 
-	response, err := h.queryService.ProcessPrompt(payload.Prompt, schemaTypeToValidateAgainst, task)
+	result, err := h.queryService.ProcessPrompt(
+		r.Context(),
+		payload.Prompt,
+		h.tasks[*payload.Task],
+		*payload.Task,
+		opts...,
+	)
 	if err != nil {
-		http.Error(w, "Failed to process prompt: "+err.Error(), http.StatusInternalServerError)
+		writeServiceError(w, err)
 		return
 	}
 
 	jsonResponse := ResponsePayload{
-		Response: response,
+		Response:       result.Response,
+		Model:          result.Model,
+		PromptVersion:  result.PromptVersion,
+		Cached:         result.Cached,
+		Citations:      result.Citations,
+		InputVerdicts:  result.InputVerdicts,
+		OutputVerdicts: result.OutputVerdicts,
+		Repairs:        result.Repairs,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(jsonResponse)
 }
 
+// decodeQuery reads the query payload and writes the error response if it is invalid. The task of the
+// payload is set to the default task if empty and has to be one of the served tasks. With a request
+// validator, the body is limited in size and validated against its schema, and the template variables
+// of the task against theirs.
+func (h *Handler) decodeQuery(w http.ResponseWriter, r *http.Request) (schematypes.QueryRequest, bool) {
+	var payload schematypes.QueryRequest
+	if h.requests == nil {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request payload")
+			return payload, false
+		}
+		return payload, h.resolveTask(w, &payload)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.requests.MaxBytes()))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeRequestError(w, http.StatusRequestEntityTooLarge, validation.TooLargeError(querySchema, maxBytesErr.Limit))
+			return payload, false
+		}
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request payload")
+		return payload, false
+	}
+
+	if err := h.requests.Validate(querySchema, body); err != nil {
+		writeRequestError(w, http.StatusBadRequest, err)
+		return payload, false
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request payload")
+		return payload, false
+	}
+	if !h.resolveTask(w, &payload) {
+		return payload, false
+	}
+
+	variables := map[string]any{prompt.VariableInput: payload.Prompt}
+	if err := h.requests.ValidateVariables(*payload.Task, variables); err != nil {
+		writeRequestError(w, http.StatusBadRequest, err)
+		return payload, false
+	}
+
+	return payload, true
+}
+
+// resolveTask sets the default task if the payload has none and writes the error response if the task
+// is not served.
+func (h *Handler) resolveTask(w http.ResponseWriter, payload *schematypes.QueryRequest) bool {
+	if payload.Task == nil || *payload.Task == "" {
+		defaultTask := h.defaultTask
+		payload.Task = &defaultTask
+	}
+	if _, ok := h.tasks[*payload.Task]; !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Unknown task: %s", *payload.Task))
+		return false
+	}
+
+	return true
+}
+
+// InvalidateCachePayload reports the cached responses removed for a task.
+type InvalidateCachePayload struct {
+	Task    string `json:"task"`
+	Removed int    `json:"removed"`
+}
+
+// InvalidateCacheHandler removes the cached responses of the task given as query parameter, e.g.
+// after its prompt template changed.
+func (h *Handler) InvalidateCacheHandler(w http.ResponseWriter, r *http.Request) {
+	task := r.URL.Query().Get("task")
+	if task == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "Missing task")
+		return
+	}
+
+	removed := h.queryService.InvalidateCache(task)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InvalidateCachePayload{
+		Task:    task,
+		Removed: removed,
+	})
+}
+
+// ReadinessPayload lists the health of all model backends.
+type ReadinessPayload struct {
+	Ready    bool                  `json:"ready"`
+	Backends []model.BackendStatus `json:"backends"`
+}
+
+// HandleReady reports whether requests can be served. It is not ready if the circuits of all
+// model backends are open.
+func (h *Handler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	backends := h.queryService.Backends()
+
+	ready := len(backends) == 0
+	for _, backend := range backends {
+		if backend.State != model.StateOpen.String() {
+			ready = true
+		}
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ReadinessPayload{
+		Ready:    ready,
+		Backends: backends,
+	})
+}
+
 func (h *Handler) HandleHelloWorld(w http.ResponseWriter, r *http.Request) {
 	_ = r.Body
 	log.Println("Received a non domain request")
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/service"
+)
+
+// fakeQueryService stands in for the query service and its semantic cache, which holds the given
+// number of cached responses per task.
+type fakeQueryService struct {
+	cached map[string]int
+}
+
+func (s *fakeQueryService) ProcessPrompt(ctx context.Context, prompt, schemaType, task string, opts ...service.QueryOption) (*service.Result, error) {
+	return nil, nil
+}
+
+func (s *fakeQueryService) Backends() []model.BackendStatus {
+	return nil
+}
+
+func (s *fakeQueryService) InvalidateCache(task string) int {
+	removed := s.cached[task]
+	delete(s.cached, task)
+
+	return removed
+}
+
+func TestInvalidateCacheHandler(t *testing.T) {
+	queryService := &fakeQueryService{cached: map[string]int{"extractPerson": 3, "summarize": 1}}
+	h := NewHandler(queryService)
+
+	recorder := httptest.NewRecorder()
+	h.InvalidateCacheHandler(recorder, httptest.NewRequest(http.MethodDelete, "/cache?task=extractPerson", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var payload InvalidateCachePayload
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&payload))
+	assert.Equal(t, InvalidateCachePayload{Task: "extractPerson", Removed: 3}, payload)
+	assert.Equal(t, map[string]int{"summarize": 1}, queryService.cached)
+
+	// Invalidating again finds nothing.
+	recorder = httptest.NewRecorder()
+	h.InvalidateCacheHandler(recorder, httptest.NewRequest(http.MethodDelete, "/cache?task=extractPerson", nil))
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&payload))
+	assert.Equal(t, InvalidateCachePayload{Task: "extractPerson", Removed: 0}, payload)
+}
+
+func TestInvalidateCacheHandlerMissingTask(t *testing.T) {
+	queryService := &fakeQueryService{cached: map[string]int{"extractPerson": 3}}
+	h := NewHandler(queryService)
+
+	recorder := httptest.NewRecorder()
+	h.InvalidateCacheHandler(recorder, httptest.NewRequest(http.MethodDelete, "/cache", nil))
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	details := decodeError(t, recorder)
+	assert.Equal(t, codeInvalidRequest, details.Code)
+	assert.Equal(t, "Missing task", details.Message)
+	assert.Equal(t, map[string]int{"extractPerson": 3}, queryService.cached)
+}
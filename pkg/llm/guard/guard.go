@@ -0,0 +1,127 @@
+// Package guard checks the input of a request before it reaches the model and the response after it
+// passed schema validation. Guards are chained, each one allows, flags or blocks the input or response.
+// Input guards may rewrite the input, e.g. to escape delimiters.
+package guard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+var inputVerdicts = metrics.NewCounter("input_guard_verdicts_total")
+
+// ErrBlocked is returned if a guard blocks the input.
+var ErrBlocked = errors.New("input blocked by guard")
+
+// Action is the decision of a guard.
+type Action string
+
+const (
+	// ActionAllow passes the input on.
+	ActionAllow Action = "allow"
+	// ActionFlag passes the input on and reports the reason with the response.
+	ActionFlag Action = "flag"
+	// ActionBlock rejects the request before the model is called.
+	ActionBlock Action = "block"
+)
+
+// parseAction returns the action of a config value, def if it is empty.
+func parseAction(value string, def Action) (Action, error) {
+	switch Action(value) {
+	case "":
+		return def, nil
+	case ActionAllow, ActionFlag, ActionBlock:
+		return Action(value), nil
+	}
+	return "", fmt.Errorf("unknown guard action: %s", value)
+}
+
+// Verdict is the decision of a guard with its reasons.
+type Verdict struct {
+	Guard   string   `json:"guard"`
+	Action  Action   `json:"action"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Input is the user input checked by the guards.
+type Input struct {
+	Task string
+	// Text is the user input. Guards may rewrite it, later guards and the prompt see the rewritten text.
+	Text string
+}
+
+// InputGuard checks the user input before the prompt is built.
+type InputGuard interface {
+	Name() string
+	Check(ctx context.Context, input *Input) (Verdict, error)
+}
+
+// BlockedError is returned if a guard blocks the input. It wraps ErrBlocked.
+type BlockedError struct {
+	// Verdicts are the verdicts of the guards that flagged or blocked the input.
+	Verdicts []Verdict
+}
+
+func (e *BlockedError) Error() string {
+	blocking := e.Verdicts[len(e.Verdicts)-1]
+	return fmt.Sprintf("%s: %s: %s", ErrBlocked, blocking.Guard, strings.Join(blocking.Reasons, "; "))
+}
+
+func (e *BlockedError) Unwrap() error {
+	return ErrBlocked
+}
+
+// InputChain runs input guards in order.
+type InputChain struct {
+	guards []InputGuard
+}
+
+// NewInputChain creates a chain of the guards.
+func NewInputChain(guards ...InputGuard) *InputChain {
+	return &InputChain{
+		guards: guards,
+	}
+}
+
+// Check runs the guards on the input and returns the possibly rewritten input together with the
+// verdicts that flagged it. The chain stops at the first guard that blocks the input, its error is
+// a *BlockedError. A guard that fails stops the chain as well.
+func (c *InputChain) Check(ctx context.Context, task, text string) (string, []Verdict, error) {
+	input := &Input{Task: task, Text: text}
+
+	var verdicts []Verdict
+	for _, guard := range c.guards {
+		verdict, err := guard.Check(ctx, input)
+		if err != nil {
+			return "", nil, fmt.Errorf("input guard %s: %w", guard.Name(), err)
+		}
+		if verdict.Action == "" {
+			verdict.Action = ActionAllow
+		}
+		verdict.Guard = guard.Name()
+		inputVerdicts.Inc(task, verdict.Guard, string(verdict.Action))
+
+		if verdict.Action == ActionAllow {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"task":    task,
+			"guard":   verdict.Guard,
+			"action":  verdict.Action,
+			"reasons": verdict.Reasons,
+		}).Warn("Input guard verdict")
+
+		verdicts = append(verdicts, verdict)
+		if verdict.Action == ActionBlock {
+			return "", verdicts, &BlockedError{Verdicts: verdicts}
+		}
+	}
+
+	return input.Text, verdicts, nil
+}
@@ -0,0 +1,66 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticGuard struct {
+	name    string
+	verdict Verdict
+	err     error
+	calls   int
+}
+
+func (g *staticGuard) Name() string { return g.name }
+
+func (g *staticGuard) Check(ctx context.Context, input *Input) (Verdict, error) {
+	g.calls++
+	input.Text += "!"
+	return g.verdict, g.err
+}
+
+func TestInputChainFlags(t *testing.T) {
+	chain := NewInputChain(
+		&staticGuard{name: "a", verdict: Verdict{Action: ActionAllow}},
+		&staticGuard{name: "b", verdict: Verdict{Action: ActionFlag, Reasons: []string{"suspicious"}}},
+		&staticGuard{name: "c"},
+	)
+
+	text, verdicts, err := chain.Check(context.Background(), "chat", "hi")
+	require.NoError(t, err)
+	// Every guard sees the text rewritten by the previous ones.
+	assert.Equal(t, "hi!!!", text)
+	assert.Equal(t, []Verdict{{Guard: "b", Action: ActionFlag, Reasons: []string{"suspicious"}}}, verdicts)
+}
+
+func TestInputChainBlocks(t *testing.T) {
+	last := &staticGuard{name: "c"}
+	chain := NewInputChain(
+		&staticGuard{name: "a", verdict: Verdict{Action: ActionFlag, Reasons: []string{"suspicious"}}},
+		&staticGuard{name: "b", verdict: Verdict{Action: ActionBlock, Reasons: []string{"forbidden"}}},
+		last,
+	)
+
+	_, verdicts, err := chain.Check(context.Background(), "chat", "hi")
+	require.ErrorIs(t, err, ErrBlocked)
+	assert.EqualError(t, err, "input blocked by guard: b: forbidden")
+	assert.Len(t, verdicts, 2)
+	assert.Zero(t, last.calls)
+
+	var blockedErr *BlockedError
+	require.ErrorAs(t, err, &blockedErr)
+	assert.Equal(t, verdicts, blockedErr.Verdicts)
+}
+
+func TestInputChainGuardError(t *testing.T) {
+	chain := NewInputChain(&staticGuard{name: "a", err: errors.New("boom")})
+
+	_, _, err := chain.Check(context.Background(), "chat", "hi")
+	assert.EqualError(t, err, "input guard a: boom")
+	assert.NotErrorIs(t, err, ErrBlocked)
+}
@@ -0,0 +1,92 @@
+package guard
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+)
+
+const judgeInstructions = `You review the response of another assistant against a rubric. ` +
+	`The user message contains the rubric and the response. Judge only whether the response meets the rubric. ` +
+	`Respond only with a JSON object {"pass": true or false, "reason": "<one sentence>"}.`
+
+// JudgeCheck asks a model whether the response meets a rubric.
+type JudgeCheck struct {
+	llm      model.Llm
+	rubric   string
+	action   Action
+	failOpen bool
+}
+
+type judgement struct {
+	Pass   *bool  `json:"pass"`
+	Reason string `json:"reason"`
+}
+
+// NewJudgeCheck creates a check that calls the configured model of the definitions.
+func NewJudgeCheck(cfg prompt.JudgeCheck, definitions *model.Definitions) (*JudgeCheck, error) {
+	llm, err := definitions.NewLlm(cfg.Model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create judge model: %w", err)
+	}
+
+	action, err := parseAction(cfg.Action, ActionBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJudgeCheckWithModel(llm, cfg.Rubric, action, cfg.FailOpen), nil
+}
+
+// NewJudgeCheckWithModel creates a check that calls the given model.
+func NewJudgeCheckWithModel(llm model.Llm, rubric string, action Action, failOpen bool) *JudgeCheck {
+	return &JudgeCheck{
+		llm:      llm,
+		rubric:   rubric,
+		action:   action,
+		failOpen: failOpen,
+	}
+}
+
+func (c *JudgeCheck) Name() string {
+	return "judge"
+}
+
+func (c *JudgeCheck) Check(ctx context.Context, output *Output) (Verdict, error) {
+	result, err := c.judge(ctx, output.Response)
+	if err != nil {
+		if !c.failOpen {
+			return Verdict{}, err
+		}
+		log.WithField("model", c.llm.Name()).Warnf("Judge failed, response is flagged: %v", err)
+		return Verdict{Action: ActionFlag, Reasons: []string{"judge failed: " + err.Error()}}, nil
+	}
+
+	if *result.Pass {
+		return Verdict{Action: ActionAllow}, nil
+	}
+
+	reason := "response does not meet the rubric"
+	if result.Reason != "" {
+		reason += ": " + result.Reason
+	}
+
+	return Verdict{Action: c.action, Reasons: []string{reason}}, nil
+}
+
+func (c *JudgeCheck) judge(ctx context.Context, response string) (judgement, error) {
+	text := fmt.Sprintf("Rubric:\n%s\n\nResponse:\n%s", c.rubric, response)
+
+	var result judgement
+	if err := ask(ctx, c.llm, judgeInstructions, text, "judgement", &result); err != nil {
+		return judgement{}, err
+	}
+	if result.Pass == nil {
+		return judgement{}, fmt.Errorf("judge %s did not answer pass", c.llm.Name())
+	}
+
+	return result, nil
+}
@@ -0,0 +1,34 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJudgeCheck(t *testing.T) {
+	llm := &fakeClassifier{response: `{"pass":false,"reason":"too informal"}`}
+	check := NewJudgeCheckWithModel(llm, "The answer is polite.", ActionBlock, false)
+
+	verdict := checkOutput(t, check, `{"answer":"yo"}`)
+	assert.Equal(t, Verdict{Action: ActionBlock, Reasons: []string{"response does not meet the rubric: too informal"}}, verdict)
+	require.Len(t, llm.request.Messages, 2)
+	assert.Equal(t, "Rubric:\nThe answer is polite.\n\nResponse:\n{\"answer\":\"yo\"}", llm.request.Messages[1].Content)
+
+	llm.response = `{"pass":true}`
+	assert.Equal(t, ActionAllow, checkOutput(t, check, `{"answer":"Hello"}`).Action)
+}
+
+func TestJudgeCheckFailure(t *testing.T) {
+	llm := &fakeClassifier{response: `{"reason":"unsure"}`}
+
+	_, err := NewJudgeCheckWithModel(llm, "rubric", ActionBlock, false).Check(context.Background(), &Output{Response: "hi"})
+	assert.EqualError(t, err, "judge classifier did not answer pass")
+
+	llm.err = errors.New("unavailable")
+	verdict := checkOutput(t, NewJudgeCheckWithModel(llm, "rubric", ActionBlock, true), "hi")
+	assert.Equal(t, Verdict{Action: ActionFlag, Reasons: []string{"judge failed: unavailable"}}, verdict)
+}
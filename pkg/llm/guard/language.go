@@ -0,0 +1,83 @@
+package guard
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// stopwords are frequent words of each supported language by ISO 639-1 code. A word shared by several
+// languages counts for each of them.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "of", "to", "with", "that", "this", "it", "for", "you", "not", "have", "be", "from", "by", "which", "will"},
+	"de": {"der", "die", "das", "und", "ist", "sind", "nicht", "mit", "ein", "eine", "zu", "von", "auf", "für", "sie", "ich", "auch", "dem", "den", "wird"},
+	"fr": {"le", "la", "les", "et", "est", "sont", "des", "une", "pour", "pas", "avec", "que", "qui", "dans", "sur", "vous", "nous", "ce", "du", "au"},
+	"es": {"el", "los", "las", "y", "es", "son", "una", "para", "con", "que", "por", "del", "como", "pero", "más", "está", "su", "al", "lo", "se"},
+	"it": {"il", "gli", "e", "è", "sono", "una", "per", "con", "che", "non", "della", "del", "di", "da", "nel", "questo", "ma", "come", "anche", "alla"},
+	"nl": {"de", "het", "een", "en", "is", "zijn", "niet", "met", "van", "op", "voor", "dat", "die", "ook", "wordt", "maar", "bij", "naar", "er", "om"},
+	"pt": {"o", "os", "as", "e", "é", "são", "uma", "para", "com", "que", "não", "do", "da", "em", "um", "por", "mais", "como", "mas", "seu"},
+}
+
+const (
+	// minLanguageWords is the number of words below which the language of a text is not detected.
+	minLanguageWords = 5
+	// minLanguageHits is the number of stopwords the detected language needs at least.
+	minLanguageHits = 2
+)
+
+// detectLanguage returns the language whose stopwords occur most often in the text, or an empty
+// string if the text is too short or matches no language clearly.
+func detectLanguage(text string) string {
+	words := strings.Fields(normalize(text))
+	if len(words) < minLanguageWords {
+		return ""
+	}
+
+	counts := make(map[string]int)
+	for _, word := range words {
+		for language, list := range stopwords {
+			if slices.Contains(list, word) {
+				counts[language]++
+			}
+		}
+	}
+
+	best, bestCount, tie := "", 0, false
+	for language, count := range counts {
+		switch {
+		case count > bestCount:
+			best, bestCount, tie = language, count, false
+		case count == bestCount:
+			tie = true
+		}
+	}
+	if bestCount < minLanguageHits || tie {
+		return ""
+	}
+
+	return best
+}
+
+// LanguageCheck rejects responses in languages that are not allowed. Responses whose language cannot
+// be detected, e.g. because they are short, pass.
+type LanguageCheck struct {
+	Allow  []string
+	Action Action
+}
+
+func (c *LanguageCheck) Name() string {
+	return "language"
+}
+
+func (c *LanguageCheck) Check(ctx context.Context, output *Output) (Verdict, error) {
+	language := detectLanguage(output.Text)
+	if language == "" || slices.Contains(c.Allow, language) {
+		return Verdict{Action: ActionAllow}, nil
+	}
+
+	return Verdict{
+		Action:  c.Action,
+		Reasons: []string{fmt.Sprintf("response is in %s, allowed are %s", language, strings.Join(c.Allow, ", "))},
+	}, nil
+}
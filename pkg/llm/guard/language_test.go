@@ -0,0 +1,35 @@
+package guard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"The order was shipped and it will arrive with the next delivery.", "en"},
+		{"Die Bestellung ist unterwegs und wird mit der nächsten Lieferung ankommen.", "de"},
+		{"La commande est en route et elle arrive avec la prochaine livraison.", "fr"},
+		{"El pedido está en camino y llega con la próxima entrega.", "es"},
+		{"Order shipped.", ""},
+		{"12345 67890 12345 67890 12345", ""},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, detectLanguage(test.text), test.text)
+	}
+}
+
+func TestLanguageCheck(t *testing.T) {
+	check := &LanguageCheck{Allow: []string{"en"}, Action: ActionFlag}
+
+	assert.Equal(t, ActionAllow, checkOutput(t, check, `{"answer":"The order was shipped and it will arrive soon."}`).Action)
+	assert.Equal(t, ActionAllow, checkOutput(t, check, `{"answer":"Ok"}`).Action)
+	assert.Equal(t, Verdict{
+		Action:  ActionFlag,
+		Reasons: []string{"response is in de, allowed are en"},
+	}, checkOutput(t, check, `{"answer":"Die Bestellung ist unterwegs und wird bald ankommen."}`))
+}
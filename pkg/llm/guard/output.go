@@ -0,0 +1,381 @@
+package guard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+var outputVerdicts = metrics.NewCounter("output_guard_verdicts_total")
+
+// ErrOutputBlocked is returned if a check blocks the response.
+var ErrOutputBlocked = errors.New("output blocked by guard")
+
+// Output is the validated model response checked by the output checks.
+type Output struct {
+	Task string
+	// Response is the raw response of the model.
+	Response string
+	// Text are the string values of a JSON response joined by newlines, or the raw response if it is
+	// not JSON. Checks of the content use it, so the keys of the schema do not match.
+	Text string
+}
+
+// OutputCheck checks the response of the model after schema validation.
+type OutputCheck interface {
+	Name() string
+	Check(ctx context.Context, output *Output) (Verdict, error)
+}
+
+// OutputBlockedError is returned if a check blocks the response. It wraps ErrOutputBlocked.
+type OutputBlockedError struct {
+	// Verdicts are the verdicts of the checks that flagged or blocked the response.
+	Verdicts []Verdict
+}
+
+func (e *OutputBlockedError) Error() string {
+	blocking := e.Verdicts[len(e.Verdicts)-1]
+	return fmt.Sprintf("%s: %s: %s", ErrOutputBlocked, blocking.Guard, strings.Join(blocking.Reasons, "; "))
+}
+
+func (e *OutputBlockedError) Unwrap() error {
+	return ErrOutputBlocked
+}
+
+// NewOutputChecks creates the checks of the output guards of a template, cheap checks first. The
+// judge model is taken from the definitions.
+func NewOutputChecks(cfg prompt.OutputGuardsConfig, definitions *model.Definitions) ([]OutputCheck, error) {
+	var checks []OutputCheck
+
+	if cfg.MaxLength != nil {
+		action, err := parseAction(cfg.MaxLength.Action, ActionBlock)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, &MaxLengthCheck{MaxChars: cfg.MaxLength.Chars, Action: action})
+	}
+
+	if cfg.Banned != nil {
+		check, err := NewBannedCheck(*cfg.Banned)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+
+	if cfg.URLs != nil {
+		action, err := parseAction(cfg.URLs.Action, ActionBlock)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, &URLCheck{Allow: cfg.URLs.Allow, Action: action})
+	}
+
+	if cfg.Refusal != nil {
+		check, err := NewRefusalCheck(*cfg.Refusal)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+
+	if cfg.Language != nil {
+		action, err := parseAction(cfg.Language.Action, ActionBlock)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, &LanguageCheck{Allow: cfg.Language.Allow, Action: action})
+	}
+
+	if cfg.Judge != nil {
+		check, err := NewJudgeCheck(*cfg.Judge, definitions)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+// OutputChain runs output checks in order.
+type OutputChain struct {
+	checks []OutputCheck
+}
+
+// NewOutputChain creates a chain of the checks.
+func NewOutputChain(checks ...OutputCheck) *OutputChain {
+	return &OutputChain{
+		checks: checks,
+	}
+}
+
+// Check runs the checks on the response and returns the verdicts that flagged it. The chain stops at
+// the first check that blocks the response, its error is an *OutputBlockedError. A check that fails
+// stops the chain as well.
+func (c *OutputChain) Check(ctx context.Context, task, response string) ([]Verdict, error) {
+	output := &Output{Task: task, Response: response, Text: textOf(response)}
+
+	var verdicts []Verdict
+	for _, check := range c.checks {
+		verdict, err := check.Check(ctx, output)
+		if err != nil {
+			return nil, fmt.Errorf("output guard %s: %w", check.Name(), err)
+		}
+		if verdict.Action == "" {
+			verdict.Action = ActionAllow
+		}
+		verdict.Guard = check.Name()
+		outputVerdicts.Inc(task, verdict.Guard, string(verdict.Action))
+
+		if verdict.Action == ActionAllow {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"task":    task,
+			"guard":   verdict.Guard,
+			"action":  verdict.Action,
+			"reasons": verdict.Reasons,
+		}).Warn("Output guard verdict")
+
+		verdicts = append(verdicts, verdict)
+		if verdict.Action == ActionBlock {
+			return verdicts, &OutputBlockedError{Verdicts: verdicts}
+		}
+	}
+
+	return verdicts, nil
+}
+
+// textOf returns the string values of a JSON document joined by newlines, or the document itself if
+// it is not JSON.
+func textOf(response string) string {
+	var value any
+	if err := json.Unmarshal([]byte(response), &value); err != nil {
+		return response
+	}
+
+	var texts []string
+	var walk func(any)
+	walk = func(value any) {
+		switch v := value.(type) {
+		case string:
+			texts = append(texts, v)
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]any:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(value)
+
+	// Map iteration is random, sorting keeps the reasons of the checks stable.
+	slices.Sort(texts)
+
+	return strings.Join(texts, "\n")
+}
+
+// MaxLengthCheck limits the characters of the raw response.
+type MaxLengthCheck struct {
+	MaxChars int
+	Action   Action
+}
+
+func (c *MaxLengthCheck) Name() string {
+	return "max_length"
+}
+
+func (c *MaxLengthCheck) Check(ctx context.Context, output *Output) (Verdict, error) {
+	if n := utf8.RuneCountInString(output.Response); n > c.MaxChars {
+		return Verdict{
+			Action:  c.Action,
+			Reasons: []string{fmt.Sprintf("response has %d characters, at most %d are allowed", n, c.MaxChars)},
+		}, nil
+	}
+
+	return Verdict{Action: ActionAllow}, nil
+}
+
+// BannedCheck rejects responses with banned terms or matching patterns.
+type BannedCheck struct {
+	terms    []*regexp.Regexp
+	patterns []*regexp.Regexp
+	action   Action
+}
+
+// NewBannedCheck compiles the terms and patterns. Terms match case-insensitive as whole words.
+func NewBannedCheck(cfg prompt.BannedCheck) (*BannedCheck, error) {
+	action, err := parseAction(cfg.Action, ActionBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &BannedCheck{action: action}
+	for _, term := range cfg.Terms {
+		if term = strings.TrimSpace(term); term != "" {
+			c.terms = append(c.terms, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(term)+`\b`))
+		}
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid banned pattern %q: %w", pattern, err)
+		}
+		c.patterns = append(c.patterns, re)
+	}
+
+	return c, nil
+}
+
+func (c *BannedCheck) Name() string {
+	return "banned"
+}
+
+func (c *BannedCheck) Check(ctx context.Context, output *Output) (Verdict, error) {
+	var reasons []string
+	for _, term := range c.terms {
+		if match := term.FindString(output.Text); match != "" {
+			reasons = append(reasons, fmt.Sprintf("banned term %q", match))
+		}
+	}
+	for _, pattern := range c.patterns {
+		if pattern.MatchString(output.Text) {
+			reasons = append(reasons, "response matches "+pattern.String())
+		}
+	}
+	if len(reasons) == 0 {
+		return Verdict{Action: ActionAllow}, nil
+	}
+
+	return Verdict{Action: c.action, Reasons: reasons}, nil
+}
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>()\[\]{}]+`)
+
+// URLCheck rejects links to hosts that are not in the allowlist. A host allows its subdomains.
+type URLCheck struct {
+	Allow  []string
+	Action Action
+}
+
+func (c *URLCheck) Name() string {
+	return "urls"
+}
+
+func (c *URLCheck) Check(ctx context.Context, output *Output) (Verdict, error) {
+	var reasons []string
+	for _, link := range urlPattern.FindAllString(output.Text, -1) {
+		link = strings.TrimRight(link, ".,;:!?")
+		parsed, err := url.Parse(link)
+		if err != nil || parsed.Hostname() == "" {
+			reasons = append(reasons, fmt.Sprintf("invalid link %q", link))
+			continue
+		}
+		if !c.allowed(parsed.Hostname()) {
+			reasons = append(reasons, fmt.Sprintf("link to %s is not allowed", parsed.Hostname()))
+		}
+	}
+	if len(reasons) == 0 {
+		return Verdict{Action: ActionAllow}, nil
+	}
+
+	return Verdict{Action: c.Action, Reasons: slices.Compact(reasons)}, nil
+}
+
+func (c *URLCheck) allowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range c.Allow {
+		allowed = strings.ToLower(strings.TrimPrefix(allowed, "."))
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultRefusalPhrases are phrases models use when they refuse a task.
+var defaultRefusalPhrases = []string{
+	"i cannot help with that",
+	"i can't help with that",
+	"i cannot help with this",
+	"i can't help with this",
+	"i cannot assist with",
+	"i can't assist with",
+	"i'm sorry but i can't",
+	"i'm sorry but i cannot",
+	"i am sorry but i cannot",
+	"i'm unable to",
+	"i am unable to",
+	"i won't be able to",
+	"i cannot comply",
+	"i can't comply",
+	"i cannot provide",
+	"i can't provide",
+	"as an ai language model",
+	"as an ai i",
+}
+
+// RefusalCheck detects responses where the model refuses the task instead of answering it. The text
+// is compared like the input of the InjectionGuard.
+type RefusalCheck struct {
+	phrases []string
+	action  Action
+}
+
+// NewRefusalCheck creates a check with the built-in and the configured phrases.
+func NewRefusalCheck(cfg prompt.RefusalCheck) (*RefusalCheck, error) {
+	action, err := parseAction(cfg.Action, ActionBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	var phrases []string
+	for _, phrase := range slices.Concat(defaultRefusalPhrases, cfg.Phrases) {
+		if phrase = normalizeRefusal(phrase); phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+
+	return &RefusalCheck{
+		phrases: phrases,
+		action:  action,
+	}, nil
+}
+
+func (c *RefusalCheck) Name() string {
+	return "refusal"
+}
+
+func (c *RefusalCheck) Check(ctx context.Context, output *Output) (Verdict, error) {
+	text := " " + normalizeRefusal(output.Text) + " "
+	for _, phrase := range c.phrases {
+		if strings.Contains(text, " "+phrase+" ") {
+			return Verdict{Action: c.action, Reasons: []string{fmt.Sprintf("refusal phrase %q", phrase)}}, nil
+		}
+	}
+
+	return Verdict{Action: ActionAllow}, nil
+}
+
+// normalizeRefusal normalizes the text and replaces typographic apostrophes, which models often use
+// in contractions.
+func normalizeRefusal(text string) string {
+	return normalize(strings.ReplaceAll(text, "’", "'"))
+}
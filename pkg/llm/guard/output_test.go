@@ -0,0 +1,141 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+)
+
+func checkOutput(t *testing.T, check OutputCheck, response string) Verdict {
+	t.Helper()
+	verdict, err := check.Check(context.Background(), &Output{Task: "chat", Response: response, Text: textOf(response)})
+	require.NoError(t, err)
+	return verdict
+}
+
+type staticCheck struct {
+	name    string
+	verdict Verdict
+	err     error
+	calls   int
+}
+
+func (c *staticCheck) Name() string { return c.name }
+
+func (c *staticCheck) Check(ctx context.Context, output *Output) (Verdict, error) {
+	c.calls++
+	return c.verdict, c.err
+}
+
+func TestOutputChain(t *testing.T) {
+	last := &staticCheck{name: "c"}
+	chain := NewOutputChain(
+		&staticCheck{name: "a", verdict: Verdict{Action: ActionFlag, Reasons: []string{"odd"}}},
+		&staticCheck{name: "b"},
+		last,
+	)
+
+	verdicts, err := chain.Check(context.Background(), "chat", `{"answer":"hi"}`)
+	require.NoError(t, err)
+	assert.Equal(t, []Verdict{{Guard: "a", Action: ActionFlag, Reasons: []string{"odd"}}}, verdicts)
+	assert.Equal(t, 1, last.calls)
+}
+
+func TestOutputChainBlocks(t *testing.T) {
+	last := &staticCheck{name: "c"}
+	chain := NewOutputChain(
+		&staticCheck{name: "a", verdict: Verdict{Action: ActionBlock, Reasons: []string{"refused"}}},
+		last,
+	)
+
+	verdicts, err := chain.Check(context.Background(), "chat", "hi")
+	require.ErrorIs(t, err, ErrOutputBlocked)
+	assert.EqualError(t, err, "output blocked by guard: a: refused")
+	assert.Zero(t, last.calls)
+
+	var blockedErr *OutputBlockedError
+	require.ErrorAs(t, err, &blockedErr)
+	assert.Equal(t, verdicts, blockedErr.Verdicts)
+
+	chain = NewOutputChain(&staticCheck{name: "a", err: errors.New("boom")})
+	_, err = chain.Check(context.Background(), "chat", "hi")
+	assert.EqualError(t, err, "output guard a: boom")
+}
+
+func TestNewOutputChecks(t *testing.T) {
+	checks, err := NewOutputChecks(prompt.OutputGuardsConfig{
+		Language:  &prompt.LanguageCheck{Allow: []string{"en"}},
+		Refusal:   &prompt.RefusalCheck{},
+		MaxLength: &prompt.MaxLengthCheck{Chars: 10, Action: "flag"},
+	}, model.NewDefinitions(nil))
+	require.NoError(t, err)
+
+	var names []string
+	for _, check := range checks {
+		names = append(names, check.Name())
+	}
+	assert.Equal(t, []string{"max_length", "refusal", "language"}, names)
+
+	_, err = NewOutputChecks(prompt.OutputGuardsConfig{URLs: &prompt.URLCheck{Action: "drop"}}, model.NewDefinitions(nil))
+	assert.EqualError(t, err, "unknown guard action: drop")
+}
+
+func TestTextOf(t *testing.T) {
+	assert.Equal(t, "a\nb\nc", textOf(`{"x":"c","y":["b",{"z":"a"}],"n":1}`))
+	assert.Equal(t, "plain text", textOf("plain text"))
+}
+
+func TestMaxLengthCheck(t *testing.T) {
+	check := &MaxLengthCheck{MaxChars: 5, Action: ActionBlock}
+
+	assert.Equal(t, ActionAllow, checkOutput(t, check, "häääh").Action)
+	assert.Equal(t, Verdict{
+		Action:  ActionBlock,
+		Reasons: []string{"response has 6 characters, at most 5 are allowed"},
+	}, checkOutput(t, check, "hääääh"))
+}
+
+func TestBannedCheck(t *testing.T) {
+	check, err := NewBannedCheck(prompt.BannedCheck{
+		Terms:    []string{"Acme"},
+		Patterns: []string{`\d{3}-\d{4}`},
+		Action:   "flag",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, ActionAllow, checkOutput(t, check, `{"acme_score":"Acmeville is fine"}`).Action)
+	assert.Equal(t, Verdict{
+		Action:  ActionFlag,
+		Reasons: []string{`banned term "ACME"`, `response matches \d{3}-\d{4}`},
+	}, checkOutput(t, check, `{"answer":"Call ACME at 555-1234"}`))
+
+	_, err = NewBannedCheck(prompt.BannedCheck{Patterns: []string{"("}})
+	assert.ErrorContains(t, err, "invalid banned pattern")
+}
+
+func TestURLCheck(t *testing.T) {
+	check := &URLCheck{Allow: []string{"example.com"}, Action: ActionBlock}
+
+	assert.Equal(t, ActionAllow, checkOutput(t, check, `{"link":"See https://docs.example.com/a?b=1."}`).Action)
+	assert.Equal(t, Verdict{
+		Action:  ActionBlock,
+		Reasons: []string{"link to evil.com is not allowed", "link to example.com.evil.com is not allowed"},
+	}, checkOutput(t, check, "Go to http://evil.com/x, http://evil.com/y or https://example.com.evil.com"))
+}
+
+func TestRefusalCheck(t *testing.T) {
+	check, err := NewRefusalCheck(prompt.RefusalCheck{Phrases: []string{"Nope, not doing it"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, ActionAllow, checkOutput(t, check, `{"answer":"I can help with that."}`).Action)
+	assert.Equal(t, Verdict{
+		Action:  ActionBlock,
+		Reasons: []string{`refusal phrase "i'm sorry but i can't"`},
+	}, checkOutput(t, check, `{"answer":"I’m sorry, but I can’t share that."}`))
+	assert.Equal(t, ActionBlock, checkOutput(t, check, "nope -- not doing it!").Action)
+}
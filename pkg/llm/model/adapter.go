@@ -1,70 +1,45 @@
 package model
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
 )
 
 // Llm is the common interface for all models.
 type Llm interface {
-	CallModel(prompt.PromptRequest) ([]byte, error)
+	CallModel(context.Context, prompt.PromptRequest) ([]byte, error)
 	Name() string
 }
 
 type LlamaLocal struct {
-	modelName string
-	baseURL   string
+	modelName        string
+	endpoints        *balancer
+	structuredOutput string
+	client           *transport.Client
 }
 
 func (m *LlamaLocal) Name() string {
 	return m.modelName
 }
 
-func (m *LlamaLocal) CallModel(prompt prompt.PromptRequest) ([]byte, error) {
-	url := fmt.Sprintf("%s/chat/completions", m.baseURL)
+// CallModel sends the prompt to the chat completions endpoint of the model's replicas, every retry
+// may go to another replica. Failures of the backend are returned as *transport.UpstreamError.
+func (m *LlamaLocal) CallModel(ctx context.Context, prompt prompt.PromptRequest) ([]byte, error) {
+	log.WithField("model", m.modelName).Debugf("Request: %v", prompt)
 
-	log.Printf("requestBody: %v", prompt)
-
-	// Convert to JSON
-	jsonData, err := json.Marshal(prompt)
-	if err != nil {
-		log.Fatalf("failed to marshal JSON: %v", err)
-		return []byte{}, fmt.Errorf("failed to marshal JSON: %w", err)
-	}
-
-	// Create request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return []byte{}, fmt.Errorf("creating request: %w", err)
-	}
-
-	// Set headers
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	body, err := m.client.PostJSONTo(ctx, m.endpoints, "/chat/completions", newChatRequest(prompt, m.structuredOutput))
 	if err != nil {
-		return []byte{}, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return []byte{}, errors.New("failed to call Model A API")
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return []byte{}, err
+		return []byte{}, fmt.Errorf("calling %s: %w", m.modelName, err)
 	}
 
 	return body, nil
 }
+
+// Status reports the health of every replica of a model with several endpoints.
+func (m *LlamaLocal) Status() []BackendStatus {
+	return m.endpoints.status()
+}
@@ -0,0 +1,274 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/config"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+// Load balancing strategies across the endpoints of a model.
+const (
+	StrategyRoundRobin       = "round_robin"
+	StrategyLeastOutstanding = "least_outstanding"
+	StrategyWeighted         = "weighted"
+)
+
+const (
+	defaultEjectAfter = 3
+	defaultEjectFor   = 30 * time.Second
+)
+
+var (
+	endpointInFlight  = metrics.NewGauge("endpoint_in_flight")
+	endpointEjections = metrics.NewCounter("endpoint_ejections_total")
+)
+
+var (
+	// errEndpointsBusy is returned if all endpoints reached their concurrency limit.
+	errEndpointsBusy = errors.New("all endpoints are at their concurrency limit")
+	// errEndpointsEjected is returned if all endpoints of a model with several endpoints are ejected.
+	errEndpointsEjected = errors.New("all endpoints are ejected")
+)
+
+// Endpoint is one replica serving the model.
+type Endpoint struct {
+	URL           string `yaml:"url"`           // base url of the OpenAI compatible API
+	Weight        int    `yaml:"weight"`        // optional; share of requests with the weighted strategy, default 1
+	MaxConcurrent int    `yaml:"maxConcurrent"` // optional; limit of in-flight requests, default unlimited
+}
+
+// LoadBalancingConfig configures how requests are spread across the endpoints of a model.
+type LoadBalancingConfig struct {
+	Strategy   string          `yaml:"strategy"`   // optional; round_robin (default), least_outstanding or weighted
+	EjectAfter int             `yaml:"ejectAfter"` // optional; consecutive failures that eject an endpoint, default 3
+	EjectFor   config.Duration `yaml:"ejectFor"`   // optional; time an ejected endpoint gets no requests, default 30s
+}
+
+type endpoint struct {
+	Endpoint
+	inFlight      int
+	failures      int
+	ejectedUntil  time.Time
+	currentWeight int
+}
+
+// balancer picks an endpoint for every attempt of a request. Endpoints of a model with several
+// endpoints that keep failing are ejected for a while (passive health checking), which replaces the
+// circuit breaker of the model: while all endpoints are ejected, calls fail fast. A single endpoint
+// is never ejected, it is guarded by the circuit breaker of the model.
+type balancer struct {
+	model      string
+	strategy   string
+	ejectAfter int
+	ejectFor   time.Duration
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+
+	now func() time.Time
+}
+
+func newBalancer(model string, endpoints []Endpoint, cfg LoadBalancingConfig) (*balancer, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("model %s has no endpoints", model)
+	}
+
+	strategy := cfg.Strategy
+	switch strategy {
+	case "":
+		strategy = StrategyRoundRobin
+	case StrategyRoundRobin, StrategyLeastOutstanding, StrategyWeighted:
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy: %s", strategy)
+	}
+
+	ejectAfter := cfg.EjectAfter
+	if ejectAfter <= 0 {
+		ejectAfter = defaultEjectAfter
+	}
+
+	b := &balancer{
+		model:      model,
+		strategy:   strategy,
+		ejectAfter: ejectAfter,
+		ejectFor:   cfg.EjectFor.Or(defaultEjectFor),
+		now:        time.Now,
+	}
+	for _, e := range endpoints {
+		if e.Weight <= 0 {
+			e.Weight = 1
+		}
+		b.endpoints = append(b.endpoints, &endpoint{Endpoint: e})
+	}
+
+	return b, nil
+}
+
+// acquire picks an endpoint and counts the request as in flight. Every acquired endpoint must be
+// released with the outcome of the request.
+func (b *balancer) acquire() (*endpoint, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	candidates := b.available(func(e *endpoint) bool { return !e.ejected(now) })
+	if len(candidates) == 0 {
+		if retryAfter, ejected := b.allEjected(now); ejected {
+			return nil, &UnavailableError{Model: b.model, RetryAfter: retryAfter, Err: errEndpointsEjected}
+		}
+		return nil, &UnavailableError{Model: b.model, RetryAfter: time.Second, Err: errEndpointsBusy}
+	}
+
+	var chosen *endpoint
+	switch b.strategy {
+	case StrategyLeastOutstanding:
+		chosen = b.roundRobin(candidates, func(e *endpoint) bool {
+			for _, other := range candidates {
+				if other.inFlight < e.inFlight {
+					return false
+				}
+			}
+			return true
+		})
+	case StrategyWeighted:
+		chosen = smoothWeighted(candidates)
+	default:
+		chosen = b.roundRobin(candidates, func(*endpoint) bool { return true })
+	}
+
+	chosen.inFlight++
+	endpointInFlight.Add(1, b.model, chosen.URL)
+
+	return chosen, nil
+}
+
+// Acquire implements transport.Endpoints, so that every attempt of a call can go to another endpoint.
+func (b *balancer) Acquire() (string, func(error), error) {
+	e, err := b.acquire()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return e.URL, func(err error) { b.release(e, err) }, nil
+}
+
+// release records the outcome of a request to the endpoint.
+func (b *balancer) release(e *endpoint, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e.inFlight--
+	endpointInFlight.Add(-1, b.model, e.URL)
+
+	switch {
+	case isBackendFailure(err):
+		e.failures++
+		if e.failures >= b.ejectAfter && len(b.endpoints) > 1 {
+			e.failures = 0
+			e.ejectedUntil = b.now().Add(b.ejectFor)
+			endpointEjections.Inc(b.model, e.URL)
+			log.WithFields(log.Fields{
+				"model":    b.model,
+				"endpoint": e.URL,
+				"for":      b.ejectFor,
+			}).Warn("Ejecting failing endpoint")
+		}
+	case isCanceled(err):
+	default:
+		e.failures = 0
+	}
+}
+
+// allEjected reports whether all endpoints are ejected and the time until the first one returns. Must hold b.mu.
+func (b *balancer) allEjected(now time.Time) (time.Duration, bool) {
+	var retryAfter time.Duration
+	for _, e := range b.endpoints {
+		if !e.ejected(now) {
+			return 0, false
+		}
+		if wait := e.ejectedUntil.Sub(now); retryAfter == 0 || wait < retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	return retryAfter, true
+}
+
+// status reports every endpoint, ejected endpoints are open.
+func (b *balancer) status() []BackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	statuses := make([]BackendStatus, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		state := StateClosed
+		if e.ejected(now) {
+			state = StateOpen
+		}
+		statuses = append(statuses, BackendStatus{
+			Model:    b.model,
+			Endpoint: e.URL,
+			State:    state.String(),
+			Failures: e.failures,
+		})
+	}
+
+	return statuses
+}
+
+func (e *endpoint) ejected(now time.Time) bool {
+	return now.Before(e.ejectedUntil)
+}
+
+// available returns the endpoints below their concurrency limit that pass the filter. Must hold b.mu.
+func (b *balancer) available(filter func(*endpoint) bool) []*endpoint {
+	var candidates []*endpoint
+	for _, e := range b.endpoints {
+		if e.MaxConcurrent > 0 && e.inFlight >= e.MaxConcurrent {
+			continue
+		}
+		if filter(e) {
+			candidates = append(candidates, e)
+		}
+	}
+
+	return candidates
+}
+
+// roundRobin returns the next candidate after the last pick that matches. Must hold b.mu.
+func (b *balancer) roundRobin(candidates []*endpoint, match func(*endpoint) bool) *endpoint {
+	for i := range len(b.endpoints) {
+		e := b.endpoints[(b.next+i)%len(b.endpoints)]
+		if slices.Contains(candidates, e) && match(e) {
+			b.next = (b.next + i + 1) % len(b.endpoints)
+			return e
+		}
+	}
+
+	return candidates[0]
+}
+
+// smoothWeighted implements the smooth weighted round robin of nginx, which spreads the picks of
+// heavier endpoints evenly instead of sending them in bursts.
+func smoothWeighted(candidates []*endpoint) *endpoint {
+	total := 0
+	var chosen *endpoint
+	for _, e := range candidates {
+		e.currentWeight += e.Weight
+		total += e.Weight
+		if chosen == nil || e.currentWeight > chosen.currentWeight {
+			chosen = e
+		}
+	}
+	chosen.currentWeight -= total
+
+	return chosen
+}
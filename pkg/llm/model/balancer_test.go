@@ -0,0 +1,173 @@
+package model
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+)
+
+func pick(t *testing.T, b *balancer, n int, release bool) []string {
+	t.Helper()
+
+	var urls []string
+	for range n {
+		e, err := b.acquire()
+		require.NoError(t, err)
+		urls = append(urls, e.URL)
+		if release {
+			b.release(e, nil)
+		}
+	}
+
+	return urls
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	b, err := newBalancer("m", []Endpoint{{URL: "a"}, {URL: "b"}, {URL: "c"}}, LoadBalancingConfig{})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b", "c", "a"}, pick(t, b, 4, true))
+}
+
+func TestBalancerWeighted(t *testing.T) {
+	b, err := newBalancer("m", []Endpoint{{URL: "a", Weight: 3}, {URL: "b", Weight: 1}}, LoadBalancingConfig{Strategy: StrategyWeighted})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "a", "b", "a", "a", "a", "b", "a"}, pick(t, b, 8, true))
+}
+
+func TestBalancerLeastOutstanding(t *testing.T) {
+	b, err := newBalancer("m", []Endpoint{{URL: "a"}, {URL: "b"}}, LoadBalancingConfig{Strategy: StrategyLeastOutstanding})
+	require.NoError(t, err)
+
+	first, err := b.acquire()
+	require.NoError(t, err)
+	assert.Equal(t, "a", first.URL)
+
+	// a is still busy, so b and then the less loaded one again are picked.
+	assert.Equal(t, []string{"b", "b"}, pick(t, b, 2, true))
+}
+
+func TestBalancerConcurrencyLimit(t *testing.T) {
+	b, err := newBalancer("m", []Endpoint{{URL: "a", MaxConcurrent: 1}}, LoadBalancingConfig{})
+	require.NoError(t, err)
+
+	e, err := b.acquire()
+	require.NoError(t, err)
+
+	_, err = b.acquire()
+	assert.ErrorIs(t, err, ErrUpstreamUnavailable)
+	assert.ErrorIs(t, err, errEndpointsBusy)
+
+	b.release(e, nil)
+	_, err = b.acquire()
+	assert.NoError(t, err)
+}
+
+func TestBalancerEjectsFailingEndpoints(t *testing.T) {
+	b, err := newBalancer("m", []Endpoint{{URL: "a"}, {URL: "b"}}, LoadBalancingConfig{EjectAfter: 1, EjectFor: 0})
+	require.NoError(t, err)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	e, err := b.acquire()
+	require.NoError(t, err)
+	require.Equal(t, "a", e.URL)
+	b.release(e, errServer)
+
+	// a is ejected, all requests go to b.
+	assert.Equal(t, []string{"b", "b"}, pick(t, b, 2, true))
+
+	// After the ejection a gets requests again.
+	now = now.Add(defaultEjectFor)
+	assert.ElementsMatch(t, []string{"a", "b"}, pick(t, b, 2, true))
+}
+
+func TestBalancerUsesEjectedEndpointsIfNoneIsLeft(t *testing.T) {
+	b, err := newBalancer("m", []Endpoint{{URL: "a"}}, LoadBalancingConfig{EjectAfter: 1})
+	require.NoError(t, err)
+
+	e, err := b.acquire()
+	require.NoError(t, err)
+	b.release(e, errServer)
+
+	assert.Equal(t, []string{"a"}, pick(t, b, 1, true))
+}
+
+func TestBalancerFailsFastIfAllEndpointsAreEjected(t *testing.T) {
+	b, err := newBalancer("m", []Endpoint{{URL: "a"}, {URL: "b"}}, LoadBalancingConfig{EjectAfter: 1})
+	require.NoError(t, err)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	for range 2 {
+		e, err := b.acquire()
+		require.NoError(t, err)
+		b.release(e, errServer)
+	}
+	assert.Equal(t, []BackendStatus{
+		{Model: "m", Endpoint: "a", State: "open"},
+		{Model: "m", Endpoint: "b", State: "open"},
+	}, b.status())
+
+	now = now.Add(time.Second)
+	_, err = b.acquire()
+	var unavailable *UnavailableError
+	require.ErrorAs(t, err, &unavailable)
+	assert.ErrorIs(t, err, errEndpointsEjected)
+	assert.Equal(t, defaultEjectFor-time.Second, unavailable.RetryAfter)
+}
+
+func TestLlamaLocalRetriesOnAnotherEndpoint(t *testing.T) {
+	var failingCalls atomic.Int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failingCalls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer healthy.Close()
+
+	RegisterDefinition("TestReplicas", Definition{
+		ModelName: "replicas",
+		Endpoints: []Endpoint{{URL: failing.URL}, {URL: healthy.URL}},
+		Transport: transport.Config{Retry: transport.RetryConfig{InitialBackoff: 1, MaxBackoff: 1}},
+		// Would open after the first failure if the replicas shared a circuit.
+		CircuitBreaker: CircuitBreakerConfig{FailureThreshold: 1},
+	})
+	llm, err := GetLlmFactory("TestReplicas")
+	require.NoError(t, err)
+
+	// Every call starts on the failing replica and is retried on the healthy one.
+	for range 2 {
+		body, err := llm.CallModel(context.Background(), prompt.PromptRequest{})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"ok":true}`, string(body))
+	}
+	assert.Equal(t, int32(2), failingCalls.Load())
+
+	reporter, ok := llm.(StatusReporter)
+	require.True(t, ok)
+	statuses := reporter.Status()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "closed", statuses[0].State)
+	assert.Equal(t, 2, statuses[0].Failures)
+}
+
+func TestNewBalancerInvalid(t *testing.T) {
+	_, err := newBalancer("m", nil, LoadBalancingConfig{})
+	assert.Error(t, err)
+
+	_, err = newBalancer("m", []Endpoint{{URL: "a"}}, LoadBalancingConfig{Strategy: "random"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,279 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/config"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultCoolDown         = 30 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+var (
+	breakerState       = metrics.NewGauge("circuit_breaker_state")
+	breakerTransitions = metrics.NewCounter("circuit_breaker_transitions_total")
+	breakerRejections  = metrics.NewCounter("circuit_breaker_rejections_total")
+)
+
+var (
+	// ErrUpstreamUnavailable is returned without calling the backend, e.g. while its circuit is open.
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+	errCircuitOpen = errors.New("circuit is open")
+)
+
+// UnavailableError is returned if a call is rejected before it reaches the backend. It wraps
+// ErrUpstreamUnavailable and the reason of the rejection.
+type UnavailableError struct {
+	Model      string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s: %s: %v, retry after %s", ErrUpstreamUnavailable, e.Model, e.Err, e.RetryAfter)
+}
+
+func (e *UnavailableError) Unwrap() []error {
+	return []error{ErrUpstreamUnavailable, e.Err}
+}
+
+// CircuitBreakerConfig configures the circuit breaker of a model.
+type CircuitBreakerConfig struct {
+	Disabled         bool            `yaml:"disabled"`         // optional; the breaker is enabled by default
+	FailureThreshold int             `yaml:"failureThreshold"` // optional; consecutive failures that open the circuit, default 5
+	CoolDown         config.Duration `yaml:"coolDown"`         // optional; time the circuit stays open, default 30s
+	HalfOpenRequests int             `yaml:"halfOpenRequests"` // optional; probe calls allowed after the cool down, default 1
+}
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	StateClosed CircuitState = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "open"
+	}
+}
+
+// BackendStatus describes the health of a model backend.
+type BackendStatus struct {
+	Model string `json:"model"`
+	// Endpoint is the replica of a model with several endpoints, which are ejected instead of
+	// sharing a circuit breaker.
+	Endpoint string `json:"endpoint,omitempty"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+}
+
+// StatusReporter is implemented by models that track the health of their backends.
+type StatusReporter interface {
+	Status() []BackendStatus
+}
+
+// CircuitBreaker wraps a model and stops calling its backend after repeated failures. While the
+// circuit is open calls fail fast. After the cool down a limited number of probe calls decide
+// whether the circuit closes again. A failure that asks for a Retry-After opens the circuit right
+// away for the requested time.
+type CircuitBreaker struct {
+	llm              Llm
+	name             string
+	failureThreshold int
+	coolDown         time.Duration
+	halfOpenRequests int
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	openFor  time.Duration
+	probes   int
+
+	now func() time.Time
+}
+
+// NewCircuitBreaker wraps the model with a circuit breaker. The name identifies the backend in
+// status reports and metrics.
+func NewCircuitBreaker(llm Llm, name string, cfg CircuitBreakerConfig) *CircuitBreaker {
+	failureThreshold := cfg.FailureThreshold
+	if failureThreshold <= 0 {
+		failureThreshold = defaultFailureThreshold
+	}
+	halfOpenRequests := cfg.HalfOpenRequests
+	if halfOpenRequests <= 0 {
+		halfOpenRequests = defaultHalfOpenRequests
+	}
+
+	breakerState.Set(int64(StateClosed), name)
+
+	return &CircuitBreaker{
+		llm:              llm,
+		name:             name,
+		failureThreshold: failureThreshold,
+		coolDown:         cfg.CoolDown.Or(defaultCoolDown),
+		halfOpenRequests: halfOpenRequests,
+		now:              time.Now,
+	}
+}
+
+func (b *CircuitBreaker) Name() string {
+	return b.llm.Name()
+}
+
+func (b *CircuitBreaker) CallModel(ctx context.Context, request prompt.PromptRequest) ([]byte, error) {
+	probe, err := b.allow()
+	if err != nil {
+		breakerRejections.Inc(b.name)
+		return nil, err
+	}
+
+	response, err := b.llm.CallModel(ctx, request)
+	b.record(err, probe)
+
+	return response, err
+}
+
+// Status reports the current state of the circuit.
+func (b *CircuitBreaker) Status() []BackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return []BackendStatus{{
+		Model:    b.name,
+		State:    b.currentState().String(),
+		Failures: b.failures,
+	}}
+}
+
+// allow decides whether a call may be made. In the half-open state it reserves one of the probes
+// and reports that the call is a probe.
+func (b *CircuitBreaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case StateOpen:
+		return false, &UnavailableError{Model: b.name, RetryAfter: b.openFor - b.now().Sub(b.openedAt), Err: errCircuitOpen}
+	case StateHalfOpen:
+		if b.state == StateOpen {
+			b.transition(StateHalfOpen)
+		}
+		if b.probes >= b.halfOpenRequests {
+			return false, &UnavailableError{Model: b.name, RetryAfter: time.Second, Err: errCircuitOpen}
+		}
+		b.probes++
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// record updates the circuit with the outcome of a call.
+func (b *CircuitBreaker) record(err error, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe && b.probes > 0 {
+		b.probes--
+	}
+
+	switch {
+	case isBackendFailure(err):
+		b.failures++
+		if retryAfter := requestedRetryAfter(err); retryAfter > 0 {
+			b.open(retryAfter)
+		} else if b.state == StateHalfOpen || (b.state == StateClosed && b.failures >= b.failureThreshold) {
+			b.open(b.coolDown)
+		}
+	case isCanceled(err) || errors.Is(err, ErrUpstreamUnavailable):
+		// The caller gave up or the call never reached the backend, e.g. all endpoints of the
+		// balancer are busy, this says nothing about its health.
+	default:
+		b.failures = 0
+		if b.state != StateClosed {
+			b.transition(StateClosed)
+		}
+	}
+}
+
+// open opens the circuit for the given time. Must hold b.mu.
+func (b *CircuitBreaker) open(openFor time.Duration) {
+	b.openedAt = b.now()
+	b.openFor = openFor
+	if b.state != StateOpen {
+		b.transition(StateOpen)
+	}
+}
+
+// currentState returns the state taking an elapsed cool down into account. Must hold b.mu.
+func (b *CircuitBreaker) currentState() CircuitState {
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.openFor {
+		return StateHalfOpen
+	}
+
+	return b.state
+}
+
+// transition changes the state of the circuit. Must hold b.mu.
+func (b *CircuitBreaker) transition(state CircuitState) {
+	log.WithFields(log.Fields{
+		"model": b.name,
+		"from":  b.state.String(),
+		"to":    state.String(),
+	}).Warn("Circuit breaker state changed")
+
+	b.state = state
+	if state != StateHalfOpen {
+		b.probes = 0
+	}
+
+	breakerState.Set(int64(state), b.name)
+	breakerTransitions.Inc(b.name, state.String())
+}
+
+// isBackendFailure reports whether the error means the backend is unhealthy. Client errors and
+// canceled calls do not count.
+func isBackendFailure(err error) bool {
+	var upstreamErr *transport.UpstreamError
+	return errors.As(err, &upstreamErr) && upstreamErr.Retryable()
+}
+
+// requestedRetryAfter returns the wait the backend asked for with a Retry-After header, zero if none.
+func requestedRetryAfter(err error) time.Duration {
+	var upstreamErr *transport.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.RetryAfter
+	}
+
+	return 0
+}
+
+func isCanceled(err error) bool {
+	var upstreamErr *transport.UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Kind == transport.KindCanceled
+	}
+
+	return errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/config"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+)
+
+// fakeLlm returns the queued errors in order and succeeds afterwards.
+type fakeLlm struct {
+	errs  []error
+	calls int
+}
+
+func (f *fakeLlm) Name() string {
+	return "fake"
+}
+
+func (f *fakeLlm) CallModel(ctx context.Context, request prompt.PromptRequest) ([]byte, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []byte("ok"), nil
+}
+
+var errServer = &transport.UpstreamError{Kind: transport.KindServer, StatusCode: 503}
+
+func newTestBreaker(llm Llm) (*CircuitBreaker, *time.Time) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(llm, "test", CircuitBreakerConfig{
+		FailureThreshold: 2,
+		CoolDown:         config.Duration(10 * time.Second),
+	})
+	breaker.now = func() time.Time { return now }
+
+	return breaker, &now
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	llm := &fakeLlm{errs: []error{errServer, errServer}}
+	breaker, now := newTestBreaker(llm)
+	ctx := context.Background()
+
+	// Two consecutive failures open the circuit.
+	_, err := breaker.CallModel(ctx, prompt.PromptRequest{})
+	assert.Error(t, err)
+	_, err = breaker.CallModel(ctx, prompt.PromptRequest{})
+	assert.Error(t, err)
+	assert.Equal(t, "open", breaker.Status()[0].State)
+
+	// While open, calls fail fast without reaching the backend.
+	_, err = breaker.CallModel(ctx, prompt.PromptRequest{})
+	var unavailable *UnavailableError
+	require.ErrorAs(t, err, &unavailable)
+	assert.ErrorIs(t, err, ErrUpstreamUnavailable)
+	assert.Equal(t, 10*time.Second, unavailable.RetryAfter)
+	assert.Equal(t, 2, llm.calls)
+
+	// After the cool down a successful probe closes the circuit.
+	*now = now.Add(10 * time.Second)
+	assert.Equal(t, "half-open", breaker.Status()[0].State)
+	response, err := breaker.CallModel(ctx, prompt.PromptRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ok"), response)
+	assert.Equal(t, "closed", breaker.Status()[0].State)
+}
+
+func TestCircuitBreakerFailedProbeReopens(t *testing.T) {
+	llm := &fakeLlm{errs: []error{errServer, errServer, errServer}}
+	breaker, now := newTestBreaker(llm)
+	ctx := context.Background()
+
+	breaker.CallModel(ctx, prompt.PromptRequest{})
+	breaker.CallModel(ctx, prompt.PromptRequest{})
+
+	*now = now.Add(10 * time.Second)
+	_, err := breaker.CallModel(ctx, prompt.PromptRequest{})
+	assert.ErrorIs(t, err, errServer)
+	assert.Equal(t, "open", breaker.Status()[0].State)
+}
+
+func TestCircuitBreakerHonoursRetryAfter(t *testing.T) {
+	throttled := &transport.UpstreamError{Kind: transport.KindRateLimited, StatusCode: 429, RetryAfter: 2 * time.Minute}
+	llm := &fakeLlm{errs: []error{throttled}}
+	breaker, now := newTestBreaker(llm)
+	ctx := context.Background()
+
+	// A single failure with a Retry-After opens the circuit for the requested time.
+	breaker.CallModel(ctx, prompt.PromptRequest{})
+	_, err := breaker.CallModel(ctx, prompt.PromptRequest{})
+	var unavailable *UnavailableError
+	require.ErrorAs(t, err, &unavailable)
+	assert.Equal(t, 2*time.Minute, unavailable.RetryAfter)
+
+	*now = now.Add(10 * time.Second)
+	assert.Equal(t, "open", breaker.Status()[0].State)
+	*now = now.Add(2 * time.Minute)
+	assert.Equal(t, "half-open", breaker.Status()[0].State)
+	assert.Equal(t, 1, llm.calls)
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	clientErr := &transport.UpstreamError{Kind: transport.KindClient, StatusCode: 400}
+	canceled := &transport.UpstreamError{Kind: transport.KindCanceled, Err: context.Canceled}
+	llm := &fakeLlm{errs: []error{clientErr, clientErr, canceled, errors.New("other"), clientErr}}
+	breaker, _ := newTestBreaker(llm)
+
+	for range 5 {
+		breaker.CallModel(context.Background(), prompt.PromptRequest{})
+	}
+
+	assert.Equal(t, "closed", breaker.Status()[0].State)
+	assert.Equal(t, 5, llm.calls)
+}
+
+func TestCircuitBreakerIgnoresUnavailableEndpoints(t *testing.T) {
+	busy := &UnavailableError{Model: "test", RetryAfter: time.Second, Err: errEndpointsBusy}
+	llm := &fakeLlm{errs: []error{errServer, busy, errServer, busy}}
+	breaker, now := newTestBreaker(llm)
+	ctx := context.Background()
+
+	// A call rejected by the balancer does not reset the failures.
+	for range 3 {
+		breaker.CallModel(ctx, prompt.PromptRequest{})
+	}
+	assert.Equal(t, "open", breaker.Status()[0].State)
+
+	// Nor does it close a half-open circuit.
+	*now = now.Add(10 * time.Second)
+	_, err := breaker.CallModel(ctx, prompt.PromptRequest{})
+	assert.ErrorIs(t, err, errEndpointsBusy)
+	assert.Equal(t, "half-open", breaker.Status()[0].State)
+}
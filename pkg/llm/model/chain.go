@@ -0,0 +1,97 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+)
+
+const defaultValidationAttempts = 1
+
+// Chain is a logical model made of an ordered list of concrete models. If a model fails, the next
+// one in the chain is used.
+type Chain struct {
+	name               string
+	members            []Llm
+	validationAttempts int
+}
+
+// NewChain creates a fallback chain of the given models. validationAttempts is the number of
+// attempts per model before a response that fails validation moves on to the next model.
+func NewChain(name string, members []Llm, validationAttempts int) *Chain {
+	if validationAttempts <= 0 {
+		validationAttempts = defaultValidationAttempts
+	}
+
+	return &Chain{
+		name:               name,
+		members:            members,
+		validationAttempts: validationAttempts,
+	}
+}
+
+// Name returns the logical name of the chain.
+func (c *Chain) Name() string {
+	return c.name
+}
+
+// Members returns the models of the chain in the order they are tried.
+func (c *Chain) Members() []Llm {
+	return c.members
+}
+
+// ValidationAttempts returns the number of attempts per model if the response fails validation.
+func (c *Chain) ValidationAttempts() int {
+	return c.validationAttempts
+}
+
+// CallModel calls the models of the chain in order until one answers. Note that the request is
+// built for a single model; callers that build requests per model iterate Members themselves.
+func (c *Chain) CallModel(ctx context.Context, request prompt.PromptRequest) ([]byte, error) {
+	var lastErr error
+	for _, member := range c.members {
+		response, err := member.CallModel(ctx, request)
+		if err == nil {
+			return response, nil
+		}
+
+		lastErr = err
+		if !IsFallbackError(err) {
+			return nil, err
+		}
+
+		log.WithFields(log.Fields{
+			"chain": c.name,
+			"model": member.Name(),
+		}).Warnf("Falling back to next model: %v", err)
+	}
+
+	return nil, fmt.Errorf("all models of chain %s failed: %w", c.name, lastErr)
+}
+
+// Status reports the health of all models of the chain.
+func (c *Chain) Status() []BackendStatus {
+	var statuses []BackendStatus
+	for _, member := range c.members {
+		if reporter, ok := member.(StatusReporter); ok {
+			statuses = append(statuses, reporter.Status()...)
+		}
+	}
+
+	return statuses
+}
+
+// IsFallbackError reports whether the next model of a chain should be tried after the error:
+// the backend failed, timed out, its circuit is open or it is overloaded. Canceled calls are not retried.
+func IsFallbackError(err error) bool {
+	if errors.Is(err, ErrUpstreamUnavailable) || errors.Is(err, ErrQueueFull) || errors.Is(err, ErrQueueTimeout) {
+		return true
+	}
+
+	var upstreamErr *transport.UpstreamError
+	return errors.As(err, &upstreamErr) && upstreamErr.Kind != transport.KindCanceled
+}
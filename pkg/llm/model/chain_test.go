@@ -0,0 +1,47 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+)
+
+func TestGetLlmFactoryResolvesChains(t *testing.T) {
+	RegisterDefinition("TestHosted", Definition{ModelName: "hosted", BaseURL: "http://localhost:1"})
+	RegisterDefinition("TestChain", Definition{Chain: []string{"LlamaLocal", "TestHosted"}, ValidationAttempts: 2})
+	RegisterDefinition("TestNested", Definition{Chain: []string{"TestChain"}})
+	RegisterDefinition("TestUnknownMember", Definition{Chain: []string{"Missing"}})
+
+	llm, err := GetLlmFactory("TestChain")
+	require.NoError(t, err)
+
+	chain, ok := llm.(*Chain)
+	require.True(t, ok)
+	assert.Equal(t, "TestChain", chain.Name())
+	assert.Equal(t, 2, chain.ValidationAttempts())
+	require.Len(t, chain.Members(), 2)
+	assert.Equal(t, "llama-3-1b-chat", chain.Members()[0].Name())
+	assert.Equal(t, "hosted", chain.Members()[1].Name())
+	assert.Len(t, chain.Status(), 2)
+
+	_, err = GetLlmFactory("TestNested")
+	assert.Error(t, err)
+
+	_, err = GetLlmFactory("TestUnknownMember")
+	assert.Error(t, err)
+}
+
+func TestChainCallModelFallsBack(t *testing.T) {
+	first := &fakeLlm{errs: []error{errServer}}
+	second := &fakeLlm{}
+
+	chain := NewChain("chain", []Llm{first, second}, 0)
+	response, err := chain.CallModel(context.Background(), prompt.PromptRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ok"), response)
+	assert.Equal(t, 1, first.calls)
+	assert.Equal(t, 1, second.calls)
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+)
+
+// AdapterOpenAI is the adapter for backends with an OpenAI compatible chat completions API.
+const AdapterOpenAI = "openai"
+
+// Definition declares a model backend, its limits and how its tokens are counted. A definition with
+// a chain declares a logical model that falls back through the listed models instead.
+type Definition struct {
+	Chain              []string `yaml:"chain"`              // optional; logical names of the models tried in order
+	ValidationAttempts int      `yaml:"validationAttempts"` // optional; chain only, attempts per model on invalid responses, default 1
+
+	Adapter       string           `yaml:"adapter"`       // optional; adapter for the backend's API, default openai
+	ModelName     string           `yaml:"modelName"`     // name of the model at the backend
+	BaseURL       string           `yaml:"baseURL"`       // base url of the OpenAI compatible API, if there is a single endpoint
+	ContextLength int              `yaml:"contextLength"` // maximum tokens of prompt and completion
+	Tokenizer     tokenizer.Config `yaml:"tokenizer"`     // token counter matching the model's tokenizer
+	// StructuredOutput declares how the backend constrains its output to the response schema:
+	// none (default), json_schema or json_object.
+	StructuredOutput string               `yaml:"structuredOutput"`
+	Transport        transport.Config     `yaml:"transport"`      // optional; timeouts and retries of backend calls
+	CircuitBreaker   CircuitBreakerConfig `yaml:"circuitBreaker"` // optional; fail fast while the backend is down, single endpoint only
+	Endpoints        []Endpoint           `yaml:"endpoints"`      // optional; replicas of the backend instead of baseURL
+	LoadBalancing    LoadBalancingConfig  `yaml:"loadBalancing"`  // optional; how requests are spread across the endpoints
+	Concurrency      ConcurrencyConfig    `yaml:"concurrency"`    // optional; limit and queue of concurrent requests
+}
+
+// endpoints returns the replicas of the backend; a single baseURL is one endpoint.
+func (d Definition) endpoints() []Endpoint {
+	if len(d.Endpoints) > 0 {
+		return d.Endpoints
+	}
+
+	return []Endpoint{{URL: d.BaseURL}}
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+)
+
+// Embedder turns texts into embedding vectors.
+type Embedder interface {
+	// Embed returns one vector per input text, in the order of the inputs.
+	Embed(ctx context.Context, input []string) ([][]float32, error)
+	Name() string
+}
+
+// OpenAIEmbedder calls the embeddings endpoint of an OpenAI compatible API, e.g. the LlamaEdge or
+// vLLM server that also serves LlamaLocal.
+type OpenAIEmbedder struct {
+	modelName string
+	endpoints *balancer
+	client    *transport.Client
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (e *OpenAIEmbedder) Name() string {
+	return e.modelName
+}
+
+// Embed sends the texts to the embeddings endpoint of one of the model's replicas.
+// Failures of the backend are returned as *transport.UpstreamError.
+func (e *OpenAIEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+
+	endpoint, err := e.endpoints.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/embeddings", endpoint.URL)
+
+	body, err := e.client.PostJSON(ctx, url, embeddingRequest{Model: e.modelName, Input: input})
+	e.endpoints.release(endpoint, err)
+	if err != nil {
+		return nil, fmt.Errorf("calling %s: %w", e.modelName, err)
+	}
+
+	var response embeddingResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("parsing embeddings of %s: %w", e.modelName, err)
+	}
+
+	vectors := make([][]float32, len(input))
+	for _, data := range response.Data {
+		if data.Index < 0 || data.Index >= len(input) {
+			return nil, fmt.Errorf("embeddings of %s: unexpected index %d", e.modelName, data.Index)
+		}
+		vectors[data.Index] = data.Embedding
+	}
+	for i, vector := range vectors {
+		if len(vector) == 0 {
+			return nil, fmt.Errorf("embeddings of %s: missing embedding for input %d", e.modelName, i)
+		}
+	}
+
+	return vectors, nil
+}
+
+// GetEmbedderFactory returns the embedder of the default definitions.
+func GetEmbedderFactory(modelName string) (Embedder, error) {
+	return defaultDefinitions.NewEmbedder(modelName)
+}
+
+// NewEmbedder returns the embedder of the model with the given logical name.
+func (d *Definitions) NewEmbedder(modelName string) (Embedder, error) {
+	definition, err := d.Get(modelName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(definition.Chain) > 0 {
+		return nil, fmt.Errorf("model %s is a chain and cannot create embeddings", modelName)
+	}
+
+	switch definition.Adapter {
+	case "", AdapterOpenAI:
+		endpoints, err := newBalancer(modelName, definition.endpoints(), definition.LoadBalancing)
+		if err != nil {
+			return nil, err
+		}
+
+		return &OpenAIEmbedder{
+			modelName: definition.ModelName,
+			endpoints: endpoints,
+			client:    transport.NewClient(definition.Transport),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown adapter %s for model: %s", definition.Adapter, modelName)
+	}
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAIEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+
+		var request embeddingRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "nomic-embed", request.Model)
+		assert.Equal(t, []string{"a", "b"}, request.Input)
+
+		// The order of the data is not guaranteed, the index is.
+		w.Write([]byte(`{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer server.Close()
+
+	RegisterDefinition("TestEmbeddings", Definition{
+		ModelName: "nomic-embed",
+		BaseURL:   server.URL + "/v1",
+	})
+
+	embedder, err := GetEmbedderFactory("TestEmbeddings")
+	require.NoError(t, err)
+	assert.Equal(t, "nomic-embed", embedder.Name())
+
+	vectors, err := embedder.Embed(context.Background(), []string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 0}, {0, 1}}, vectors)
+}
+
+func TestOpenAIEmbedderMissingEmbedding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":[{"index":0,"embedding":[1,0]}]}`))
+	}))
+	defer server.Close()
+
+	RegisterDefinition("TestEmbeddingsMissing", Definition{BaseURL: server.URL})
+
+	embedder, err := GetEmbedderFactory("TestEmbeddingsMissing")
+	require.NoError(t, err)
+
+	_, err = embedder.Embed(context.Background(), []string{"a", "b"})
+	assert.ErrorContains(t, err, "missing embedding for input 1")
+}
+
+func TestGetEmbedderFactoryRejectsChains(t *testing.T) {
+	RegisterDefinition("TestEmbeddingsChain", Definition{Chain: []string{"LlamaLocal"}})
+
+	_, err := GetEmbedderFactory("TestEmbeddingsChain")
+	assert.Error(t, err)
+}
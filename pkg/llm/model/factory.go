@@ -1,16 +1,151 @@
 package model
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
 
-// GetLlmFactory returns the appropriate Llm implementation.
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+)
+
+// builtinDefinitions are the models known without any config, by their logical name.
+var builtinDefinitions = map[string]Definition{
+	"LlamaLocal": {
+		Adapter:       AdapterOpenAI,
+		ModelName:     "llama-3-1b-chat",
+		BaseURL:       "http://localhost:8080/v1",
+		ContextLength: 128000,
+		Tokenizer: tokenizer.Config{
+			Type: tokenizer.TypeHeuristic,
+		},
+		StructuredOutput: StructuredOutputNone,
+	},
+}
+
+// Definitions holds model definitions by logical name. It is safe for concurrent use, so several
+// servers of one process can each create their models from their own definitions.
+type Definitions struct {
+	mu          sync.RWMutex
+	definitions map[string]Definition
+}
+
+// NewDefinitions returns the built-in definitions, replaced and extended by the given ones, e.g.
+// from the application config.
+func NewDefinitions(definitions map[string]Definition) *Definitions {
+	d := &Definitions{definitions: maps.Clone(builtinDefinitions)}
+	maps.Copy(d.definitions, definitions)
+
+	return d
+}
+
+// defaultDefinitions are used by the package level functions.
+var defaultDefinitions = NewDefinitions(nil)
+
+// DefaultDefinitions returns the definitions of RegisterDefinition, GetDefinition and GetLlmFactory.
+func DefaultDefinitions() *Definitions {
+	return defaultDefinitions
+}
+
+// Register adds a model or replaces the definition of a known model.
+func (d *Definitions) Register(modelName string, definition Definition) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.definitions[modelName] = definition
+}
+
+// Get returns the definition of the model with the given logical name.
+func (d *Definitions) Get(modelName string) (Definition, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	definition, exists := d.definitions[modelName]
+	if !exists {
+		return Definition{}, errors.New("unknown model: " + modelName)
+	}
+
+	return definition, nil
+}
+
+// RegisterDefinition adds a model to the default definitions or replaces the definition of a known
+// model.
+func RegisterDefinition(modelName string, definition Definition) {
+	defaultDefinitions.Register(modelName, definition)
+}
+
+// GetDefinition returns the default definition of the model with the given logical name.
+func GetDefinition(modelName string) (Definition, error) {
+	return defaultDefinitions.Get(modelName)
+}
+
+// GetLlmFactory returns the appropriate Llm implementation of the default definitions.
 func GetLlmFactory(modelName string) (Llm, error) {
-	switch modelName {
-	case "LlamaLocal":
-		return &LlamaLocal{
-			modelName: "llama-3-1b-chat",
-			baseURL:   "http://localhost:8080/v1",
-		}, nil
+	return defaultDefinitions.NewLlm(modelName)
+}
+
+// NewLlm returns the appropriate Llm implementation of the model with the given logical name.
+func (d *Definitions) NewLlm(modelName string) (Llm, error) {
+	definition, err := d.Get(modelName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(definition.Chain) > 0 {
+		return d.newChain(modelName, definition)
+	}
+
+	var llm Llm
+	switch definition.Adapter {
+	case "", AdapterOpenAI:
+		endpoints, err := newBalancer(modelName, definition.endpoints(), definition.LoadBalancing)
+		if err != nil {
+			return nil, err
+		}
+
+		llm = &LlamaLocal{
+			modelName:        definition.ModelName,
+			endpoints:        endpoints,
+			structuredOutput: definition.StructuredOutput,
+			client:           transport.NewClient(definition.Transport),
+		}
 	default:
-		return nil, errors.New("unknown model: " + modelName)
+		return nil, fmt.Errorf("unknown adapter %s for model: %s", definition.Adapter, modelName)
 	}
+
+	// Several endpoints are ejected one by one by the balancer, one bad replica must not open the
+	// circuit of the whole model.
+	if !definition.CircuitBreaker.Disabled && len(definition.endpoints()) == 1 {
+		llm = NewCircuitBreaker(llm, modelName, definition.CircuitBreaker)
+	}
+
+	// The limiter is the outermost layer so that queued requests see the current circuit state.
+	if definition.Concurrency.MaxInFlight > 0 {
+		llm = NewLimiter(llm, modelName, definition.Concurrency)
+	}
+
+	return llm, nil
+}
+
+// newChain creates the models of a chain. Chains can not be nested.
+func (d *Definitions) newChain(modelName string, definition Definition) (*Chain, error) {
+	members := make([]Llm, 0, len(definition.Chain))
+	for _, memberName := range definition.Chain {
+		memberDefinition, err := d.Get(memberName)
+		if err != nil {
+			return nil, fmt.Errorf("chain %s: %w", modelName, err)
+		}
+		if len(memberDefinition.Chain) > 0 {
+			return nil, fmt.Errorf("chain %s: member %s is a chain itself", modelName, memberName)
+		}
+
+		member, err := d.NewLlm(memberName)
+		if err != nil {
+			return nil, fmt.Errorf("chain %s: %w", modelName, err)
+		}
+		members = append(members, member)
+	}
+
+	return NewChain(modelName, members, definition.ValidationAttempts), nil
 }
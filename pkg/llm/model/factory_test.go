@@ -0,0 +1,43 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefinitions(t *testing.T) {
+	first := NewDefinitions(map[string]Definition{"Shared": {ModelName: "first", BaseURL: "http://localhost:1"}})
+	second := NewDefinitions(map[string]Definition{"Shared": {ModelName: "second", BaseURL: "http://localhost:2"}})
+
+	// Definitions of one registry do not leak into another or into the built-in ones.
+	definition, err := first.Get("Shared")
+	require.NoError(t, err)
+	assert.Equal(t, "first", definition.ModelName)
+	definition, err = second.Get("Shared")
+	require.NoError(t, err)
+	assert.Equal(t, "second", definition.ModelName)
+	_, err = NewDefinitions(nil).Get("Shared")
+	assert.EqualError(t, err, "unknown model: Shared")
+
+	_, err = first.Get("LlamaLocal")
+	assert.NoError(t, err, "built-in definitions are included")
+
+	// Registering and creating models concurrently is safe.
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("Model%d", i)
+			first.Register(name, Definition{ModelName: name, BaseURL: "http://localhost:1"})
+			llm, err := first.NewLlm(name)
+			assert.NoError(t, err)
+			assert.Equal(t, name, llm.Name())
+		}()
+	}
+	wg.Wait()
+}
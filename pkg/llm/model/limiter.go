@@ -0,0 +1,148 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/config"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+const (
+	defaultQueueTimeout = 10 * time.Second
+	queueFullRetryAfter = time.Second
+)
+
+var (
+	modelInFlight   = metrics.NewGauge("model_in_flight")
+	modelQueueDepth = metrics.NewGauge("model_queue_depth")
+	queueRejections = metrics.NewCounter("model_queue_rejections_total")
+)
+
+var (
+	// ErrQueueFull is returned if a model has no free slot and its wait queue is full.
+	ErrQueueFull = errors.New("model queue is full")
+	// ErrQueueTimeout is returned if a request waited longer than the queue timeout for a free slot.
+	ErrQueueTimeout = errors.New("timed out waiting in model queue")
+)
+
+// OverloadedError is returned if a request is rejected because the model is at its concurrency
+// limit. It wraps ErrQueueFull or ErrQueueTimeout.
+type OverloadedError struct {
+	Model      string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("%s: %v, retry after %s", e.Model, e.Err, e.RetryAfter)
+}
+
+func (e *OverloadedError) Unwrap() error {
+	return e.Err
+}
+
+// ConcurrencyConfig limits the concurrent requests to a model. Requests above the limit wait in a
+// bounded queue.
+type ConcurrencyConfig struct {
+	MaxInFlight  int             `yaml:"maxInFlight"`  // optional; concurrent requests, default unlimited
+	MaxQueue     int             `yaml:"maxQueue"`     // optional; requests waiting for a slot, default 0
+	QueueTimeout config.Duration `yaml:"queueTimeout"` // optional; longest wait for a slot, default 10s
+}
+
+// Limiter wraps a model and limits its concurrent requests. Requests above the limit wait in a
+// bounded queue, requests that do not fit into the queue or wait too long are rejected.
+type Limiter struct {
+	llm          Llm
+	name         string
+	slots        chan struct{}
+	maxQueue     int
+	queueTimeout time.Duration
+
+	mu     sync.Mutex
+	queued int
+}
+
+// NewLimiter wraps the model with a concurrency limit. The name identifies the model in metrics.
+func NewLimiter(llm Llm, name string, cfg ConcurrencyConfig) *Limiter {
+	return &Limiter{
+		llm:          llm,
+		name:         name,
+		slots:        make(chan struct{}, cfg.MaxInFlight),
+		maxQueue:     max(cfg.MaxQueue, 0),
+		queueTimeout: cfg.QueueTimeout.Or(defaultQueueTimeout),
+	}
+}
+
+func (l *Limiter) Name() string {
+	return l.llm.Name()
+}
+
+func (l *Limiter) CallModel(ctx context.Context, request prompt.PromptRequest) ([]byte, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+
+	return l.llm.CallModel(ctx, request)
+}
+
+// Status reports the health of the wrapped model.
+func (l *Limiter) Status() []BackendStatus {
+	if reporter, ok := l.llm.(StatusReporter); ok {
+		return reporter.Status()
+	}
+
+	return nil
+}
+
+// acquire takes a free slot, waiting in the queue if there is none.
+func (l *Limiter) acquire(ctx context.Context) error {
+	// Fast path without queueing.
+	select {
+	case l.slots <- struct{}{}:
+		modelInFlight.Add(1, l.name)
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queued >= l.maxQueue {
+		l.mu.Unlock()
+		queueRejections.Inc(l.name, "full")
+		return &OverloadedError{Model: l.name, RetryAfter: queueFullRetryAfter, Err: ErrQueueFull}
+	}
+	l.queued++
+	modelQueueDepth.Set(int64(l.queued), l.name)
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		modelQueueDepth.Set(int64(l.queued), l.name)
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		modelInFlight.Add(1, l.name)
+		return nil
+	case <-timer.C:
+		queueRejections.Inc(l.name, "timeout")
+		return &OverloadedError{Model: l.name, RetryAfter: l.queueTimeout, Err: ErrQueueTimeout}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+	modelInFlight.Add(-1, l.name)
+}
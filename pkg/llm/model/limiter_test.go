@@ -0,0 +1,108 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/config"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+)
+
+// blockingLlm blocks every call until it is released.
+type blockingLlm struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingLlm() *blockingLlm {
+	return &blockingLlm{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+}
+
+func (b *blockingLlm) Name() string {
+	return "blocking"
+}
+
+func (b *blockingLlm) CallModel(ctx context.Context, request prompt.PromptRequest) ([]byte, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return []byte("ok"), nil
+}
+
+func TestLimiterQueuesAndRejects(t *testing.T) {
+	llm := newBlockingLlm()
+	limiter := NewLimiter(llm, "test", ConcurrencyConfig{
+		MaxInFlight:  1,
+		MaxQueue:     1,
+		QueueTimeout: config.Duration(time.Minute),
+	})
+	ctx := context.Background()
+
+	// The first request takes the only slot.
+	results := make(chan error, 2)
+	go func() {
+		_, err := limiter.CallModel(ctx, prompt.PromptRequest{})
+		results <- err
+	}()
+	<-llm.started
+
+	// The second request waits in the queue.
+	go func() {
+		_, err := limiter.CallModel(ctx, prompt.PromptRequest{})
+		results <- err
+	}()
+	require.Eventually(t, func() bool {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return limiter.queued == 1
+	}, time.Second, time.Millisecond)
+
+	// The third request does not fit into the queue.
+	_, err := limiter.CallModel(ctx, prompt.PromptRequest{})
+	var overloaded *OverloadedError
+	require.ErrorAs(t, err, &overloaded)
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	// Releasing the first request lets the queued one through.
+	llm.release <- struct{}{}
+	<-llm.started
+	llm.release <- struct{}{}
+	assert.NoError(t, <-results)
+	assert.NoError(t, <-results)
+}
+
+func TestLimiterQueueTimeout(t *testing.T) {
+	llm := newBlockingLlm()
+	limiter := NewLimiter(llm, "test", ConcurrencyConfig{
+		MaxInFlight:  1,
+		MaxQueue:     1,
+		QueueTimeout: config.Duration(10 * time.Millisecond),
+	})
+
+	go limiter.CallModel(context.Background(), prompt.PromptRequest{})
+	<-llm.started
+	defer close(llm.release)
+
+	_, err := limiter.CallModel(context.Background(), prompt.PromptRequest{})
+	assert.ErrorIs(t, err, ErrQueueTimeout)
+	assert.True(t, IsFallbackError(err))
+}
+
+func TestLimiterRespectsCancellation(t *testing.T) {
+	llm := newBlockingLlm()
+	limiter := NewLimiter(llm, "test", ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1})
+
+	go limiter.CallModel(context.Background(), prompt.PromptRequest{})
+	<-llm.started
+	defer close(llm.release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := limiter.CallModel(ctx, prompt.PromptRequest{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
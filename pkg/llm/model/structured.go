@@ -0,0 +1,71 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+)
+
+// Structured output capabilities a model backend can declare.
+const (
+	// StructuredOutputNone sends no schema; the response is only validated afterwards.
+	StructuredOutputNone = "none"
+	// StructuredOutputJSONSchema sends response_format {type: json_schema} (OpenAI, vLLM).
+	StructuredOutputJSONSchema = "json_schema"
+	// StructuredOutputJSONObject sends response_format {type: json_object, schema} (llama.cpp).
+	StructuredOutputJSONObject = "json_object"
+)
+
+// chatRequest is the body of a chat completion request including the provider specific format
+// used for constrained decoding and tools.
+type chatRequest struct {
+	prompt.PromptRequest
+	// Messages replaces the messages of the prompt request with their provider format.
+	Messages       []chatMessage   `json:"messages"`
+	Tools          []chatTool      `json:"tools,omitempty"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+}
+
+type responseFormat struct {
+	Type       string          `json:"type"`
+	JSONSchema *jsonSchema     `json:"json_schema,omitempty"`
+	Schema     json.RawMessage `json:"schema,omitempty"`
+}
+
+type jsonSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict"`
+}
+
+// newChatRequest translates the messages, tools and response schema of the request into the format
+// the backend understands. Backends without structured output support get no response schema.
+func newChatRequest(request prompt.PromptRequest, capability string) chatRequest {
+	body := chatRequest{
+		PromptRequest: request,
+		Messages:      newChatMessages(request.Messages),
+		Tools:         newChatTools(request.Tools),
+	}
+	if request.ResponseSchema == nil {
+		return body
+	}
+
+	switch capability {
+	case StructuredOutputJSONSchema:
+		body.ResponseFormat = &responseFormat{
+			Type: "json_schema",
+			JSONSchema: &jsonSchema{
+				Name:   request.ResponseSchema.Name,
+				Schema: request.ResponseSchema.Schema,
+				Strict: true,
+			},
+		}
+	case StructuredOutputJSONObject:
+		body.ResponseFormat = &responseFormat{
+			Type:   "json_object",
+			Schema: request.ResponseSchema.Schema,
+		}
+	}
+
+	return body
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+)
+
+func TestNewChatRequest(t *testing.T) {
+	request := prompt.PromptRequest{
+		Model:    "llama",
+		Messages: []prompt.Message{{Role: "user", Content: "Hi"}},
+		ResponseSchema: &prompt.ResponseSchema{
+			Name:   "personResponse",
+			Schema: json.RawMessage(`{"type":"object"}`),
+		},
+	}
+
+	testCases := []struct {
+		capability string
+		expected   string
+	}{
+		{
+			capability: StructuredOutputNone,
+			expected:   `{"messages":[{"role":"user","content":"Hi"}],"model":"llama"}`,
+		},
+		{
+			capability: StructuredOutputJSONSchema,
+			expected: `{"messages":[{"role":"user","content":"Hi"}],"model":"llama",` +
+				`"response_format":{"type":"json_schema","json_schema":{"name":"personResponse","schema":{"type":"object"},"strict":true}}}`,
+		},
+		{
+			capability: StructuredOutputJSONObject,
+			expected: `{"messages":[{"role":"user","content":"Hi"}],"model":"llama",` +
+				`"response_format":{"type":"json_object","schema":{"type":"object"}}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.capability, func(t *testing.T) {
+			body, err := json.Marshal(newChatRequest(request, tc.capability))
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(body))
+		})
+	}
+}
+
+func TestNewChatRequestWithoutSchema(t *testing.T) {
+	body, err := json.Marshal(newChatRequest(prompt.PromptRequest{Model: "llama"}, StructuredOutputJSONSchema))
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "response_format")
+}
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+)
+
+// chatMessage is a message in the OpenAI chat completions format.
+type chatMessage struct {
+	Role       string         `json:"role"`
+	Content    string         `json:"content"`
+	ToolCalls  []chatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+}
+
+type chatToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function chatFunctionCall `json:"function"`
+}
+
+type chatFunctionCall struct {
+	Name string `json:"name"`
+	// Arguments are JSON encoded as a string.
+	Arguments string `json:"arguments"`
+}
+
+type chatTool struct {
+	Type     string       `json:"type"`
+	Function chatFunction `json:"function"`
+}
+
+type chatFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// newChatMessages translates the messages including their tool calls into the OpenAI format.
+func newChatMessages(messages []prompt.Message) []chatMessage {
+	chatMessages := make([]chatMessage, len(messages))
+	for i, message := range messages {
+		chatMessages[i] = chatMessage{
+			Role:       message.Role,
+			Content:    message.Content,
+			ToolCallID: message.ToolCallID,
+		}
+		for _, call := range message.ToolCalls {
+			chatMessages[i].ToolCalls = append(chatMessages[i].ToolCalls, chatToolCall{
+				ID:   call.ID,
+				Type: "function",
+				Function: chatFunctionCall{
+					Name:      call.Name,
+					Arguments: string(call.Arguments),
+				},
+			})
+		}
+	}
+
+	return chatMessages
+}
+
+// newChatTools translates the tools into the OpenAI format.
+func newChatTools(tools []prompt.Tool) []chatTool {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	chatTools := make([]chatTool, len(tools))
+	for i, tool := range tools {
+		chatTools[i] = chatTool{
+			Type: "function",
+			Function: chatFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		}
+	}
+
+	return chatTools
+}
+
+// Completion is the answer of a model, either final content or calls of tools.
+type Completion struct {
+	Content   string
+	ToolCalls []prompt.ToolCall
+	// Usage are the tokens the backend reported for the call, zero if it did not report them.
+	Usage Usage
+}
+
+// Usage are the tokens of a model call.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type chatCompletion struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Usage Usage `json:"usage"`
+}
+
+// ParseCompletion extracts the content and tool calls from a chat completion response. A response
+// that is not a chat completion, e.g. from a backend returning the content only, is the content.
+func ParseCompletion(body []byte) (Completion, error) {
+	var completion chatCompletion
+	if err := json.Unmarshal(body, &completion); err != nil || len(completion.Choices) == 0 {
+		return Completion{Content: string(body)}, nil
+	}
+
+	message := completion.Choices[0].Message
+	result := Completion{Content: message.Content, Usage: completion.Usage}
+	for _, call := range message.ToolCalls {
+		arguments := json.RawMessage(call.Function.Arguments)
+		if call.Function.Arguments == "" {
+			arguments = json.RawMessage("{}")
+		}
+		if !json.Valid(arguments) {
+			return Completion{}, fmt.Errorf("tool call %s of %s has invalid JSON arguments", call.ID, call.Function.Name)
+		}
+
+		result.ToolCalls = append(result.ToolCalls, prompt.ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: arguments,
+		})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+)
+
+func TestNewChatRequestTools(t *testing.T) {
+	request := prompt.PromptRequest{
+		Model: "llama",
+		Messages: []prompt.Message{
+			{Role: "user", Content: "Weather in Berlin?"},
+			{Role: "assistant", ToolCalls: []prompt.ToolCall{
+				{ID: "call-1", Name: "get_weather", Arguments: json.RawMessage(`{"city":"Berlin"}`)},
+			}},
+			{Role: "tool", ToolCallID: "call-1", Content: "sunny"},
+		},
+		Tools: []prompt.Tool{{
+			Name:            "get_weather",
+			Description:     "Current weather",
+			ArgumentsSchema: "weatherArguments",
+			Parameters:      json.RawMessage(`{"type":"object"}`),
+		}},
+	}
+
+	body, err := json.Marshal(newChatRequest(request, StructuredOutputNone))
+	require.NoError(t, err)
+
+	expected := `{
+		"model": "llama",
+		"messages": [
+			{"role": "user", "content": "Weather in Berlin?"},
+			{"role": "assistant", "content": "", "tool_calls": [
+				{"id": "call-1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Berlin\"}"}}
+			]},
+			{"role": "tool", "content": "sunny", "tool_call_id": "call-1"}
+		],
+		"tools": [
+			{"type": "function", "function": {"name": "get_weather", "description": "Current weather", "parameters": {"type": "object"}}}
+		]
+	}`
+	assert.JSONEq(t, expected, string(body))
+}
+
+func TestParseCompletion(t *testing.T) {
+	body := []byte(`{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[
+		{"id":"call-1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Berlin\"}"}},
+		{"id":"call-2","type":"function","function":{"name":"get_time","arguments":""}}
+	]}}]}`)
+
+	completion, err := ParseCompletion(body)
+	require.NoError(t, err)
+	assert.Equal(t, []prompt.ToolCall{
+		{ID: "call-1", Name: "get_weather", Arguments: json.RawMessage(`{"city":"Berlin"}`)},
+		{ID: "call-2", Name: "get_time", Arguments: json.RawMessage(`{}`)},
+	}, completion.ToolCalls)
+
+	completion, err = ParseCompletion([]byte(`{"choices":[{"message":{"role":"assistant","content":"{\"name\":\"Ada\"}"}}],` +
+		`"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"Ada"}`, completion.Content)
+	assert.Empty(t, completion.ToolCalls)
+	assert.Equal(t, Usage{PromptTokens: 20, CompletionTokens: 5, TotalTokens: 25}, completion.Usage)
+
+	// Responses that are not chat completions are the content.
+	completion, err = ParseCompletion([]byte(`{"name":"Ada"}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"Ada"}`, completion.Content)
+
+	_, err = ParseCompletion([]byte(`{"choices":[{"message":{"tool_calls":[{"id":"1","function":{"name":"f","arguments":"{"}}]}}]}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package prompt
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+)
+
+const (
+	// Tokens added per message by the chat format (role and separators) and for priming the reply.
+	tokensPerMessage = 4
+	tokensPerReply   = 3
+
+	// defaultReserveOutputTokens is used if the template does not reserve output tokens itself.
+	defaultReserveOutputTokens = 512
+
+	// VariableInput names the user input in a template's list of truncatable variables.
+	VariableInput = "input"
+)
+
+// Budget describes the context window of a model a prompt request has to fit in.
+type Budget struct {
+	ContextLength int
+	Counter       tokenizer.Counter
+}
+
+// BudgetConfig is the template part that controls how a prompt is fitted into the context window.
+type BudgetConfig struct {
+	ReserveOutputTokens int      `yaml:"reserveOutputTokens"` // optional; tokens kept free for the completion
+	Truncate            []string `yaml:"truncate"`            // optional; variables that may be shortened
+}
+
+func (c BudgetConfig) isTruncatable(variable string) bool {
+	return slices.Contains(c.Truncate, variable)
+}
+
+// BudgetError is returned if the fixed parts of a prompt request do not fit into the context window.
+type BudgetError struct {
+	Required      int
+	Available     int
+	ContextLength int
+	Reserved      int
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("prompt requires %d tokens but only %d are available (context length %d, reserved for output %d)",
+		e.Required, e.Available, e.ContextLength, e.Reserved)
+}
+
+// fitToBudget shortens the request until it fits the budget. The oldest conversation turns are
+// dropped first, then the designated variables are truncated. Leading instructions and the final
+// message are never dropped.
+func fitToBudget(request *PromptRequest, budget Budget, reserve int) error {
+	available := budget.ContextLength - reserve
+	budgetErr := func(required int) error {
+		return &BudgetError{
+			Required:      required,
+			Available:     available,
+			ContextLength: budget.ContextLength,
+			Reserved:      reserve,
+		}
+	}
+
+	// Fixed parts are all messages that cannot be dropped, truncatable ones only with their overhead.
+	fixed := tokensPerReply
+	for _, msg := range request.Messages {
+		if msg.history {
+			continue
+		}
+		fixed += tokensPerMessage
+		if !msg.truncatable {
+			fixed += budget.Counter.Count(msg.Content)
+		}
+	}
+	if fixed > available {
+		return budgetErr(fixed)
+	}
+
+	// Drop the oldest conversation turns.
+	total := countRequestTokens(request.Messages, budget.Counter)
+	for total > available {
+		i := oldestTurn(request.Messages)
+		if i < 0 {
+			break
+		}
+
+		total -= tokensPerMessage + budget.Counter.Count(request.Messages[i].Content)
+		request.Messages = append(request.Messages[:i], request.Messages[i+1:]...)
+	}
+
+	// Truncate the designated variables, starting with the last one.
+	for i := len(request.Messages) - 1; i >= 0 && total > available; i-- {
+		msg := &request.Messages[i]
+		if !msg.truncatable {
+			continue
+		}
+
+		tokens := budget.Counter.Count(msg.Content)
+		keep := max(tokens-(total-available), 0)
+		msg.Content = budget.Counter.Truncate(msg.Content, keep)
+		total -= tokens - budget.Counter.Count(msg.Content)
+	}
+
+	if total > available {
+		return budgetErr(total)
+	}
+
+	return nil
+}
+
+// countRequestTokens counts the tokens of all messages including the chat format overhead.
+func countRequestTokens(messages []Message, counter tokenizer.Counter) int {
+	total := tokensPerReply
+	for _, msg := range messages {
+		total += tokensPerMessage + counter.Count(msg.Content)
+	}
+
+	return total
+}
+
+// oldestTurn returns the index of the oldest droppable conversation turn or -1 if there is none.
+func oldestTurn(messages []Message) int {
+	for i, msg := range messages {
+		if msg.history {
+			return i
+		}
+	}
+
+	return -1
+}
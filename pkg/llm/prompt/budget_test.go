@@ -0,0 +1,106 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+)
+
+// One token per character keeps the expected numbers readable.
+var testCounter = tokenizer.NewHeuristicCounter(1)
+
+func TestFitToBudgetFits(t *testing.T) {
+	request := PromptRequest{
+		Messages: []Message{
+			{Role: "developer", Content: "abc"},
+			{Role: "user", Content: "def"},
+		},
+	}
+
+	err := fitToBudget(&request, Budget{ContextLength: 100, Counter: testCounter}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, request.Messages, 2)
+}
+
+func TestFitToBudgetDropsOldestTurns(t *testing.T) {
+	request := PromptRequest{
+		Messages: []Message{
+			{Role: "developer", Content: "abc"},
+			{Role: "user", Content: "old question", history: true},
+			{Role: "assistant", Content: "old answer", history: true},
+			{Role: "user", Content: "new question"},
+		},
+	}
+
+	// developer (3+4) + answer (10+4) + question (12+4) + reply (3) = 40
+	err := fitToBudget(&request, Budget{ContextLength: 50, Counter: testCounter}, 10)
+	require.NoError(t, err)
+	require.Len(t, request.Messages, 3)
+	assert.Equal(t, "old answer", request.Messages[1].Content)
+	assert.Equal(t, "new question", request.Messages[2].Content)
+}
+
+func TestFitToBudgetDropsTurnsOfLongConversations(t *testing.T) {
+	messages := []Message{{Role: "developer", Content: "abc"}}
+	for range 20 {
+		messages = append(messages, Message{Role: "user", Content: "h", history: true})
+	}
+	request := PromptRequest{Messages: append(messages, Message{Role: "user", Content: "hi"})}
+
+	// Only the overhead of the turns exceeds the budget: developer (3+4) + input (2+4) + reply (3) = 16
+	// fixed tokens, each turn adds 5.
+	err := fitToBudget(&request, Budget{ContextLength: 40, Counter: testCounter}, 10)
+	require.NoError(t, err)
+	require.Len(t, request.Messages, 4)
+	assert.Equal(t, "hi", request.Messages[3].Content)
+}
+
+func TestFitToBudgetTruncatesVariables(t *testing.T) {
+	request := PromptRequest{
+		Messages: []Message{
+			{Role: "developer", Content: "abc"},
+			{Role: "user", Content: strings.Repeat("x", 100), truncatable: true},
+		},
+	}
+
+	// developer (3+4) + user overhead (4) + reply (3) = 14 fixed tokens
+	err := fitToBudget(&request, Budget{ContextLength: 40, Counter: testCounter}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("x", 16), request.Messages[1].Content)
+}
+
+func TestFitToBudgetFixedPartsTooLarge(t *testing.T) {
+	request := PromptRequest{
+		Messages: []Message{
+			{Role: "developer", Content: strings.Repeat("x", 100)},
+			{Role: "user", Content: "hello", truncatable: true},
+		},
+	}
+
+	err := fitToBudget(&request, Budget{ContextLength: 60, Counter: testCounter}, 10)
+
+	var budgetErr *BudgetError
+	require.ErrorAs(t, err, &budgetErr)
+	assert.Equal(t, 111, budgetErr.Required)
+	assert.Equal(t, 50, budgetErr.Available)
+}
+
+func TestBuildPromptRequestWithBudget(t *testing.T) {
+	pb, err := NewPromptBuilder([]string{"prompts/promptTemplateDefault.yaml"})
+	require.NoError(t, err)
+
+	model := "llama-3-1b-chat"
+	pb.SetBudget(model, Budget{ContextLength: 600, Counter: testCounter})
+
+	req, err := pb.BuildPromptRequest(strings.Repeat("x", 1000), model, "chat")
+	require.NoError(t, err)
+	assert.Equal(t, 512, req.MaxTokens)
+	assert.LessOrEqual(t, countRequestTokens(req.Messages, testCounter), 600-512)
+
+	pb.SetBudget(model, Budget{ContextLength: 520, Counter: testCounter})
+	_, err = pb.BuildPromptRequest("hello", model, "chat")
+	assert.ErrorAs(t, err, new(*BudgetError))
+}
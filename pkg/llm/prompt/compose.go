@@ -0,0 +1,185 @@
+package prompt
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// fragmentPattern matches a fragment reference like {{> safetyPreamble}} in a template's content.
+var fragmentPattern = regexp.MustCompile(`\{\{>\s*([\w.-]+)\s*\}\}`)
+
+// templateFile is the content of a prompt file. A file holds a template, shared fragments or both.
+type templateFile struct {
+	PromptTemplate `yaml:",inline"`
+	Fragments      map[string]string `yaml:"fragments"` // optional; reusable text referenced by name
+}
+
+// isAbstract reports whether the template only serves as a base for other templates.
+func (t PromptTemplate) isAbstract() bool {
+	return t.Model == "" && t.Task == ""
+}
+
+// composer resolves inheritance and fragment references of all loaded templates.
+type composer struct {
+	fragments map[string]string
+	named     map[string]PromptTemplate
+}
+
+func newComposer() *composer {
+	return &composer{
+		fragments: make(map[string]string),
+		named:     make(map[string]PromptTemplate),
+	}
+}
+
+// add registers the fragments and the named template of a file.
+func (c *composer) add(file string, tf templateFile) error {
+	for name, content := range tf.Fragments {
+		if _, exists := c.fragments[name]; exists {
+			return fmt.Errorf("duplicate fragment %q in %s", name, file)
+		}
+		c.fragments[name] = content
+	}
+
+	if tf.Name != "" {
+		if _, exists := c.named[tf.Name]; exists {
+			return fmt.Errorf("duplicate template name %q in %s", tf.Name, file)
+		}
+		c.named[tf.Name] = tf.PromptTemplate
+	}
+
+	return nil
+}
+
+// resolve applies the template's base templates and expands all fragment references.
+func (c *composer) resolve(template PromptTemplate) (PromptTemplate, error) {
+	resolved, err := c.resolveExtends(template, nil)
+	if err != nil {
+		return PromptTemplate{}, err
+	}
+
+	resolved.Roles.Developer.Content, err = c.expand(resolved.Roles.Developer.Content, nil)
+	if err != nil {
+		return PromptTemplate{}, fmt.Errorf("developer role: %w", err)
+	}
+
+	if resolved.Roles.Assistant != nil {
+		assistant := *resolved.Roles.Assistant
+		assistant.Content, err = c.expand(assistant.Content, nil)
+		if err != nil {
+			return PromptTemplate{}, fmt.Errorf("assistant role: %w", err)
+		}
+		resolved.Roles.Assistant = &assistant
+	}
+
+	return resolved, nil
+}
+
+// resolveExtends merges the template onto its base templates, following the chain up to its root.
+func (c *composer) resolveExtends(template PromptTemplate, chain []string) (PromptTemplate, error) {
+	if template.Extends == "" {
+		return template, nil
+	}
+
+	if template.Name != "" {
+		chain = append(chain, template.Name)
+	}
+	if slices.Contains(chain, template.Extends) {
+		return PromptTemplate{}, fmt.Errorf("template inheritance cycle: %s -> %s", strings.Join(chain, " -> "), template.Extends)
+	}
+
+	base, exists := c.named[template.Extends]
+	if !exists {
+		return PromptTemplate{}, fmt.Errorf("base template %q not found", template.Extends)
+	}
+
+	base, err := c.resolveExtends(base, chain)
+	if err != nil {
+		return PromptTemplate{}, err
+	}
+
+	return mergeTemplates(base, template), nil
+}
+
+// expand replaces all fragment references in the content. Fragments may reference other fragments.
+func (c *composer) expand(content string, chain []string) (string, error) {
+	var expandErr error
+	expanded := fragmentPattern.ReplaceAllStringFunc(content, func(ref string) string {
+		if expandErr != nil {
+			return ref
+		}
+
+		name := fragmentPattern.FindStringSubmatch(ref)[1]
+		if slices.Contains(chain, name) {
+			expandErr = fmt.Errorf("fragment cycle: %s -> %s", strings.Join(chain, " -> "), name)
+			return ref
+		}
+
+		fragment, exists := c.fragments[name]
+		if !exists {
+			expandErr = fmt.Errorf("fragment %q not found", name)
+			return ref
+		}
+
+		result, err := c.expand(fragment, append(chain, name))
+		if err != nil {
+			expandErr = err
+			return ref
+		}
+
+		return result
+	})
+
+	return expanded, expandErr
+}
+
+// mergeTemplates overrides the fields of the base with all fields that are set in the child.
+func mergeTemplates(base, child PromptTemplate) PromptTemplate {
+	merged := base
+	merged.Name = child.Name
+	merged.Extends = child.Extends
+
+	if child.Model != "" {
+		merged.Model = child.Model
+	}
+	if child.Task != "" {
+		merged.Task = child.Task
+	}
+	if child.Version != "" {
+		merged.Version = child.Version
+	}
+	if child.Weight != nil {
+		merged.Weight = child.Weight
+	}
+	if child.Config.Temperature != nil {
+		merged.Config.Temperature = child.Config.Temperature
+	}
+	if child.Config.SchemaInstructions != "" {
+		merged.Config.SchemaInstructions = child.Config.SchemaInstructions
+	}
+	if child.Roles.Developer.Content != "" {
+		merged.Roles.Developer = child.Roles.Developer
+	}
+	if child.Roles.Assistant != nil {
+		merged.Roles.Assistant = child.Roles.Assistant
+	}
+	if child.Budget.ReserveOutputTokens != 0 {
+		merged.Budget.ReserveOutputTokens = child.Budget.ReserveOutputTokens
+	}
+	if child.Budget.Truncate != nil {
+		merged.Budget.Truncate = child.Budget.Truncate
+	}
+	if child.Tools != nil {
+		merged.Tools = child.Tools
+	}
+	if child.Retrieval != nil {
+		merged.Retrieval = child.Retrieval
+	}
+	if child.OutputGuards != nil {
+		merged.OutputGuards = child.OutputGuards
+	}
+
+	return merged
+}
@@ -0,0 +1,99 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPromptTemplatesComposition(t *testing.T) {
+	templates, err := loadPromptTemplates([]string{
+		"prompts/fragments.yaml",
+		"prompts/promptTemplateBase.yaml",
+		"prompts/promptTemplatePerson.yaml",
+	})
+	require.NoError(t, err)
+
+	// The abstract base template is not registered as a task.
+	require.Len(t, templates, 1)
+
+	versions := templates[generatePromptKey("llama-3-1b-chat", "person")]
+	require.Len(t, versions, 1)
+	person := versions[0]
+
+	assert.Equal(t, "v1", person.Version)
+	assert.Contains(t, person.Roles.Developer.Content, "You are a helpful assistant.")
+	assert.Contains(t, person.Roles.Developer.Content, "Extract the person")
+	assert.NotContains(t, person.Roles.Developer.Content, "{{>")
+
+	// Inherited from the base template.
+	require.NotNil(t, person.Config.Temperature)
+	assert.Equal(t, 0.2, *person.Config.Temperature)
+	assert.Equal(t, 256, person.Budget.ReserveOutputTokens)
+	assert.Equal(t, []string{"input"}, person.Budget.Truncate)
+}
+
+func TestLoadPromptTemplatesCompositionErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name: "unknown fragment",
+			files: map[string]string{
+				"a.yaml": "model: m\ntask: t\nroles:\n  developer:\n    content: '{{> missing}}'\n",
+			},
+			err: `fragment "missing" not found`,
+		},
+		{
+			name: "fragment cycle",
+			files: map[string]string{
+				"f.yaml": "fragments:\n  a: '{{> b}}'\n  b: '{{> a}}'\n",
+				"t.yaml": "model: m\ntask: t\nroles:\n  developer:\n    content: '{{> a}}'\n",
+			},
+			err: "fragment cycle: a -> b -> a",
+		},
+		{
+			name: "unknown base template",
+			files: map[string]string{
+				"a.yaml": "extends: missing\nmodel: m\ntask: t\n",
+			},
+			err: `base template "missing" not found`,
+		},
+		{
+			name: "inheritance cycle",
+			files: map[string]string{
+				"a.yaml": "name: a\nextends: b\n",
+				"b.yaml": "name: b\nextends: a\n",
+				"t.yaml": "extends: a\nmodel: m\ntask: t\n",
+			},
+			err: "template inheritance cycle: a -> b -> a",
+		},
+		{
+			name: "duplicate fragment",
+			files: map[string]string{
+				"a.yaml": "fragments:\n  a: x\n",
+				"b.yaml": "fragments:\n  a: y\n",
+			},
+			err: `duplicate fragment "a"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var files []string
+			for _, name := range []string{"a.yaml", "b.yaml", "f.yaml", "t.yaml"} {
+				if content, ok := tc.files[name]; ok {
+					files = append(files, writeTemplate(t, dir, name, content))
+				}
+			}
+
+			_, err := loadPromptTemplates(files)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
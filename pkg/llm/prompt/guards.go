@@ -0,0 +1,110 @@
+package prompt
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// OutputGuardsConfig declares the checks of a task's response that run after schema validation.
+// Checks without config are skipped. The action of a check is flag or block, the default.
+type OutputGuardsConfig struct {
+	MaxLength *MaxLengthCheck `yaml:"maxLength"` // optional; limits the characters of the response
+	Banned    *BannedCheck    `yaml:"banned"`    // optional; terms and regular expressions the response must not contain
+	URLs      *URLCheck       `yaml:"urls"`      // optional; hosts links in the response may point to
+	Refusal   *RefusalCheck   `yaml:"refusal"`   // optional; detects answers where the model refuses the task
+	Language  *LanguageCheck  `yaml:"language"`  // optional; languages the response may be written in
+	Judge     *JudgeCheck     `yaml:"judge"`     // optional; asks a model to grade the response with a rubric
+}
+
+// MaxLengthCheck limits the length of the response.
+type MaxLengthCheck struct {
+	Chars  int    `yaml:"chars"`
+	Action string `yaml:"action"`
+}
+
+// BannedCheck rejects responses with banned terms or matching patterns. Terms match case-insensitive
+// as whole words.
+type BannedCheck struct {
+	Terms    []string `yaml:"terms"`
+	Patterns []string `yaml:"patterns"`
+	Action   string   `yaml:"action"`
+}
+
+// URLCheck rejects links to hosts that are not allowed. A host allows its subdomains.
+type URLCheck struct {
+	Allow  []string `yaml:"allow"`
+	Action string   `yaml:"action"`
+}
+
+// RefusalCheck detects refusals like "I cannot help with that".
+type RefusalCheck struct {
+	Phrases []string `yaml:"phrases"` // optional; phrases added to the built-in ones
+	Action  string   `yaml:"action"`
+}
+
+// LanguageCheck rejects responses in other languages, given as ISO 639-1 codes.
+type LanguageCheck struct {
+	Allow  []string `yaml:"allow"`
+	Action string   `yaml:"action"`
+}
+
+// JudgeCheck asks a model whether the response meets the rubric.
+type JudgeCheck struct {
+	Model  string `yaml:"model"` // logical name of the judge model
+	Rubric string `yaml:"rubric"`
+	Action string `yaml:"action"`
+	// FailOpen flags the response instead of failing the request if the judge fails.
+	FailOpen bool `yaml:"failOpen"`
+}
+
+// validateOutputGuards checks the output guards of a template when it is loaded, so mistakes do not
+// surface on the first request of the task.
+func validateOutputGuards(cfg *OutputGuardsConfig) error {
+	if cfg == nil {
+		return nil
+	}
+
+	var actions []string
+	if cfg.MaxLength != nil {
+		if cfg.MaxLength.Chars <= 0 {
+			return fmt.Errorf("maxLength requires chars")
+		}
+		actions = append(actions, cfg.MaxLength.Action)
+	}
+	if cfg.Banned != nil {
+		for _, pattern := range cfg.Banned.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("invalid banned pattern %q: %w", pattern, err)
+			}
+		}
+		actions = append(actions, cfg.Banned.Action)
+	}
+	if cfg.URLs != nil {
+		actions = append(actions, cfg.URLs.Action)
+	}
+	if cfg.Refusal != nil {
+		actions = append(actions, cfg.Refusal.Action)
+	}
+	if cfg.Language != nil {
+		if len(cfg.Language.Allow) == 0 {
+			return fmt.Errorf("language requires allowed languages")
+		}
+		actions = append(actions, cfg.Language.Action)
+	}
+	if cfg.Judge != nil {
+		if cfg.Judge.Model == "" || cfg.Judge.Rubric == "" {
+			return fmt.Errorf("judge requires a model and a rubric")
+		}
+		actions = append(actions, cfg.Judge.Action)
+	}
+
+	for _, action := range actions {
+		switch action {
+		case "", "flag", "block":
+		default:
+			return fmt.Errorf("unknown output guard action: %s", action)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package prompt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPromptRequestOutputGuards(t *testing.T) {
+	pb, err := NewPromptBuilder([]string{"prompts/promptTemplateDocs.yaml"})
+	require.NoError(t, err)
+	pb.SetRetriever(&fakeRetriever{})
+
+	req, err := pb.BuildPromptRequest("How many vacation days?", "llama-3-1b-chat", "docs")
+	require.NoError(t, err)
+	require.NotNil(t, req.OutputGuards)
+	assert.Equal(t, 4000, req.OutputGuards.MaxLength.Chars)
+	assert.Equal(t, []string{"example.com"}, req.OutputGuards.URLs.Allow)
+	assert.Equal(t, "flag", req.OutputGuards.URLs.Action)
+	assert.NotNil(t, req.OutputGuards.Refusal)
+	assert.Nil(t, req.OutputGuards.Judge)
+}
+
+func TestLoadPromptTemplatesInvalidOutputGuards(t *testing.T) {
+	testCases := map[string]string{
+		"missing chars":     "outputGuards:\n  maxLength: {}\n",
+		"invalid pattern":   "outputGuards:\n  banned:\n    patterns: [\"(\"]\n",
+		"unknown action":    "outputGuards:\n  refusal:\n    action: drop\n",
+		"missing languages": "outputGuards:\n  language: {}\n",
+		"missing rubric":    "outputGuards:\n  judge:\n    model: LlamaLocal\n",
+	}
+
+	for name, guards := range testCases {
+		t.Run(name, func(t *testing.T) {
+			file := writeTemplate(t, t.TempDir(), "guards.yaml", "model: m\ntask: chat\n"+guards)
+
+			_, err := loadPromptTemplates([]string{file})
+			assert.ErrorContains(t, err, "invalid output guards")
+		})
+	}
+}
@@ -1,13 +1,29 @@
 package prompt
 
-import "fmt"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
 
 type Prompt interface {
-	BuildPromptRequest(userInput, model, task string) (PromptRequest, error)
+	BuildPromptRequest(userInput, model, task string, opts ...BuildOption) (PromptRequest, error)
+}
+
+// SchemaDescriber renders a response schema as output format instructions for the model.
+type SchemaDescriber interface {
+	DescribeSchema(schema, format string) (string, error)
+	JSONSchema(schema string) (json.RawMessage, error)
 }
 
 type PromptBuilder struct {
-	promptTemplates map[string]PromptTemplate
+	promptTemplates  map[string][]PromptTemplate
+	budgets          map[string]Budget
+	structuredOutput map[string]bool // models that get the response schema as JSON Schema
+	describer        SchemaDescriber
+	retriever        Retriever
 }
 
 func NewPromptBuilder(files []string) (*PromptBuilder, error) {
@@ -17,40 +33,294 @@ func NewPromptBuilder(files []string) (*PromptBuilder, error) {
 	}
 
 	return &PromptBuilder{
-		promptTemplates: promptTemplates,
+		promptTemplates:  promptTemplates,
+		budgets:          make(map[string]Budget),
+		structuredOutput: make(map[string]bool),
 	}, nil
 }
 
+// SetBudget enforces the context window of the given model on all prompt requests built for it.
+func (pb *PromptBuilder) SetBudget(model string, budget Budget) {
+	pb.budgets[model] = budget
+}
+
+// SetStructuredOutput attaches the response schema as JSON Schema to all prompt requests built for
+// the given model. Models without structured output support only validate the response afterwards.
+func (pb *PromptBuilder) SetStructuredOutput(model string) {
+	pb.structuredOutput[model] = true
+}
+
+// SetSchemaDescriber enables output format instructions for templates that ask for them.
+func (pb *PromptBuilder) SetSchemaDescriber(describer SchemaDescriber) {
+	pb.describer = describer
+}
+
+// BuildOption represents a prompt request build option
+type BuildOption func(*buildConfig)
+
+type buildConfig struct {
+	ctx            context.Context
+	history        []Message
+	selectionKey   string
+	responseSchema string
+}
+
+// WithContext is the context of the request, it is used to retrieve passages.
+func WithContext(ctx context.Context) BuildOption {
+	return func(c *buildConfig) {
+		c.ctx = ctx
+	}
+}
+
+// WithHistory adds previous conversation turns between the instructions and the user input.
+// Turns are dropped oldest first if the request does not fit into the model's context window.
+func WithHistory(messages []Message) BuildOption {
+	return func(c *buildConfig) {
+		c.history = messages
+	}
+}
+
+// WithSelectionKey makes the choice between several live template versions sticky, e.g. per user.
+func WithSelectionKey(key string) BuildOption {
+	return func(c *buildConfig) {
+		c.selectionKey = key
+	}
+}
+
+// WithResponseSchema names the schema the response is validated against. Templates with schema
+// instructions describe this schema in the developer message.
+func WithResponseSchema(schema string) BuildOption {
+	return func(c *buildConfig) {
+		c.responseSchema = schema
+	}
+}
+
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCalls are the tools an assistant message asked for, adapters send them in their own format.
+	ToolCalls []ToolCall `json:"-"`
+	// ToolCallID is the call a tool message answers.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+
+	history     bool // conversation turn that may be dropped
+	truncatable bool // designated variable that may be shortened
 }
 
 type PromptRequest struct {
-	Messages []Message `json:"messages"`
-	Model    string    `json:"model"`
+	Messages  []Message `json:"messages"`
+	Model     string    `json:"model"`
+	MaxTokens int       `json:"max_tokens,omitempty"`
+	// Temperature is the sampling temperature, taken from the template config. The backend default is used if unset.
+	Temperature *float64 `json:"temperature,omitempty"`
+
+	// TemplateVersion is the version of the prompt template the request was built from.
+	TemplateVersion string `json:"-"`
+	// ResponseSchema is the expected response. Models with structured output support send it to
+	// the backend in their own format.
+	ResponseSchema *ResponseSchema `json:"-"`
+	// Tools are the tools the model may call before it answers. Adapters send them in their own format.
+	Tools []Tool `json:"-"`
+	// Passages are the retrieved passages injected into the prompt, responses cite them by id.
+	Passages []Passage `json:"-"`
+	// OutputGuards are the checks of the template that run on the validated response.
+	OutputGuards *OutputGuardsConfig `json:"-"`
+}
+
+// Tool is a tool the model can call, its arguments have to be valid against a CUE schema.
+type Tool struct {
+	Name        string
+	Description string
+	// ArgumentsSchema is the name of the CUE schema the arguments are validated against.
+	ArgumentsSchema string
+	// Parameters is the JSON Schema of the arguments as sent to the model.
+	Parameters json.RawMessage
+}
+
+// ToolCall is a call of a tool requested by the model, independent of the provider's format.
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ResponseSchema is a named JSON Schema the response has to be valid against.
+type ResponseSchema struct {
+	Name   string
+	Schema json.RawMessage
 }
 
 // BuildPromptRequest builds a prompt request for the given user input and prompt template.
-func (pb *PromptBuilder) BuildPromptRequest(userInput, model, task string) (PromptRequest, error) {
+// If a budget is set for the model, the request is fitted into the model's context window.
+func (pb *PromptBuilder) BuildPromptRequest(userInput, model, task string, opts ...BuildOption) (PromptRequest, error) {
 	if userInput == "" {
 		return PromptRequest{}, fmt.Errorf("user input cannot be empty")
 	}
 
-	key := generatePromptKey(model, task)
-	developerContent := pb.promptTemplates[key].Roles.Developer.Content
+	cfg := &buildConfig{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(cfg)
+	}
 
-	return PromptRequest{
-		Messages: []Message{
-			{
-				Role:    "developer",
-				Content: developerContent,
-			},
-			{
-				Role:    "user",
-				Content: userInput,
-			},
+	versions, exists := pb.versions(model, task)
+	if !exists {
+		return PromptRequest{}, fmt.Errorf("no prompt template found for model %s and task %s", model, task)
+	}
+	template := selectTemplate(versions, cfg.selectionKey)
+
+	developerContent := template.Roles.Developer.Content
+	if template.Config.SchemaInstructions != "" && cfg.responseSchema != "" && pb.describer != nil {
+		instructions, err := pb.describer.DescribeSchema(cfg.responseSchema, template.Config.SchemaInstructions)
+		if err != nil {
+			return PromptRequest{}, fmt.Errorf("failed to describe response schema: %w", err)
+		}
+		developerContent = strings.TrimSpace(developerContent + "\n\n" + instructions)
+	}
+
+	var passages []Passage
+	if template.Retrieval != nil {
+		var err error
+		passages, err = pb.retrieve(cfg.ctx, template.Retrieval, model, userInput)
+		if err != nil {
+			return PromptRequest{}, err
+		}
+		if len(passages) > 0 {
+			developerContent = strings.TrimSpace(developerContent + "\n\n" + formatContext(template.Retrieval, passages))
+		}
+	}
+
+	var responseSchema *ResponseSchema
+	if cfg.responseSchema != "" && pb.describer != nil && pb.structuredOutput[model] {
+		schema, err := pb.describer.JSONSchema(cfg.responseSchema)
+		if err != nil {
+			return PromptRequest{}, fmt.Errorf("failed to get response schema: %w", err)
+		}
+		responseSchema = &ResponseSchema{
+			Name:   cfg.responseSchema,
+			Schema: schema,
+		}
+	}
+
+	tools, err := pb.buildTools(template.Tools)
+	if err != nil {
+		return PromptRequest{}, err
+	}
+
+	messages := []Message{
+		{
+			Role:    "developer",
+			Content: developerContent,
 		},
-		Model: model,
-	}, nil
+	}
+	for _, turn := range cfg.history {
+		turn.history = true
+		messages = append(messages, turn)
+	}
+	messages = append(messages, Message{
+		Role:        "user",
+		Content:     userInput,
+		truncatable: template.Budget.isTruncatable(VariableInput),
+	})
+
+	request := PromptRequest{
+		Messages:        messages,
+		Model:           model,
+		Temperature:     template.Config.Temperature,
+		TemplateVersion: template.Version,
+		ResponseSchema:  responseSchema,
+		Tools:           tools,
+		Passages:        passages,
+		OutputGuards:    template.OutputGuards,
+	}
+
+	budget, exists := pb.budgets[model]
+	if !exists {
+		return request, nil
+	}
+
+	reserve := template.Budget.ReserveOutputTokens
+	if reserve <= 0 {
+		reserve = defaultReserveOutputTokens
+	}
+	request.MaxTokens = reserve
+
+	if err := fitToBudget(&request, budget, reserve); err != nil {
+		return PromptRequest{}, fmt.Errorf("prompt does not fit context window of %s: %w", model, err)
+	}
+
+	return request, nil
+}
+
+// versions returns the template versions of the model and task. Models without their own template
+// use the template of the task that declares no model.
+func (pb *PromptBuilder) versions(model, task string) ([]PromptTemplate, bool) {
+	if versions, exists := pb.promptTemplates[generatePromptKey(model, task)]; exists {
+		return versions, true
+	}
+
+	versions, exists := pb.promptTemplates[generatePromptKey("", task)]
+	return versions, exists
+}
+
+// HasTemplate reports whether a prompt request can be built for the model and task.
+func (pb *PromptBuilder) HasTemplate(model, task string) bool {
+	_, exists := pb.versions(model, task)
+	return exists
+}
+
+// Tasks returns the tasks of all loaded templates in alphabetical order.
+func (pb *PromptBuilder) Tasks() []string {
+	var tasks []string
+	for _, versions := range pb.promptTemplates {
+		if task := versions[0].Task; !slices.Contains(tasks, task) {
+			tasks = append(tasks, task)
+		}
+	}
+	slices.Sort(tasks)
+
+	return tasks
+}
+
+// Tools returns the distinct tools declared in the templates, sorted by name.
+func (pb *PromptBuilder) Tools() []ToolTemplate {
+	var tools []ToolTemplate
+	for _, versions := range pb.promptTemplates {
+		for _, template := range versions {
+			for _, tool := range template.Tools {
+				if !slices.ContainsFunc(tools, func(t ToolTemplate) bool { return t == tool }) {
+					tools = append(tools, tool)
+				}
+			}
+		}
+	}
+	slices.SortFunc(tools, func(a, b ToolTemplate) int { return strings.Compare(a.Name, b.Name) })
+
+	return tools
+}
+
+// buildTools resolves the argument schemas of the template's tools into JSON Schemas.
+func (pb *PromptBuilder) buildTools(templates []ToolTemplate) ([]Tool, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	if pb.describer == nil {
+		return nil, fmt.Errorf("tools require a schema describer for their arguments")
+	}
+
+	tools := make([]Tool, 0, len(templates))
+	for _, t := range templates {
+		parameters, err := pb.describer.JSONSchema(t.Arguments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get arguments schema of tool %s: %w", t.Name, err)
+		}
+
+		tools = append(tools, Tool{
+			Name:            t.Name,
+			Description:     t.Description,
+			ArgumentsSchema: t.Arguments,
+			Parameters:      parameters,
+		})
+	}
+
+	return tools, nil
 }
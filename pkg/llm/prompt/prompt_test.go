@@ -1,9 +1,13 @@
 package prompt
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewPromptBuilder(t *testing.T) {
@@ -30,3 +34,132 @@ func TestBuildPromptRequest(t *testing.T) {
 	}
 
 }
+
+func TestBuildPromptRequestTemplateVersion(t *testing.T) {
+	pb, err := NewPromptBuilder([]string{"prompts/promptTemplateDefault.yaml"})
+	assert.NoError(t, err)
+
+	req, err := pb.BuildPromptRequest("Hello Model", "llama-3-1b-chat", "chat", WithSelectionKey("user-1"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", req.TemplateVersion)
+
+	_, err = pb.BuildPromptRequest("Hello Model", "llama-3-1b-chat", "unknown-task")
+	assert.Error(t, err)
+}
+
+type fakeDescriber struct{}
+
+func (fakeDescriber) DescribeSchema(schema, format string) (string, error) {
+	return format + " description of " + schema, nil
+}
+
+func (fakeDescriber) JSONSchema(schema string) (json.RawMessage, error) {
+	return json.RawMessage(`{"type":"object"}`), nil
+}
+
+func TestBuildPromptRequestSchemaInstructions(t *testing.T) {
+	pb, err := NewPromptBuilder([]string{
+		"prompts/fragments.yaml",
+		"prompts/promptTemplateBase.yaml",
+		"prompts/promptTemplatePerson.yaml",
+	})
+	assert.NoError(t, err)
+
+	// Without a describer the developer message is left as is.
+	req, err := pb.BuildPromptRequest("Ron is 56", "llama-3-1b-chat", "person", WithResponseSchema("personResponse"))
+	assert.NoError(t, err)
+	assert.NotContains(t, req.Messages[0].Content, "description of")
+
+	pb.SetSchemaDescriber(fakeDescriber{})
+	req, err = pb.BuildPromptRequest("Ron is 56", "llama-3-1b-chat", "person", WithResponseSchema("personResponse"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(req.Messages[0].Content, "\n\ntext description of personResponse"))
+	assert.Nil(t, req.ResponseSchema)
+}
+
+type unknownSchemaDescriber struct {
+	fakeDescriber
+}
+
+func (unknownSchemaDescriber) JSONSchema(schema string) (json.RawMessage, error) {
+	return nil, fmt.Errorf("unknown schema: %s", schema)
+}
+
+func TestBuildPromptRequestStructuredOutput(t *testing.T) {
+	pb, err := NewPromptBuilder([]string{"prompts/promptTemplateDefault.yaml"})
+	require.NoError(t, err)
+
+	// Models without structured output support never need the JSON Schema.
+	pb.SetSchemaDescriber(unknownSchemaDescriber{})
+	req, err := pb.BuildPromptRequest("Hello Model", "llama-3-1b-chat", "chat", WithResponseSchema("unknown"))
+	require.NoError(t, err)
+	assert.Nil(t, req.ResponseSchema)
+
+	pb.SetStructuredOutput("llama-3-1b-chat")
+	_, err = pb.BuildPromptRequest("Hello Model", "llama-3-1b-chat", "chat", WithResponseSchema("unknown"))
+	assert.ErrorContains(t, err, "failed to get response schema")
+
+	pb.SetSchemaDescriber(fakeDescriber{})
+	req, err = pb.BuildPromptRequest("Hello Model", "llama-3-1b-chat", "chat", WithResponseSchema("personResponse"))
+	require.NoError(t, err)
+	assert.Equal(t, "personResponse", req.ResponseSchema.Name)
+	assert.JSONEq(t, `{"type":"object"}`, string(req.ResponseSchema.Schema))
+}
+
+func TestBuildPromptRequestTemperature(t *testing.T) {
+	dir := t.TempDir()
+	fixed := writeTemplate(t, dir, "fixed.yaml", "model: m\ntask: chat\nconfig:\n  temperature: 0.2\n")
+	unset := writeTemplate(t, dir, "unset.yaml", "model: m\ntask: summary\n")
+
+	pb, err := NewPromptBuilder([]string{fixed, unset})
+	require.NoError(t, err)
+
+	req, err := pb.BuildPromptRequest("Hello Model", "m", "chat")
+	require.NoError(t, err)
+	require.NotNil(t, req.Temperature)
+	assert.Equal(t, 0.2, *req.Temperature)
+
+	// Without a temperature in the template the backend default is used.
+	req, err = pb.BuildPromptRequest("Hello Model", "m", "summary")
+	require.NoError(t, err)
+	assert.Nil(t, req.Temperature)
+}
+
+func TestBuildPromptRequestFallbackTemplate(t *testing.T) {
+	dir := t.TempDir()
+	own := writeTemplate(t, dir, "own.yaml", "model: m\ntask: chat\nroles:\n  developer:\n    content: own\n")
+	fallback := writeTemplate(t, dir, "fallback.yaml", "task: chat\nroles:\n  developer:\n    content: fallback\n")
+
+	pb, err := NewPromptBuilder([]string{own, fallback})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chat"}, pb.Tasks())
+	assert.True(t, pb.HasTemplate("other", "chat"))
+	assert.False(t, pb.HasTemplate("other", "person"))
+
+	// The model's own template wins over the template without a model.
+	req, err := pb.BuildPromptRequest("Hello Model", "m", "chat")
+	require.NoError(t, err)
+	assert.Equal(t, "own", req.Messages[0].Content)
+
+	req, err = pb.BuildPromptRequest("Hello Model", "other", "chat")
+	require.NoError(t, err)
+	assert.Equal(t, "other", req.Model)
+	assert.Equal(t, "fallback", req.Messages[0].Content)
+}
+
+func TestBuildPromptRequestTools(t *testing.T) {
+	pb, err := NewPromptBuilder([]string{"prompts/promptTemplateWeather.yaml"})
+	require.NoError(t, err)
+
+	// The arguments schemas of tools are resolved by the describer.
+	_, err = pb.BuildPromptRequest("Is it raining in Berlin?", "llama-3-1b-chat", "weather")
+	assert.ErrorContains(t, err, "tools require a schema describer")
+
+	pb.SetSchemaDescriber(fakeDescriber{})
+	req, err := pb.BuildPromptRequest("Is it raining in Berlin?", "llama-3-1b-chat", "weather")
+	require.NoError(t, err)
+	require.Len(t, req.Tools, 1)
+	assert.Equal(t, "get_weather", req.Tools[0].Name)
+	assert.Equal(t, "weatherArguments", req.Tools[0].ArgumentsSchema)
+	assert.JSONEq(t, `{"type":"object"}`, string(req.Tools[0].Parameters))
+}
@@ -0,0 +1,140 @@
+package prompt
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+)
+
+const (
+	defaultRetrievalTopK             = 4
+	defaultRetrievalMaxContextTokens = 1024
+	defaultRetrievalInstructions     = "Answer using the following passages. Cite every passage you use by its id in square brackets, e.g. [%s]."
+)
+
+// Passage is a retrieved piece of a document that is injected into the prompt.
+type Passage struct {
+	// ID identifies the passage in citations.
+	ID    string
+	Text  string
+	Score float64
+}
+
+// Retriever finds the passages most relevant to a query.
+type Retriever interface {
+	Retrieve(ctx context.Context, query string, topK int, filters map[string]string) ([]Passage, error)
+}
+
+// RetrievalConfig is the template part that declares which passages are injected into the prompt.
+type RetrievalConfig struct {
+	TopK             int               `yaml:"topK"`             // optional; passages retrieved, default 4
+	Filters          map[string]string `yaml:"filters"`          // optional; metadata the passages must have, e.g. dir: handbook
+	MinScore         float64           `yaml:"minScore"`         // optional; minimum similarity of a passage
+	MaxContextTokens int               `yaml:"maxContextTokens"` // optional; tokens of all passages, default 1024
+	Instructions     string            `yaml:"instructions"`     // optional; text before the passages, %s is an example id
+}
+
+// SetRetriever enables templates with a retrieval block.
+func (pb *PromptBuilder) SetRetriever(retriever Retriever) {
+	pb.retriever = retriever
+}
+
+// CheckRetrieval fails if a template has a retrieval block but no retriever is set, its prompts could
+// never be built.
+func (pb *PromptBuilder) CheckRetrieval() error {
+	if pb.retriever != nil {
+		return nil
+	}
+
+	// Sorted for a stable error if several tasks need retrieval.
+	for _, name := range slices.Sorted(maps.Keys(pb.promptTemplates)) {
+		for _, template := range pb.promptTemplates[name] {
+			if template.Retrieval != nil {
+				return fmt.Errorf("task %s needs retrieval, which is not configured", template.Task)
+			}
+		}
+	}
+
+	return nil
+}
+
+// retrieve returns the passages for the user input that fit into the context tokens of the template.
+func (pb *PromptBuilder) retrieve(ctx context.Context, cfg *RetrievalConfig, model, userInput string) ([]Passage, error) {
+	if pb.retriever == nil {
+		return nil, fmt.Errorf("template requires a retriever")
+	}
+
+	topK := cfg.TopK
+	if topK <= 0 {
+		topK = defaultRetrievalTopK
+	}
+	maxTokens := cfg.MaxContextTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultRetrievalMaxContextTokens
+	}
+
+	candidates, err := pb.retriever.Retrieve(ctx, userInput, topK, cfg.Filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve passages: %w", err)
+	}
+
+	var counter tokenizer.Counter = tokenizer.NewHeuristicCounter(0)
+	if budget, ok := pb.budgets[model]; ok {
+		counter = budget.Counter
+	}
+
+	var passages []Passage
+	used := 0
+	for _, passage := range candidates {
+		if passage.Score < cfg.MinScore {
+			continue
+		}
+		tokens := counter.Count(formatPassage(passage))
+		if used+tokens > maxTokens {
+			break
+		}
+		used += tokens
+		passages = append(passages, passage)
+	}
+
+	return passages, nil
+}
+
+// formatContext renders the passages with their ids for the developer message.
+func formatContext(cfg *RetrievalConfig, passages []Passage) string {
+	instructions := cfg.Instructions
+	if instructions == "" {
+		instructions = defaultRetrievalInstructions
+	}
+
+	// The instructions are no format string, other percent signs are kept as they are.
+	var b strings.Builder
+	b.WriteString(strings.Replace(instructions, "%s", passages[0].ID, 1))
+	for _, passage := range passages {
+		b.WriteString("\n\n")
+		b.WriteString(formatPassage(passage))
+	}
+
+	return b.String()
+}
+
+func formatPassage(passage Passage) string {
+	return fmt.Sprintf("[%s] %s", passage.ID, passage.Text)
+}
+
+// Citations returns the ids of the passages the response cites in square brackets, in the order of
+// the passages.
+func Citations(response string, passages []Passage) []string {
+	var cited []string
+	for _, passage := range passages {
+		if strings.Contains(response, "["+passage.ID+"]") {
+			cited = append(cited, passage.ID)
+		}
+	}
+
+	return cited
+}
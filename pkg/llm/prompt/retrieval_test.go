@@ -0,0 +1,93 @@
+package prompt
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRetriever struct {
+	passages []Passage
+	query    string
+	topK     int
+	filters  map[string]string
+}
+
+func (r *fakeRetriever) Retrieve(ctx context.Context, query string, topK int, filters map[string]string) ([]Passage, error) {
+	r.query, r.topK, r.filters = query, topK, filters
+	return r.passages, nil
+}
+
+func TestBuildPromptRequestRetrieval(t *testing.T) {
+	pb, err := NewPromptBuilder([]string{"prompts/promptTemplateDocs.yaml"})
+	require.NoError(t, err)
+
+	_, err = pb.BuildPromptRequest("How many vacation days?", "llama-3-1b-chat", "docs")
+	assert.ErrorContains(t, err, "template requires a retriever")
+
+	retriever := &fakeRetriever{passages: []Passage{
+		{ID: "handbook/vacation.md#0", Text: "Employees have 30 vacation days.", Score: 0.8},
+		{ID: "handbook/vacation.md#1", Text: strings.Repeat("long ", 2000), Score: 0.7},
+		{ID: "handbook/travel.md#0", Text: "Travel is booked by the office.", Score: 0.1},
+	}}
+	pb.SetRetriever(retriever)
+
+	req, err := pb.BuildPromptRequest("How many vacation days?", "llama-3-1b-chat", "docs", WithContext(context.Background()))
+	require.NoError(t, err)
+	assert.Equal(t, "How many vacation days?", retriever.query)
+	assert.Equal(t, 4, retriever.topK)
+	assert.Equal(t, map[string]string{"dir": "handbook"}, retriever.filters)
+
+	// The second passage exceeds the context tokens and the third is below the minimum score.
+	require.Len(t, req.Passages, 1)
+	assert.Equal(t, "handbook/vacation.md#0", req.Passages[0].ID)
+	assert.Contains(t, req.Messages[0].Content, "e.g. [handbook/vacation.md#0]")
+	assert.True(t, strings.HasSuffix(req.Messages[0].Content, "\n\n[handbook/vacation.md#0] Employees have 30 vacation days."))
+}
+
+func TestBuildPromptRequestRetrievalNoPassages(t *testing.T) {
+	pb, err := NewPromptBuilder([]string{"prompts/promptTemplateDocs.yaml"})
+	require.NoError(t, err)
+	pb.SetRetriever(&fakeRetriever{})
+
+	req, err := pb.BuildPromptRequest("How many vacation days?", "llama-3-1b-chat", "docs")
+	require.NoError(t, err)
+	assert.Empty(t, req.Passages)
+	assert.Equal(t, "You answer questions about the documentation. Only use facts from the passages below.", req.Messages[0].Content)
+}
+
+func TestFormatContext(t *testing.T) {
+	passages := []Passage{{ID: "a.md#0", Text: "First."}, {ID: "b.md#0", Text: "Second."}}
+
+	// Instructions are no format string, only the first %s is the example id.
+	cfg := &RetrievalConfig{Instructions: "Use 100% of the passages, cite them as [%s], not as %s or %d."}
+	assert.Equal(t,
+		"Use 100% of the passages, cite them as [a.md#0], not as %s or %d.\n\n[a.md#0] First.\n\n[b.md#0] Second.",
+		formatContext(cfg, passages))
+
+	cfg = &RetrievalConfig{Instructions: "Answer from the passages."}
+	assert.Equal(t, "Answer from the passages.\n\n[a.md#0] First.\n\n[b.md#0] Second.", formatContext(cfg, passages))
+}
+
+func TestCheckRetrieval(t *testing.T) {
+	pb, err := NewPromptBuilder([]string{"prompts/promptTemplateDefault.yaml", "prompts/promptTemplateDocs.yaml"})
+	require.NoError(t, err)
+	assert.EqualError(t, pb.CheckRetrieval(), "task docs needs retrieval, which is not configured")
+
+	pb.SetRetriever(&fakeRetriever{})
+	assert.NoError(t, pb.CheckRetrieval())
+
+	pb, err = NewPromptBuilder([]string{"prompts/promptTemplateDefault.yaml"})
+	require.NoError(t, err)
+	assert.NoError(t, pb.CheckRetrieval())
+}
+
+func TestCitations(t *testing.T) {
+	passages := []Passage{{ID: "a.md#0"}, {ID: "a.md#1"}, {ID: "b.md#0"}}
+
+	assert.Equal(t, []string{"a.md#0", "b.md#0"}, Citations(`{"answer":"Yes [b.md#0], see [a.md#0]."}`, passages))
+	assert.Empty(t, Citations("No sources.", passages))
+}
@@ -2,26 +2,49 @@ package prompt
 
 import (
 	"embed"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math/rand/v2"
 	"os"
+	"slices"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
 //go:embed prompts/*.yaml
-var schemaFS embed.FS
+var promptFS embed.FS
 
 // PromptTemplate represents the YAML configuration structure
 type PromptTemplate struct {
-	Model  string       `yaml:"model"`
-	Task   string       `yaml:"task"`
-	Config PromptConfig `yaml:"config"`
-	Roles  Roles        `yaml:"roles"`
+	Name      string           `yaml:"name"`    // optional; identifies the template as a base for others
+	Extends   string           `yaml:"extends"` // optional; name of the base template whose fields are overridden
+	Model     string           `yaml:"model"`   // optional; without a model the template serves every model that has no template for the task
+	Task      string           `yaml:"task"`
+	Version   string           `yaml:"version"` // optional; several versions of a task can be live at once
+	Weight    *float64         `yaml:"weight"`  // optional; relative share of traffic for this version, default 1, 0 takes it out of selection
+	Config    PromptConfig     `yaml:"config"`
+	Roles     Roles            `yaml:"roles"`
+	Budget    BudgetConfig     `yaml:"budget"`
+	Tools     []ToolTemplate   `yaml:"tools"`     // optional; tools the model may call before it answers
+	Retrieval *RetrievalConfig `yaml:"retrieval"` // optional; passages retrieved for the input and injected as context
+	// OutputGuards are optional checks of the response after schema validation.
+	OutputGuards *OutputGuardsConfig `yaml:"outputGuards"`
+}
+
+// ToolTemplate declares a tool in a prompt template.
+type ToolTemplate struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Arguments   string `yaml:"arguments"` // name of the CUE schema of the arguments
 }
 
 type PromptConfig struct {
-	Temperature float64 `yaml:"temperature"`
+	Temperature        *float64 `yaml:"temperature"`
+	SchemaInstructions string   `yaml:"schemaInstructions"` // optional; describe the response schema as text or jsonschema
 }
 
 type Roles struct {
@@ -33,34 +56,172 @@ type Role struct {
 	Content string `yaml:"content"`
 }
 
+const defaultVersion = "v1"
+
 // generatePromptKey generates a unique key for a prompt template based on the model and task. This is used to identify the prompt template in the map.
 func generatePromptKey(model, task string) string {
 	return fmt.Sprintf("%s-%s", model, task)
 }
 
-func loadPromptTemplates(promptFiles []string) (map[string]PromptTemplate, error) {
-	promptTemplates := make(map[string]PromptTemplate)
+// weight returns the relative traffic share of the template.
+func (t PromptTemplate) weight() float64 {
+	if t.Weight == nil {
+		return 1
+	}
+
+	return *t.Weight
+}
+
+// loadPromptTemplates loads all template files and groups the versions of each model and task.
+// Base templates and fragments may be defined in any of the files and are resolved after all files
+// are read. Loading the same version of a model and task twice is an error.
+func loadPromptTemplates(promptFiles []string) (map[string][]PromptTemplate, error) {
+	promptTemplates := make(map[string][]PromptTemplate)
+	composer := newComposer()
 
+	var templates []PromptTemplate
+	var sources []string
 	for _, file := range promptFiles {
-		data, err := os.ReadFile(file)
+		data, err := readTemplateFile(file)
 		if err != nil {
 			return nil, fmt.Errorf("reading template file: %w", err)
 		}
 
-		var template PromptTemplate
-		if err := yaml.Unmarshal(data, &template); err != nil {
+		var tf templateFile
+		if err := yaml.Unmarshal(data, &tf); err != nil {
 			return nil, fmt.Errorf("parsing template yaml: %w", err)
 		}
 
+		if err := composer.add(file, tf); err != nil {
+			return nil, err
+		}
+
+		// Files that only hold fragments or abstract base templates do not define a task.
+		if tf.Extends == "" && tf.isAbstract() {
+			continue
+		}
+		templates = append(templates, tf.PromptTemplate)
+		sources = append(sources, file)
+	}
+
+	for i, template := range templates {
+		template, err := composer.resolve(template)
+		if err != nil {
+			return nil, fmt.Errorf("resolving template %s: %w", sources[i], err)
+		}
+		if template.isAbstract() {
+			continue
+		}
+
+		if template.Version == "" {
+			template.Version = defaultVersion
+		}
+
+		if template.weight() < 0 {
+			return nil, fmt.Errorf("negative weight %v in %s", template.weight(), sources[i])
+		}
+		if err := validateTools(template.Tools); err != nil {
+			return nil, fmt.Errorf("invalid tools in %s: %w", sources[i], err)
+		}
+		if err := validateOutputGuards(template.OutputGuards); err != nil {
+			return nil, fmt.Errorf("invalid output guards in %s: %w", sources[i], err)
+		}
+
 		key := generatePromptKey(template.Model, template.Task)
-		log.Debugf("Loaded prompt templates: %v", promptTemplates)
+		for _, existing := range promptTemplates[key] {
+			if existing.Version == template.Version {
+				return nil, fmt.Errorf("duplicate prompt template %s version %s in %s", key, template.Version, sources[i])
+			}
+		}
 
-		promptTemplates[key] = template
+		log.Debugf("Loaded prompt template %s version %s", key, template.Version)
+
+		promptTemplates[key] = append(promptTemplates[key], template)
 	}
 
 	if len(promptTemplates) == 0 {
 		return nil, fmt.Errorf("no prompt templates found")
 	}
 
+	// Keep the versions in a stable order so that sticky selection does not depend on the file order.
+	for key, versions := range promptTemplates {
+		slices.SortFunc(versions, func(a, b PromptTemplate) int {
+			return strings.Compare(a.Version, b.Version)
+		})
+		if !slices.ContainsFunc(versions, func(t PromptTemplate) bool { return t.weight() > 0 }) {
+			return nil, fmt.Errorf("all versions of prompt template %s have weight 0", key)
+		}
+	}
+
 	return promptTemplates, nil
 }
+
+// validateTools checks that every tool has a unique name and an arguments schema.
+func validateTools(tools []ToolTemplate) error {
+	names := make(map[string]bool)
+	for _, tool := range tools {
+		if tool.Name == "" {
+			return fmt.Errorf("tool without name")
+		}
+		if tool.Arguments == "" {
+			return fmt.Errorf("tool %s has no arguments schema", tool.Name)
+		}
+		if names[tool.Name] {
+			return fmt.Errorf("duplicate tool %s", tool.Name)
+		}
+		names[tool.Name] = true
+	}
+
+	return nil
+}
+
+// readTemplateFile reads a template from the embedded prompts and falls back to the file system.
+func readTemplateFile(file string) ([]byte, error) {
+	data, err := promptFS.ReadFile(file)
+	if err == nil {
+		return data, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return os.ReadFile(file)
+}
+
+// selectTemplate picks one of the live versions of a task by weight. With a selection key the
+// choice is sticky, i.e. the same key always gets the same version as long as the versions and
+// their weights do not change. Without a key the version is picked at random. Versions with weight 0
+// are never picked, at least one version has a positive weight.
+func selectTemplate(versions []PromptTemplate, selectionKey string) PromptTemplate {
+	if len(versions) == 1 {
+		return versions[0]
+	}
+
+	total := 0.0
+	for _, t := range versions {
+		total += t.weight()
+	}
+
+	var point float64
+	if selectionKey != "" {
+		h := fnv.New64a()
+		h.Write([]byte(selectionKey))
+		point = float64(h.Sum64()) / float64(^uint64(0)) * total
+	} else {
+		point = rand.Float64() * total
+	}
+
+	last := versions[0]
+	for _, t := range versions {
+		if t.weight() <= 0 {
+			continue
+		}
+		point -= t.weight()
+		if point < 0 {
+			return t
+		}
+		last = t
+	}
+
+	return last
+}
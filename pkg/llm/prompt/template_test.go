@@ -1,9 +1,13 @@
 package prompt
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLoadPromptTemplates(t *testing.T) {
@@ -13,9 +17,125 @@ func TestLoadPromptTemplates(t *testing.T) {
 
 	// Test specific templates
 	llamaChatKey := generatePromptKey("llama-3-1b-chat", "chat")
-	template, exists := templates[llamaChatKey]
+	versions, exists := templates[llamaChatKey]
 	assert.True(t, exists)
+	require.Len(t, versions, 1)
+	template := versions[0]
 	assert.Equal(t, "llama-3-1b-chat", template.Model)
 	assert.Equal(t, "chat", template.Task)
+	assert.Equal(t, "v1", template.Version)
 	assert.NotEmpty(t, template.Roles.Developer.Content)
 }
+
+func writeTemplate(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
+
+func TestLoadPromptTemplatesVersions(t *testing.T) {
+	dir := t.TempDir()
+	v2 := writeTemplate(t, dir, "v2.yaml", "model: m\ntask: chat\nversion: v2\nweight: 3\n")
+	v1 := writeTemplate(t, dir, "v1.yaml", "model: m\ntask: chat\nversion: v1\n")
+
+	templates, err := loadPromptTemplates([]string{v2, v1})
+	require.NoError(t, err)
+
+	versions := templates[generatePromptKey("m", "chat")]
+	require.Len(t, versions, 2)
+	assert.Equal(t, "v1", versions[0].Version)
+	assert.Equal(t, "v2", versions[1].Version)
+}
+
+func TestLoadPromptTemplatesDuplicateVersion(t *testing.T) {
+	dir := t.TempDir()
+	a := writeTemplate(t, dir, "a.yaml", "model: m\ntask: chat\n")
+	b := writeTemplate(t, dir, "b.yaml", "model: m\ntask: chat\nversion: v1\n")
+
+	_, err := loadPromptTemplates([]string{a, b})
+	assert.Error(t, err)
+}
+
+func TestLoadPromptTemplatesEmpty(t *testing.T) {
+	_, err := loadPromptTemplates(nil)
+	assert.EqualError(t, err, "no prompt templates found")
+
+	// Files with only fragments do not define a template.
+	file := writeTemplate(t, t.TempDir(), "fragments.yaml", "fragments:\n  preamble: Be brief.\n")
+	_, err = loadPromptTemplates([]string{file})
+	assert.EqualError(t, err, "no prompt templates found")
+}
+
+func weight(w float64) *float64 {
+	return &w
+}
+
+func TestSelectTemplate(t *testing.T) {
+	versions := []PromptTemplate{
+		{Version: "v1", Weight: weight(1)},
+		{Version: "v2", Weight: weight(3)},
+		{Version: "tiny", Weight: weight(0.0001)},
+		{Version: "drained", Weight: weight(0)},
+		{Version: "z-default"},
+	}
+
+	// Sticky selection returns the same version for the same key.
+	first := selectTemplate(versions, "user-42")
+	for range 10 {
+		assert.Equal(t, first.Version, selectTemplate(versions, "user-42").Version)
+	}
+
+	// Weighted selection roughly follows the weights.
+	counts := make(map[string]int)
+	for range 4000 {
+		counts[selectTemplate(versions, "").Version]++
+	}
+	assert.InDelta(t, 800, counts["v1"], 200)
+	assert.InDelta(t, 2400, counts["v2"], 200)
+	assert.InDelta(t, 800, counts["z-default"], 200)
+	assert.Zero(t, counts["drained"])
+
+	// A drained version is never picked, whatever the selection key.
+	for i := range 1000 {
+		assert.NotEqual(t, "drained", selectTemplate(versions, fmt.Sprintf("user-%d", i)).Version)
+	}
+}
+
+func TestLoadPromptTemplatesWeights(t *testing.T) {
+	dir := t.TempDir()
+	live := writeTemplate(t, dir, "live.yaml", "model: m\ntask: chat\nversion: v1\n")
+	drained := writeTemplate(t, dir, "drained.yaml", "model: m\ntask: chat\nversion: v2\nweight: 0\n")
+	negative := writeTemplate(t, dir, "negative.yaml", "model: m\ntask: chat\nversion: v3\nweight: -1\n")
+
+	templates, err := loadPromptTemplates([]string{live, drained})
+	require.NoError(t, err)
+	versions := templates[generatePromptKey("m", "chat")]
+	require.Len(t, versions, 2)
+	assert.Equal(t, 0.0, versions[1].weight())
+
+	_, err = loadPromptTemplates([]string{live, negative})
+	assert.ErrorContains(t, err, "negative weight -1")
+
+	_, err = loadPromptTemplates([]string{drained})
+	assert.EqualError(t, err, "all versions of prompt template m-chat have weight 0")
+}
+
+func TestLoadPromptTemplatesInvalidTools(t *testing.T) {
+	testCases := map[string]string{
+		"missing name":      "tools:\n  - arguments: args\n",
+		"missing arguments": "tools:\n  - name: lookup\n",
+		"duplicate name":    "tools:\n  - name: lookup\n    arguments: args\n  - name: lookup\n    arguments: args\n",
+	}
+
+	for name, tools := range testCases {
+		t.Run(name, func(t *testing.T) {
+			file := writeTemplate(t, t.TempDir(), "tools.yaml", "model: m\ntask: chat\n"+tools)
+
+			_, err := loadPromptTemplates([]string{file})
+			assert.ErrorContains(t, err, "invalid tools")
+		})
+	}
+}
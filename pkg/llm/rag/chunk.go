@@ -0,0 +1,172 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+)
+
+const defaultChunkTokens = 256
+
+// Chunking strategies.
+const (
+	// ChunkParagraphs joins consecutive paragraphs into chunks.
+	ChunkParagraphs = "paragraphs"
+	// ChunkTokens splits the text into chunks of words regardless of its structure.
+	ChunkTokens = "tokens"
+	// ChunkHeadings starts a new chunk at every markdown heading, long sections are split by paragraphs.
+	ChunkHeadings = "headings"
+)
+
+// ChunkConfig controls how documents are split into chunks.
+type ChunkConfig struct {
+	Strategy  string `yaml:"strategy"`  // optional; paragraphs (default), tokens or headings
+	MaxTokens int    `yaml:"maxTokens"` // optional; tokens of a chunk, default 256
+	Overlap   int    `yaml:"overlap"`   // optional; tokens of the previous chunk repeated at the start of the next
+}
+
+func (c ChunkConfig) withDefaults() (ChunkConfig, error) {
+	if c.Strategy == "" {
+		c.Strategy = ChunkParagraphs
+	}
+	if c.MaxTokens <= 0 {
+		c.MaxTokens = defaultChunkTokens
+	}
+
+	switch c.Strategy {
+	case ChunkParagraphs, ChunkTokens, ChunkHeadings:
+	default:
+		return c, fmt.Errorf("unknown chunking strategy: %s", c.Strategy)
+	}
+	if c.Overlap < 0 || c.Overlap >= c.MaxTokens {
+		return c, fmt.Errorf("chunk overlap must be between 0 and %d tokens", c.MaxTokens-1)
+	}
+
+	return c, nil
+}
+
+// String identifies the chunking in the hash of a document, so a changed configuration re-chunks it.
+func (c ChunkConfig) String() string {
+	return fmt.Sprintf("%s/%d/%d", c.Strategy, c.MaxTokens, c.Overlap)
+}
+
+// Chunk is a piece of a document that is embedded and retrieved on its own.
+type Chunk struct {
+	ID       string
+	Document string
+	Text     string
+}
+
+// split splits the text into chunks of up to MaxTokens tokens with the configured strategy.
+func split(text string, cfg ChunkConfig, counter tokenizer.Counter) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	switch cfg.Strategy {
+	case ChunkTokens:
+		return pack(strings.Fields(text), " ", cfg, counter)
+	case ChunkHeadings:
+		var chunks []string
+		for _, section := range sections(text) {
+			chunks = append(chunks, splitParagraphs(section, cfg, counter)...)
+		}
+		return chunks
+	default:
+		return splitParagraphs(text, cfg, counter)
+	}
+}
+
+// splitParagraphs joins consecutive paragraphs into chunks. A paragraph longer than MaxTokens is
+// split at word boundaries.
+func splitParagraphs(text string, cfg ChunkConfig, counter tokenizer.Counter) []string {
+	var chunks []string
+	var units []string
+
+	flush := func() {
+		chunks = append(chunks, pack(units, "\n\n", cfg, counter)...)
+		units = nil
+	}
+
+	for _, paragraph := range paragraphs(text) {
+		if counter.Count(paragraph) > cfg.MaxTokens {
+			flush()
+			chunks = append(chunks, pack(strings.Fields(paragraph), " ", cfg, counter)...)
+			continue
+		}
+		units = append(units, paragraph)
+	}
+	flush()
+
+	return chunks
+}
+
+// pack joins consecutive units with sep into chunks of up to MaxTokens tokens. A new chunk starts
+// with the trailing units of the previous one that fit into Overlap tokens.
+func pack(units []string, sep string, cfg ChunkConfig, counter tokenizer.Counter) []string {
+	var chunks []string
+	var current []string
+	carried := 0 // units at the start of current repeated from the previous chunk
+
+	for _, unit := range units {
+		if len(current) > carried && counter.Count(strings.Join(append(current, unit), sep)) > cfg.MaxTokens {
+			chunks = append(chunks, strings.Join(current, sep))
+			current = overlap(current, sep, cfg.Overlap, counter)
+			// The carried units must leave room for the next unit.
+			for len(current) > 0 && counter.Count(strings.Join(append(current, unit), sep)) > cfg.MaxTokens {
+				current = current[1:]
+			}
+			carried = len(current)
+		}
+		current = append(current, unit)
+	}
+	if len(current) > carried {
+		chunks = append(chunks, strings.Join(current, sep))
+	}
+
+	return chunks
+}
+
+// overlap returns the trailing units that fit into tokens.
+func overlap(units []string, sep string, tokens int, counter tokenizer.Counter) []string {
+	if tokens <= 0 {
+		return nil
+	}
+
+	start := len(units)
+	for start > 0 && counter.Count(strings.Join(units[start-1:], sep)) <= tokens {
+		start--
+	}
+
+	return append([]string(nil), units[start:]...)
+}
+
+func paragraphs(text string) []string {
+	var result []string
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			result = append(result, paragraph)
+		}
+	}
+
+	return result
+}
+
+// sections splits markdown at its headings, each section starts with its heading.
+func sections(text string) []string {
+	var result []string
+	var current strings.Builder
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "#") && current.Len() > 0 {
+			result = append(result, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	if current.Len() > 0 {
+		result = append(result, current.String())
+	}
+
+	return result
+}
@@ -0,0 +1,58 @@
+package rag
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+)
+
+// A counter with one character per token makes the expected chunks easy to derive.
+var charCounter = tokenizer.NewHeuristicCounter(1)
+
+func TestSplitParagraphs(t *testing.T) {
+	cfg := ChunkConfig{Strategy: ChunkParagraphs, MaxTokens: 10}
+	text := "aaaa\n\nbbbb\r\n\r\nccccccccc\n\n\n\ndd"
+
+	assert.Equal(t, []string{"aaaa\n\nbbbb", "ccccccccc", "dd"}, split(text, cfg, charCounter))
+	assert.Empty(t, split(" \n\n ", cfg, charCounter))
+}
+
+func TestSplitParagraphsLongParagraph(t *testing.T) {
+	cfg := ChunkConfig{Strategy: ChunkParagraphs, MaxTokens: 10}
+	text := "intro\n\n" + strings.Repeat("word ", 5)
+
+	chunks := split(text, cfg, charCounter)
+	assert.Equal(t, []string{"intro", "word word", "word word", "word"}, chunks)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, charCounter.Count(chunk), 10)
+	}
+}
+
+func TestSplitTokensOverlap(t *testing.T) {
+	cfg := ChunkConfig{Strategy: ChunkTokens, MaxTokens: 11, Overlap: 3}
+
+	chunks := split("one two\n\nsix ten red", cfg, charCounter)
+	assert.Equal(t, []string{"one two six", "six ten red"}, chunks)
+}
+
+func TestSplitHeadings(t *testing.T) {
+	cfg := ChunkConfig{Strategy: ChunkHeadings, MaxTokens: 100}
+	text := "Intro\n# First\nText one.\n\nMore.\n## Second\nText two."
+
+	assert.Equal(t, []string{"Intro", "# First\nText one.\n\nMore.", "## Second\nText two."}, split(text, cfg, charCounter))
+}
+
+func TestChunkConfigDefaults(t *testing.T) {
+	cfg, err := ChunkConfig{}.withDefaults()
+	require.NoError(t, err)
+	assert.Equal(t, ChunkConfig{Strategy: ChunkParagraphs, MaxTokens: defaultChunkTokens}, cfg)
+
+	_, err = ChunkConfig{Strategy: "sentences"}.withDefaults()
+	assert.ErrorContains(t, err, "unknown chunking strategy")
+
+	_, err = ChunkConfig{MaxTokens: 10, Overlap: 10}.withDefaults()
+	assert.ErrorContains(t, err, "overlap")
+}
@@ -0,0 +1,83 @@
+package rag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Document formats.
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+	// FormatJSONL holds one document per line as a JSON object with id, text and metadata.
+	FormatJSONL = "jsonl"
+)
+
+// Document is a text that is split into chunks and indexed.
+type Document struct {
+	ID       string            `json:"id"`
+	Text     string            `json:"text"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// FormatOf returns the format of a file by its extension, or an empty string if it is not a document.
+func FormatOf(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".txt":
+		return FormatText
+	case ".md", ".markdown":
+		return FormatMarkdown
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	}
+	return ""
+}
+
+// ParseDocuments reads the documents of a file or request body. Text and markdown are a single
+// document with the given id. Lines of JSONL without an id get the id followed by their line number.
+func ParseDocuments(id, format string, data []byte) ([]Document, error) {
+	switch format {
+	case FormatText, FormatMarkdown:
+		if id == "" {
+			return nil, fmt.Errorf("document requires an id")
+		}
+		return []Document{{ID: id, Text: string(data)}}, nil
+	case FormatJSONL:
+		return parseJSONL(id, data)
+	default:
+		return nil, fmt.Errorf("unsupported document format: %q", format)
+	}
+}
+
+func parseJSONL(id string, data []byte) ([]Document, error) {
+	var documents []Document
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var document Document
+		if err := json.Unmarshal(scanner.Bytes(), &document); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if document.ID == "" {
+			if id == "" {
+				return nil, fmt.Errorf("line %d: document requires an id", line)
+			}
+			document.ID = fmt.Sprintf("%s:%d", id, line)
+		}
+		documents = append(documents, document)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
@@ -0,0 +1,521 @@
+// Package rag retrieves passages of local documents for prompts: documents are split into chunks,
+// embedded and stored in an on-disk vector index.
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/vectorindex"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+var ingestedDocuments = metrics.NewCounter("ingested_documents_total")
+
+const (
+	embedBatchSize = 32
+	// lockTimeout is how long a change waits for another process that changes the index file.
+	lockTimeout = 30 * time.Second
+
+	// Metadata keys of indexed chunks. Filters of a retrieval block match them and the metadata of
+	// the documents.
+	MetadataDocument = "document"
+	MetadataDir      = "dir"
+	metadataText     = "text"
+	metadataHash     = "hash"
+	metadataChunk    = "chunkHash"
+)
+
+// Statuses of an ingested document.
+const (
+	StatusAdded     = "added"
+	StatusUpdated   = "updated"
+	StatusUnchanged = "unchanged"
+)
+
+// Config configures retrieval of documents.
+type Config struct {
+	Embedder  string      `yaml:"embedder"`  // logical name of the embedding model; empty disables retrieval
+	Index     string      `yaml:"index"`     // file of the vector index
+	Documents string      `yaml:"documents"` // optional; directory ingested at startup
+	Chunking  ChunkConfig `yaml:"chunking"`  // optional; how documents are split
+}
+
+// DocumentInfo describes an indexed document.
+type DocumentInfo struct {
+	ID string `json:"id"`
+	// Hash is the hash of the text, metadata and chunking of the document.
+	Hash   string `json:"hash"`
+	Chunks int    `json:"chunks"`
+	// Status is set by ingestion: added, updated or unchanged.
+	Status string `json:"status,omitempty"`
+}
+
+// Store holds the embedded chunks of documents. It implements prompt.Retriever.
+//
+// Several processes may share the index file, e.g. the service and the ingest command. Every change
+// locks the file, reloads it and applies the change to the reloaded index, so changes of other
+// processes are kept. Reads reload the index once the file changed, so changes of other processes
+// become visible without a change or restart.
+type Store struct {
+	embedder model.Embedder
+	// loaded is replaced as a whole by every change or reload, searches use the index they started with.
+	loaded   atomic.Pointer[loadedIndex]
+	path     string
+	chunking ChunkConfig
+	counter  tokenizer.Counter
+
+	// mu serializes changes of the index within the process, the lock file across processes.
+	mu sync.Mutex
+	// reloadMu serializes reloads of a changed index file.
+	reloadMu sync.Mutex
+}
+
+// loadedIndex is an index with the modification time and size of the file it was loaded from.
+type loadedIndex struct {
+	index   *vectorindex.Index
+	modTime time.Time
+	size    int64
+}
+
+// Open loads the index at path, or starts an empty one if the file does not exist yet.
+func Open(path string, embedder model.Embedder, chunking ChunkConfig) (*Store, error) {
+	if path == "" {
+		return nil, fmt.Errorf("retrieval requires an index file")
+	}
+
+	chunking, err := chunking.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		embedder: embedder,
+		path:     path,
+		chunking: chunking,
+		counter:  tokenizer.NewHeuristicCounter(0),
+	}
+	loaded, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.loaded.Store(loaded)
+
+	return s, nil
+}
+
+// Len returns the number of indexed chunks.
+func (s *Store) Len() int {
+	return s.current().Len()
+}
+
+// Retrieve returns the topK chunks most similar to the query whose metadata matches the filters.
+func (s *Store) Retrieve(ctx context.Context, query string, topK int, filters map[string]string) ([]prompt.Passage, error) {
+	vectors, err := s.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+
+	var filter vectorindex.Filter
+	if len(filters) > 0 {
+		filter = vectorindex.MetadataEquals(filters)
+	}
+
+	matches, err := s.current().Search(vectors[0], topK, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	passages := make([]prompt.Passage, 0, len(matches))
+	for _, match := range matches {
+		passages = append(passages, prompt.Passage{
+			ID:    match.ID,
+			Text:  match.Metadata[metadataText],
+			Score: match.Score,
+		})
+	}
+
+	return passages, nil
+}
+
+// Documents lists the indexed documents ordered by id.
+func (s *Store) Documents() []DocumentInfo {
+	documents := make(map[string]*DocumentInfo)
+	for _, item := range s.current().Items(nil) {
+		id := item.Metadata[MetadataDocument]
+		info, ok := documents[id]
+		if !ok {
+			info = &DocumentInfo{ID: id, Hash: item.Metadata[metadataHash]}
+			documents[id] = info
+		}
+		info.Chunks++
+	}
+
+	result := make([]DocumentInfo, 0, len(documents))
+	for _, id := range slices.Sorted(maps.Keys(documents)) {
+		result = append(result, *documents[id])
+	}
+
+	return result
+}
+
+// Add indexes the documents and saves the index. A document that is added again replaces its
+// previous chunks, unless its text, metadata and the chunking are unchanged. Only chunks whose text
+// is not indexed yet are embedded. If a document fails, none of the documents are added.
+func (s *Store) Add(ctx context.Context, documents []Document) ([]DocumentInfo, error) {
+	// Embedding takes long, it happens before the index file is locked. Vectors are looked up by the
+	// hash of the chunk text, so they apply to the index as it is when the change is made.
+	chunked, vectors, err := s.embed(ctx, s.current(), documents)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []DocumentInfo
+	err = s.update(func(index *vectorindex.Index) error {
+		var err error
+		infos, err = s.add(index, chunked, vectors)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		ingestedDocuments.Inc(info.Status)
+	}
+
+	return infos, nil
+}
+
+// Remove deletes the chunks of a document and saves the index. It reports whether the document existed.
+func (s *Store) Remove(id string) (bool, error) {
+	var removed []string
+	err := s.update(func(index *vectorindex.Index) error {
+		removed = index.Delete(documentFilter(id))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(removed) == 0 {
+		return false, nil
+	}
+
+	log.WithField("document", id).Info("Removed document")
+
+	return true, nil
+}
+
+// update applies a change to the current index file and saves it. The index of the store is only
+// replaced once the change is saved, a failed change leaves it as it was.
+func (s *Store) update(change func(index *vectorindex.Index) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := vectorindex.Lock(s.path, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	loaded, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if err := change(loaded.index); err != nil {
+		return err
+	}
+
+	if err := loaded.index.Save(s.path); err != nil {
+		return err
+	}
+	loaded.modTime, loaded.size = s.stat()
+	s.loaded.Store(loaded)
+
+	return nil
+}
+
+// current returns the index, reloaded first if the index file changed since it was loaded, e.g. by
+// the ingest command. If the reload fails, the previous index is kept.
+func (s *Store) current() *vectorindex.Index {
+	loaded := s.loaded.Load()
+	if !s.changed(loaded) {
+		return loaded.index
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	loaded = s.loaded.Load()
+	if !s.changed(loaded) {
+		return loaded.index
+	}
+
+	reloaded, err := s.load()
+	if err != nil {
+		log.Warnf("Failed to reload index %s: %v", s.path, err)
+		return loaded.index
+	}
+	s.loaded.Store(reloaded)
+	log.WithField("index", s.path).Info("Reloaded changed index")
+
+	return reloaded.index
+}
+
+// load reads the index file. The file is checked before it is read, a change in between is
+// detected by the next read.
+func (s *Store) load() (*loadedIndex, error) {
+	modTime, size := s.stat()
+
+	index, err := vectorindex.Load(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loadedIndex{index: index, modTime: modTime, size: size}, nil
+}
+
+// changed reports whether the index file differs from the one the index was loaded from.
+func (s *Store) changed(loaded *loadedIndex) bool {
+	modTime, size := s.stat()
+	return !modTime.Equal(loaded.modTime) || size != loaded.size
+}
+
+// stat returns the modification time and size of the index file, zero if it does not exist.
+func (s *Store) stat() (time.Time, int64) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+
+	return info.ModTime(), info.Size()
+}
+
+// IngestDir indexes all text, markdown and JSONL files below dir, see Add. Documents are identified
+// by their path relative to dir.
+func (s *Store) IngestDir(ctx context.Context, dir string) ([]DocumentInfo, error) {
+	var documents []Document
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || FormatOf(file) == "" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+
+		parsed, err := readDocuments(file, filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		documents = append(documents, parsed...)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := s.Add(ctx, documents)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"dir":       dir,
+		"documents": len(infos),
+	}).Info("Ingested documents")
+
+	return infos, nil
+}
+
+// IngestFile indexes a single text, markdown or JSONL file with the given id, see Add.
+func (s *Store) IngestFile(ctx context.Context, file, id string) ([]DocumentInfo, error) {
+	documents, err := readDocuments(file, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Add(ctx, documents)
+}
+
+func readDocuments(file, id string) ([]Document, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", file, err)
+	}
+
+	documents, err := ParseDocuments(id, FormatOf(file), data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	return documents, nil
+}
+
+// chunkedDocument is a document split into chunks.
+type chunkedDocument struct {
+	Document
+	hash   string
+	chunks []Chunk
+}
+
+// embed splits the documents into chunks and embeds the chunks whose text is not in the index. It
+// returns the vectors of the chunks of the index and the documents by the hash of their text.
+func (s *Store) embed(ctx context.Context, index *vectorindex.Index, documents []Document) ([]chunkedDocument, map[string][]float32, error) {
+	// Chunks with the same text are not embedded again.
+	vectors := make(map[string][]float32)
+	for _, item := range index.Items(nil) {
+		vectors[item.Metadata[metadataChunk]] = item.Vector
+	}
+
+	chunked := make([]chunkedDocument, 0, len(documents))
+	for _, document := range documents {
+		chunks := s.chunk(document)
+		if len(chunks) == 0 {
+			return nil, nil, fmt.Errorf("ingesting %s: document has no text", document.ID)
+		}
+		if err := s.embedChunks(ctx, chunks, vectors); err != nil {
+			return nil, nil, fmt.Errorf("ingesting %s: %w", document.ID, err)
+		}
+		chunked = append(chunked, chunkedDocument{Document: document, hash: s.documentHash(document), chunks: chunks})
+	}
+
+	return chunked, vectors, nil
+}
+
+// embedChunks embeds the chunks that have no vector yet in batches and adds their vectors.
+func (s *Store) embedChunks(ctx context.Context, chunks []Chunk, vectors map[string][]float32) error {
+	var missing []string
+	for _, chunk := range chunks {
+		chunkHash := hashText(chunk.Text)
+		if _, ok := vectors[chunkHash]; !ok && !slices.Contains(missing, chunk.Text) {
+			missing = append(missing, chunk.Text)
+		}
+	}
+
+	for start := 0; start < len(missing); start += embedBatchSize {
+		end := min(start+embedBatchSize, len(missing))
+		batch, err := s.embedder.Embed(ctx, missing[start:end])
+		if err != nil {
+			return fmt.Errorf("embedding chunks: %w", err)
+		}
+		for i, vector := range batch {
+			vectors[hashText(missing[start+i])] = vector
+		}
+	}
+
+	return nil
+}
+
+// add indexes the embedded documents in the given index without saving it.
+func (s *Store) add(index *vectorindex.Index, documents []chunkedDocument, vectors map[string][]float32) ([]DocumentInfo, error) {
+	infos := make([]DocumentInfo, 0, len(documents))
+	for _, document := range documents {
+		info, err := addDocument(index, document, vectors)
+		if err != nil {
+			return nil, fmt.Errorf("ingesting %s: %w", document.ID, err)
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func addDocument(index *vectorindex.Index, document chunkedDocument, vectors map[string][]float32) (DocumentInfo, error) {
+	existing := index.Items(documentFilter(document.ID))
+	if len(existing) > 0 && existing[0].Metadata[metadataHash] == document.hash {
+		return DocumentInfo{ID: document.ID, Hash: document.hash, Chunks: len(existing), Status: StatusUnchanged}, nil
+	}
+
+	index.Delete(documentFilter(document.ID))
+
+	for _, chunk := range document.chunks {
+		chunkHash := hashText(chunk.Text)
+
+		metadata := maps.Clone(document.Metadata)
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[MetadataDocument] = document.ID
+		metadata[MetadataDir] = path.Dir(document.ID)
+		metadata[metadataText] = chunk.Text
+		metadata[metadataHash] = document.hash
+		metadata[metadataChunk] = chunkHash
+
+		err := index.Add(vectorindex.Item{
+			ID:       chunk.ID,
+			Vector:   vectors[chunkHash],
+			Metadata: metadata,
+		})
+		if err != nil {
+			return DocumentInfo{}, fmt.Errorf("indexing chunk %s: %w", chunk.ID, err)
+		}
+	}
+
+	status := StatusAdded
+	if len(existing) > 0 {
+		status = StatusUpdated
+	}
+
+	return DocumentInfo{ID: document.ID, Hash: document.hash, Chunks: len(document.chunks), Status: status}, nil
+}
+
+// chunk splits a document into chunks with ids of the form document#n.
+func (s *Store) chunk(document Document) []Chunk {
+	texts := split(document.Text, s.chunking, s.counter)
+
+	chunks := make([]Chunk, len(texts))
+	for i, text := range texts {
+		chunks[i] = Chunk{
+			ID:       document.ID + "#" + strconv.Itoa(i),
+			Document: document.ID,
+			Text:     text,
+		}
+	}
+
+	return chunks
+}
+
+// documentHash covers everything the chunks of a document depend on.
+func (s *Store) documentHash(document Document) string {
+	var b strings.Builder
+	b.WriteString(s.chunking.String())
+	for _, key := range slices.Sorted(maps.Keys(document.Metadata)) {
+		fmt.Fprintf(&b, "\x00%s=%s", key, document.Metadata[key])
+	}
+	b.WriteString("\x00")
+	b.WriteString(document.Text)
+
+	return hashText(b.String())
+}
+
+func hashText(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func documentFilter(id string) vectorindex.Filter {
+	return vectorindex.MetadataEquals(map[string]string{MetadataDocument: id})
+}
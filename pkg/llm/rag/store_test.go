@@ -0,0 +1,289 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/vectorindex"
+)
+
+// keywordEmbedder embeds a text by the keywords it contains.
+type keywordEmbedder struct {
+	calls  int
+	inputs []int
+}
+
+var keywords = []string{"vacation", "travel", "salary"}
+
+func (e *keywordEmbedder) Name() string { return "keywords" }
+
+func (e *keywordEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	e.calls++
+	e.inputs = append(e.inputs, len(input))
+	vectors := make([][]float32, len(input))
+	for i, text := range input {
+		vector := make([]float32, len(keywords)+1)
+		vector[len(keywords)] = 0.1
+		for j, keyword := range keywords {
+			vector[j] = float32(strings.Count(strings.ToLower(text), keyword))
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestStoreIngestAndRetrieve(t *testing.T) {
+	docs := t.TempDir()
+	writeFile(t, filepath.Join(docs, "handbook", "vacation.md"), "# Time off\n\nEmployees have 30 vacation days.")
+	writeFile(t, filepath.Join(docs, "handbook", "travel.txt"), "Travel is booked by the office.")
+	writeFile(t, filepath.Join(docs, "salary.md"), "Salary is paid monthly.")
+	writeFile(t, filepath.Join(docs, "image.png"), "vacation")
+
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	store, err := Open(indexPath, &keywordEmbedder{}, ChunkConfig{MaxTokens: 8})
+	require.NoError(t, err)
+
+	infos, err := store.IngestDir(context.Background(), docs)
+	require.NoError(t, err)
+	assert.Equal(t, []DocumentInfo{
+		{ID: "handbook/travel.txt", Hash: infos[0].Hash, Chunks: 1, Status: StatusAdded},
+		{ID: "handbook/vacation.md", Hash: infos[1].Hash, Chunks: 2, Status: StatusAdded},
+		{ID: "salary.md", Hash: infos[2].Hash, Chunks: 1, Status: StatusAdded},
+	}, infos)
+	assert.Equal(t, 4, store.Len())
+
+	passages, err := store.Retrieve(context.Background(), "How many vacation days?", 1, nil)
+	require.NoError(t, err)
+	require.Len(t, passages, 1)
+	assert.Equal(t, "handbook/vacation.md#1", passages[0].ID)
+	assert.Equal(t, "Employees have 30 vacation days.", passages[0].Text)
+
+	passages, err = store.Retrieve(context.Background(), "salary", 3, map[string]string{MetadataDir: "handbook"})
+	require.NoError(t, err)
+	for _, passage := range passages {
+		assert.True(t, strings.HasPrefix(passage.ID, "handbook/"))
+	}
+
+	// The index is persisted and loaded again.
+	reopened, err := Open(indexPath, &keywordEmbedder{}, ChunkConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, reopened.Len())
+}
+
+func TestStoreReingestReplacesChunks(t *testing.T) {
+	docs := t.TempDir()
+	file := filepath.Join(docs, "vacation.md")
+	writeFile(t, file, "Vacation one.\n\nVacation two.")
+
+	embedder := &keywordEmbedder{}
+	store, err := Open(filepath.Join(t.TempDir(), "index.json"), embedder, ChunkConfig{MaxTokens: 4})
+	require.NoError(t, err)
+
+	_, err = store.IngestDir(context.Background(), docs)
+	require.NoError(t, err)
+	assert.Equal(t, 2, store.Len())
+	assert.Equal(t, 1, embedder.calls)
+
+	// Unchanged documents are not embedded again.
+	infos, err := store.IngestDir(context.Background(), docs)
+	require.NoError(t, err)
+	assert.Equal(t, StatusUnchanged, infos[0].Status)
+	assert.Equal(t, 1, embedder.calls)
+
+	// Only the new chunk of a changed document is embedded.
+	writeFile(t, file, "Vacation one.\n\nVacation three.\n\nVacation four.")
+	infos, err = store.IngestDir(context.Background(), docs)
+	require.NoError(t, err)
+	assert.Equal(t, StatusUpdated, infos[0].Status)
+	assert.Equal(t, 3, store.Len())
+	assert.Equal(t, []int{2, 2}, embedder.inputs)
+}
+
+func TestStoreAddAndRemove(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "index.json"), &keywordEmbedder{}, ChunkConfig{})
+	require.NoError(t, err)
+
+	documents, err := ParseDocuments("faq.jsonl", FormatJSONL, []byte(
+		`{"id":"faq/travel","text":"Travel is booked by the office.","metadata":{"team":"ops"}}`+"\n\n"+
+			`{"text":"Vacation requests go to your lead."}`+"\n"))
+	require.NoError(t, err)
+
+	infos, err := store.Add(context.Background(), documents)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "faq.jsonl:3", infos[1].ID)
+
+	// Metadata of documents can be used as retrieval filters.
+	passages, err := store.Retrieve(context.Background(), "vacation", 2, map[string]string{"team": "ops"})
+	require.NoError(t, err)
+	require.Len(t, passages, 1)
+	assert.Equal(t, "faq/travel#0", passages[0].ID)
+
+	removed, err := store.Remove("faq/travel")
+	require.NoError(t, err)
+	assert.True(t, removed)
+
+	removed, err = store.Remove("faq/travel")
+	require.NoError(t, err)
+	assert.False(t, removed)
+
+	assert.Equal(t, []DocumentInfo{{ID: "faq.jsonl:3", Hash: infos[1].Hash, Chunks: 1}}, store.Documents())
+
+	_, err = store.Add(context.Background(), []Document{{ID: "empty", Text: " "}})
+	assert.ErrorContains(t, err, "document has no text")
+}
+
+func TestStoreKeepsChangesOfOtherProcesses(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	server, err := Open(indexPath, &keywordEmbedder{}, ChunkConfig{})
+	require.NoError(t, err)
+	cli, err := Open(indexPath, &keywordEmbedder{}, ChunkConfig{})
+	require.NoError(t, err)
+
+	_, err = cli.Add(context.Background(), []Document{{ID: "travel.md", Text: "Travel is booked by the office."}})
+	require.NoError(t, err)
+	_, err = server.Add(context.Background(), []Document{{ID: "salary.md", Text: "Salary is paid monthly."}})
+	require.NoError(t, err)
+
+	// The server reloads the index before its change, the document of the CLI is neither lost nor hidden.
+	assert.Len(t, server.Documents(), 2)
+	reopened, err := Open(indexPath, &keywordEmbedder{}, ChunkConfig{})
+	require.NoError(t, err)
+	assert.Len(t, reopened.Documents(), 2)
+
+	// A file lock held by another process makes changes wait for it.
+	unlock, err := vectorindex.Lock(indexPath, 0)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		unlock()
+	}()
+	removed, err := server.Remove("travel.md")
+	require.NoError(t, err)
+	assert.True(t, removed)
+}
+
+func TestStoreSeesChangesOfOtherProcesses(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	server, err := Open(indexPath, &keywordEmbedder{}, ChunkConfig{})
+	require.NoError(t, err)
+	cli, err := Open(indexPath, &keywordEmbedder{}, ChunkConfig{})
+	require.NoError(t, err)
+
+	// The server reloads the index file once the CLI changed it, without a change of its own.
+	_, err = cli.Add(context.Background(), []Document{{ID: "travel.md", Text: "Travel is booked by the office."}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"travel.md"}, documentIDs(server.Documents()))
+	passages, err := server.Retrieve(context.Background(), "travel", 1, nil)
+	require.NoError(t, err)
+	require.Len(t, passages, 1)
+	assert.Equal(t, "travel.md#0", passages[0].ID)
+
+	_, err = cli.Remove("travel.md")
+	require.NoError(t, err)
+	assert.Empty(t, server.Documents())
+}
+
+// lockCheckingEmbedder records whether the index file was locked while it embedded.
+type lockCheckingEmbedder struct {
+	keywordEmbedder
+	lockPath string
+	locked   bool
+}
+
+func (e *lockCheckingEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	if _, err := os.Stat(e.lockPath); err == nil {
+		e.locked = true
+	}
+	return e.keywordEmbedder.Embed(ctx, input)
+}
+
+func TestStoreEmbedsWithoutLock(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	embedder := &lockCheckingEmbedder{lockPath: indexPath + ".lock"}
+	store, err := Open(indexPath, embedder, ChunkConfig{})
+	require.NoError(t, err)
+
+	_, err = store.Add(context.Background(), []Document{{ID: "travel.md", Text: "Travel is booked by the office."}})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, embedder.calls)
+	assert.False(t, embedder.locked)
+}
+
+func documentIDs(infos []DocumentInfo) []string {
+	ids := make([]string, len(infos))
+	for i, info := range infos {
+		ids[i] = info.ID
+	}
+	return ids
+}
+
+// failingEmbedder fails for texts that contain fail.
+type failingEmbedder struct {
+	keywordEmbedder
+}
+
+func (e *failingEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	for _, text := range input {
+		if strings.Contains(text, "fail") {
+			return nil, errors.New("embedding failed")
+		}
+	}
+	return e.keywordEmbedder.Embed(ctx, input)
+}
+
+func TestStoreAddIsAtomic(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	store, err := Open(indexPath, &failingEmbedder{}, ChunkConfig{})
+	require.NoError(t, err)
+
+	_, err = store.Add(context.Background(), []Document{{ID: "travel.md", Text: "Travel is booked by the office."}})
+	require.NoError(t, err)
+	before := store.Documents()
+
+	// The old chunks of travel.md are kept although they are replaced before salary.md fails.
+	_, err = store.Add(context.Background(), []Document{
+		{ID: "travel.md", Text: "Travel is booked online."},
+		{ID: "salary.md", Text: "Salary fails."},
+	})
+	assert.ErrorContains(t, err, "ingesting salary.md")
+	assert.Equal(t, before, store.Documents())
+
+	reopened, err := Open(indexPath, &keywordEmbedder{}, ChunkConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, before, reopened.Documents())
+}
+
+func TestOpenRequiresIndex(t *testing.T) {
+	_, err := Open("", &keywordEmbedder{}, ChunkConfig{})
+	assert.Error(t, err)
+}
+
+func TestParseDocuments(t *testing.T) {
+	documents, err := ParseDocuments("notes.md", FormatOf("notes.md"), []byte("# Notes"))
+	require.NoError(t, err)
+	assert.Equal(t, []Document{{ID: "notes.md", Text: "# Notes"}}, documents)
+
+	_, err = ParseDocuments("", FormatText, []byte("text"))
+	assert.Error(t, err)
+
+	_, err = ParseDocuments("", FormatJSONL, []byte(`{"text":"no id"}`))
+	assert.ErrorContains(t, err, "line 1")
+
+	_, err = ParseDocuments("a.pdf", FormatOf("a.pdf"), nil)
+	assert.ErrorContains(t, err, "unsupported document format")
+}
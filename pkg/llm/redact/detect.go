@@ -0,0 +1,160 @@
+package redact
+
+import (
+	"math/big"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Names of the built-in detectors.
+const (
+	DetectorEmail      = "email"
+	DetectorIBAN       = "iban"
+	DetectorCreditCard = "credit_card"
+	DetectorIP         = "ip"
+	DetectorPhone      = "phone"
+)
+
+// Detector finds one kind of personal data.
+type Detector struct {
+	Name string
+	re   *regexp.Regexp
+	// valid checks a match of the regular expression, e.g. the checksum of a credit card number.
+	valid func(string) bool
+}
+
+// builtinDetectors are in the order they win over each other when matches start at the same position.
+var builtinDetectors = []Detector{
+	{
+		Name: DetectorEmail,
+		re:   regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	{
+		Name:  DetectorIBAN,
+		re:    regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`),
+		valid: validIBAN,
+	},
+	{
+		Name:  DetectorCreditCard,
+		re:    regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid: validLuhn,
+	},
+	{
+		Name:  DetectorIP,
+		re:    regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|\b(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}\b`),
+		valid: validIP,
+	},
+	{
+		Name: DetectorPhone,
+		// A number needs a country code, an area code in parentheses or the 3-3-4 shape of North
+		// America, other groups of digits are too often ports, durations or ids.
+		re: regexp.MustCompile(`(?:\+\d{1,3}|\(\d{2,5}\))(?:[\s.-]?\d{1,8}){1,5}\b|` +
+			`\b\d{3}-\d{3}-\d{4}\b|\b\d{3}\.\d{3}\.\d{4}\b`),
+		valid: validPhone,
+	},
+}
+
+// match is a value found by a detector.
+type match struct {
+	detector   string
+	start, end int
+}
+
+// find returns the non-overlapping matches of the detectors ordered by position. Of overlapping
+// matches the one that starts first wins, then the one of the earlier detector.
+func find(detectors []Detector, text string) []match {
+	var candidates []match
+	for _, detector := range detectors {
+		for _, loc := range detector.re.FindAllStringIndex(text, -1) {
+			if detector.valid != nil && !detector.valid(text[loc[0]:loc[1]]) {
+				continue
+			}
+			candidates = append(candidates, match{detector: detector.Name, start: loc[0], end: loc[1]})
+		}
+	}
+
+	// A stable sort by start keeps the detector order for matches at the same position.
+	slices.SortStableFunc(candidates, func(a, b match) int {
+		return a.start - b.start
+	})
+
+	var matches []match
+	end := 0
+	for _, candidate := range candidates {
+		if candidate.start < end {
+			continue
+		}
+		matches = append(matches, candidate)
+		end = candidate.end
+	}
+
+	return matches
+}
+
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// validLuhn checks the length and the Luhn checksum of a credit card number.
+func validLuhn(s string) bool {
+	number := digits(s)
+	if len(number) < 13 || len(number) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+// validIBAN checks the length and the mod 97 checksum of an IBAN.
+func validIBAN(s string) bool {
+	iban := strings.ReplaceAll(s, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	// Move the country code and check digits to the end and replace letters by numbers, A = 10.
+	var b strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteString(strconv.Itoa(int(r - 'A' + 10)))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	n, ok := new(big.Int).SetString(b.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func validIP(s string) bool {
+	return net.ParseIP(s) != nil
+}
+
+// validPhone requires 7 to 15 digits, the range of the E.164 numbering plan.
+func validPhone(s string) bool {
+	n := len(digits(s))
+	return n >= 7 && n <= 15
+}
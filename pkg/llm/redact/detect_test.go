@@ -0,0 +1,43 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{name: "email", text: "Mail ada.lovelace+ai@example.co.uk now", expected: []string{"email:ada.lovelace+ai@example.co.uk"}},
+		{name: "iban", text: "Pay to DE89 3704 0044 0532 0130 00.", expected: []string{"iban:DE89 3704 0044 0532 0130 00"}},
+		{name: "iban compact", text: "GB82WEST12345698765432", expected: []string{"iban:GB82WEST12345698765432"}},
+		{name: "iban wrong checksum", text: "GB00WEST12345698765432", expected: nil},
+		{name: "credit card", text: "Card 4111 1111 1111 1111 expires", expected: []string{"credit_card:4111 1111 1111 1111"}},
+		{name: "credit card wrong checksum", text: "Order 4111 1111 1111 1112", expected: nil},
+		{name: "ipv4", text: "from 192.168.1.20 and 10.0.0.1", expected: []string{"ip:192.168.1.20", "ip:10.0.0.1"}},
+		{name: "ipv4 out of range", text: "version 999.1.2.3", expected: nil},
+		{name: "ipv6", text: "host 2001:db8::8a2e:370:7334 up", expected: []string{"ip:2001:db8::8a2e:370:7334"}},
+		{name: "phone", text: "Call +49 30 1234567 or (030) 1234-5678 or 555-123-4567", expected: []string{"phone:+49 30 1234567", "phone:(030) 1234-5678", "phone:555-123-4567"}},
+		{name: "phone compact", text: "Call +4915112345678.", expected: []string{"phone:+4915112345678"}},
+		{name: "no phone", text: "On 2026-10-19 at 12:30:45 Ada was 36", expected: nil},
+		{name: "no phone latency", text: "latency 250 1000", expected: nil},
+		{name: "no phone ports", text: "port 8080 9090", expected: nil},
+		{name: "no phone order id", text: "order 2026 1019 0403", expected: nil},
+		{name: "no phone count", text: "12 345 678 items", expected: nil},
+		{name: "no phone durations", text: "took 1500 ms, p99 2500.125 ms, uptime 86400-3600 s", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var found []string
+			for _, m := range find(builtinDetectors, tt.text) {
+				found = append(found, m.detector+":"+tt.text[m.start:m.end])
+			}
+			assert.Equal(t, tt.expected, found)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package redact
+
+import (
+	"io"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LogFormatter masks personal data in the formatted log entries of another formatter.
+type LogFormatter struct {
+	Formatter log.Formatter
+	Redactor  *Redactor
+}
+
+// NewLogFormatter wraps the formatter.
+func NewLogFormatter(formatter log.Formatter, redactor *Redactor) *LogFormatter {
+	return &LogFormatter{
+		Formatter: formatter,
+		Redactor:  redactor,
+	}
+}
+
+func (f *LogFormatter) Format(entry *log.Entry) ([]byte, error) {
+	formatted, err := f.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(f.Redactor.maskLog(string(formatted))), nil
+}
+
+// Writer masks personal data in everything written to another writer, e.g. the output of the
+// standard library logger.
+type Writer struct {
+	w        io.Writer
+	redactor *Redactor
+}
+
+// NewWriter wraps the writer.
+func NewWriter(w io.Writer, redactor *Redactor) *Writer {
+	return &Writer{
+		w:        w,
+		redactor: redactor,
+	}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.redactor.maskLog(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
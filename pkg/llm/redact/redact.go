@@ -0,0 +1,198 @@
+// Package redact detects personal data like email addresses, phone numbers, IBANs, credit card
+// numbers and IP addresses, and masks it in prompts, responses and logs.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+var (
+	redactedValues  = metrics.NewCounter("redacted_values_total")
+	maskedLogValues = metrics.NewCounter("masked_log_values_total")
+)
+
+// Modes of handling personal data in prompts.
+const (
+	// ModeOff sends prompts unchanged. Logs and transcripts are masked in every mode.
+	ModeOff = "off"
+	// ModeMask replaces personal data by its type, e.g. [EMAIL], before the prompt is sent.
+	ModeMask = "mask"
+	// ModeTokenize replaces personal data by numbered tokens, e.g. [EMAIL_1], and restores the
+	// values in the response.
+	ModeTokenize = "tokenize"
+)
+
+// Config configures the redaction of personal data.
+type Config struct {
+	Mode string `yaml:"mode"` // optional; off (default), mask or tokenize
+	// Detectors are the built-in detectors to use, default all: email, iban, credit_card, ip, phone.
+	Detectors []string `yaml:"detectors"`
+	// Custom are additional detectors for personal data specific to the application.
+	Custom []PatternConfig `yaml:"custom"`
+	// MaskResponses masks personal data in responses that the model did not get from the prompt.
+	MaskResponses bool `yaml:"maskResponses"`
+}
+
+// PatternConfig is a custom detector.
+type PatternConfig struct {
+	Name    string `yaml:"name"`    // type of the data, used in the placeholder
+	Pattern string `yaml:"pattern"` // regular expression of the data
+}
+
+// Redactor masks personal data.
+type Redactor struct {
+	mode          string
+	detectors     []Detector
+	maskResponses bool
+}
+
+// New creates a redactor with the configured detectors.
+func New(cfg Config) (*Redactor, error) {
+	mode := cfg.Mode
+	if mode == "" {
+		mode = ModeOff
+	}
+	switch mode {
+	case ModeOff, ModeMask, ModeTokenize:
+	default:
+		return nil, fmt.Errorf("unknown redaction mode: %s", cfg.Mode)
+	}
+
+	var detectors []Detector
+	if len(cfg.Detectors) == 0 {
+		detectors = slices.Clone(builtinDetectors)
+	}
+	for _, name := range cfg.Detectors {
+		i := slices.IndexFunc(builtinDetectors, func(d Detector) bool { return d.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown detector: %s", name)
+		}
+		detectors = append(detectors, builtinDetectors[i])
+	}
+
+	for _, custom := range cfg.Custom {
+		if custom.Name == "" {
+			return nil, fmt.Errorf("custom detector %q requires a name", custom.Pattern)
+		}
+		re, err := regexp.Compile(custom.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of detector %s: %w", custom.Name, err)
+		}
+		detectors = append(detectors, Detector{Name: custom.Name, re: re})
+	}
+
+	return &Redactor{
+		mode:          mode,
+		detectors:     detectors,
+		maskResponses: cfg.MaskResponses,
+	}, nil
+}
+
+// Mode returns the mode of handling personal data in prompts.
+func (r *Redactor) Mode() string {
+	return r.mode
+}
+
+// Mask replaces personal data by its type, e.g. [EMAIL].
+func (r *Redactor) Mask(text string) string {
+	return r.replace(text, redactedValues, func(m match, value string) string {
+		return placeholder(m.detector, 0)
+	})
+}
+
+// maskLog masks a log line, its values are counted apart from the redacted prompts and responses.
+func (r *Redactor) maskLog(text string) string {
+	return r.replace(text, maskedLogValues, func(m match, value string) string {
+		return placeholder(m.detector, 0)
+	})
+}
+
+// Tokenize replaces personal data by numbered tokens, e.g. [EMAIL_1]. The same value gets the same
+// token. The vault restores the values.
+func (r *Redactor) Tokenize(text string) (string, *Vault) {
+	vault := &Vault{}
+	tokens := make(map[string]string)
+	counts := make(map[string]int)
+
+	tokenized := r.replace(text, redactedValues, func(m match, value string) string {
+		if token, ok := tokens[value]; ok {
+			return token
+		}
+		counts[m.detector]++
+		token := placeholder(m.detector, counts[m.detector])
+		tokens[value] = token
+		vault.tokens = append(vault.tokens, token, value)
+		return token
+	})
+
+	return tokenized, vault
+}
+
+// RedactPrompt handles personal data in a prompt according to the mode. The vault restores
+// tokenized values in the response, it is empty in the other modes.
+func (r *Redactor) RedactPrompt(text string) (string, *Vault) {
+	switch r.mode {
+	case ModeMask:
+		return r.Mask(text), &Vault{}
+	case ModeTokenize:
+		return r.Tokenize(text)
+	default:
+		return text, &Vault{}
+	}
+}
+
+// RedactResponse masks personal data the model produced if configured, then restores the values of
+// the tokens of the prompt.
+func (r *Redactor) RedactResponse(text string, vault *Vault) string {
+	if r.maskResponses {
+		text = r.Mask(text)
+	}
+
+	return vault.Restore(text)
+}
+
+func (r *Redactor) replace(text string, counter *metrics.Counter, replacement func(match, string) string) string {
+	matches := find(r.detectors, text)
+	if len(matches) == 0 {
+		return text
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(text[last:m.start])
+		b.WriteString(replacement(m, text[m.start:m.end]))
+		last = m.end
+		counter.Inc(m.detector)
+	}
+	b.WriteString(text[last:])
+
+	return b.String()
+}
+
+func placeholder(detector string, n int) string {
+	name := strings.ToUpper(detector)
+	if n == 0 {
+		return "[" + name + "]"
+	}
+	return fmt.Sprintf("[%s_%d]", name, n)
+}
+
+// Vault holds the values of the tokens of a prompt.
+type Vault struct {
+	tokens []string // pairs of token and value
+}
+
+// Restore replaces the tokens in the text by their values.
+func (v *Vault) Restore(text string) string {
+	if v == nil || len(v.tokens) == 0 {
+		return text
+	}
+
+	return strings.NewReplacer(v.tokens...).Replace(text)
+}
@@ -0,0 +1,122 @@
+package redact
+
+import (
+	"bytes"
+	"expvar"
+	"io"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMask(t *testing.T) {
+	redactor, err := New(Config{Custom: []PatternConfig{{Name: "employee_id", Pattern: `\bEMP-\d{6}\b`}}})
+	require.NoError(t, err)
+
+	masked := redactor.Mask("Ada (EMP-123456, ada@example.com) called from 10.0.0.1")
+	assert.Equal(t, "Ada ([EMPLOYEE_ID], [EMAIL]) called from [IP]", masked)
+}
+
+func TestTokenizeAndRestore(t *testing.T) {
+	redactor, err := New(Config{Mode: ModeTokenize})
+	require.NoError(t, err)
+
+	prompt, vault := redactor.RedactPrompt("Write to ada@example.com and bob@example.com, cc ada@example.com")
+	assert.Equal(t, "Write to [EMAIL_1] and [EMAIL_2], cc [EMAIL_1]", prompt)
+
+	response := redactor.RedactResponse(`{"to":"[EMAIL_2]","cc":"[EMAIL_1]"}`, vault)
+	assert.Equal(t, `{"to":"bob@example.com","cc":"ada@example.com"}`, response)
+}
+
+func TestRedactResponseMasksNewValues(t *testing.T) {
+	redactor, err := New(Config{Mode: ModeTokenize, MaskResponses: true})
+	require.NoError(t, err)
+
+	_, vault := redactor.RedactPrompt("Who is ada@example.com?")
+	response := redactor.RedactResponse("[EMAIL_1] works with eve@example.com", vault)
+	assert.Equal(t, "ada@example.com works with [EMAIL]", response)
+}
+
+func TestRedactPromptModes(t *testing.T) {
+	off, err := New(Config{})
+	require.NoError(t, err)
+	assert.Equal(t, ModeOff, off.Mode())
+	prompt, _ := off.RedactPrompt("ada@example.com")
+	assert.Equal(t, "ada@example.com", prompt)
+
+	mask, err := New(Config{Mode: ModeMask, Detectors: []string{DetectorPhone}})
+	require.NoError(t, err)
+	prompt, vault := mask.RedactPrompt("ada@example.com, +49 30 1234567")
+	assert.Equal(t, "ada@example.com, [PHONE]", prompt)
+	assert.Equal(t, "[PHONE]", mask.RedactResponse("[PHONE]", vault))
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	_, err := New(Config{Mode: "encrypt"})
+	assert.ErrorContains(t, err, "unknown redaction mode")
+
+	_, err = New(Config{Detectors: []string{"ssn"}})
+	assert.ErrorContains(t, err, "unknown detector")
+
+	_, err = New(Config{Custom: []PatternConfig{{Pattern: "x"}}})
+	assert.ErrorContains(t, err, "requires a name")
+
+	_, err = New(Config{Custom: []PatternConfig{{Name: "x", Pattern: "("}}})
+	assert.ErrorContains(t, err, "invalid pattern")
+}
+
+func TestLogFormatter(t *testing.T) {
+	redactor, err := New(Config{})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(NewLogFormatter(&log.JSONFormatter{}, redactor))
+
+	logger.WithField("user", "ada@example.com").Info("Request from 192.168.1.20")
+	assert.Contains(t, out.String(), `"user":"[EMAIL]"`)
+	assert.Contains(t, out.String(), `"msg":"Request from [IP]"`)
+	assert.NotContains(t, out.String(), "ada@example.com")
+
+	logger.WithField("latency", "250 1000").Info("Listening on port 8080 9090")
+	assert.Contains(t, out.String(), `"latency":"250 1000"`)
+	assert.Contains(t, out.String(), `"msg":"Listening on port 8080 9090"`)
+}
+
+func TestLogMaskingIsCountedApart(t *testing.T) {
+	redactor, err := New(Config{Detectors: []string{DetectorIP}})
+	require.NoError(t, err)
+
+	before := counterValue("redacted_values_total", DetectorIP)
+	beforeLogs := counterValue("masked_log_values_total", DetectorIP)
+
+	_, err = NewWriter(io.Discard, redactor).Write([]byte("Request from 192.168.1.20"))
+	require.NoError(t, err)
+	assert.Equal(t, before, counterValue("redacted_values_total", DetectorIP))
+	assert.Equal(t, beforeLogs+1, counterValue("masked_log_values_total", DetectorIP))
+
+	redactor.Mask("Request from 192.168.1.20")
+	assert.Equal(t, before+1, counterValue("redacted_values_total", DetectorIP))
+}
+
+func counterValue(name, label string) int64 {
+	value, ok := expvar.Get(name).(*expvar.Map).Get(label).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return value.Value()
+}
+
+func TestWriter(t *testing.T) {
+	redactor, err := New(Config{})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	n, err := NewWriter(&out, redactor).Write([]byte("GET /query?email=ada@example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, 32, n)
+	assert.Equal(t, "GET /query?email=[EMAIL]", out.String())
+}
@@ -0,0 +1,144 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// splitPattern pre-splits text into words before byte pair merging. It follows the GPT-2 pattern
+// without the lookahead that Go's regexp package does not support.
+var splitPattern = regexp.MustCompile(`'s|'t|'re|'ve|'m|'ll|'d| ?\pL+| ?\pN+| ?[^\s\pL\pN]+|\s+`)
+
+// BPECounter counts tokens with a byte level byte pair encoding loaded from a local vocab file.
+type BPECounter struct {
+	ranks map[string]int
+}
+
+// LoadBPE loads a tiktoken style vocab file. Every line holds a base64 encoded token and its rank,
+// separated by a space. Lower ranks are merged first.
+func LoadBPE(path string) (*BPECounter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening vocab file: %w", err)
+	}
+	defer file.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("vocab line %d: expected token and rank", line)
+		}
+
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("vocab line %d: decoding token: %w", line, err)
+		}
+
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("vocab line %d: parsing rank: %w", line, err)
+		}
+
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading vocab file: %w", err)
+	}
+
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("vocab file is empty: %s", path)
+	}
+
+	return &BPECounter{
+		ranks: ranks,
+	}, nil
+}
+
+func (c *BPECounter) Count(text string) int {
+	count := 0
+	for _, word := range splitPattern.FindAllString(text, -1) {
+		count += len(c.encodeWord(word))
+	}
+
+	return count
+}
+
+func (c *BPECounter) Truncate(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	remaining := maxTokens
+	for _, word := range splitPattern.FindAllString(text, -1) {
+		parts := c.encodeWord(word)
+		if len(parts) > remaining {
+			parts = parts[:remaining]
+		}
+		for _, part := range parts {
+			b.WriteString(part)
+		}
+
+		remaining -= len(parts)
+		if remaining == 0 {
+			break
+		}
+	}
+
+	return trimInvalidUTF8(b.String())
+}
+
+// encodeWord splits a pre-tokenized word into its tokens by repeatedly merging the adjacent pair
+// with the lowest rank. Bytes unknown to the vocab stay single tokens.
+func (c *BPECounter) encodeWord(word string) []string {
+	if _, ok := c.ranks[word]; ok {
+		return []string{word}
+	}
+
+	parts := make([]string, len(word))
+	for i := range len(word) {
+		parts[i] = word[i : i+1]
+	}
+
+	for len(parts) > 1 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i < len(parts)-1; i++ {
+			if rank, ok := c.ranks[parts[i]+parts[i+1]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+
+	return parts
+}
+
+// trimInvalidUTF8 removes a multi byte character that was cut in half by truncation.
+func trimInvalidUTF8(s string) string {
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
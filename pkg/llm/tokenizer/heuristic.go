@@ -0,0 +1,44 @@
+package tokenizer
+
+import (
+	"math"
+	"unicode/utf8"
+)
+
+const defaultCharsPerToken = 4.0
+
+// HeuristicCounter estimates tokens from the number of characters. It needs no vocabulary and is a
+// good enough approximation for English text with most BPE tokenizers.
+type HeuristicCounter struct {
+	charsPerToken float64
+}
+
+// NewHeuristicCounter creates a counter that assumes charsPerToken characters per token.
+// A value <= 0 falls back to the default of 4 characters per token.
+func NewHeuristicCounter(charsPerToken float64) *HeuristicCounter {
+	if charsPerToken <= 0 {
+		charsPerToken = defaultCharsPerToken
+	}
+
+	return &HeuristicCounter{
+		charsPerToken: charsPerToken,
+	}
+}
+
+func (c *HeuristicCounter) Count(text string) int {
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / c.charsPerToken))
+}
+
+func (c *HeuristicCounter) Truncate(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+
+	maxChars := int(float64(maxTokens) * c.charsPerToken)
+	runes := []rune(text)
+	if len(runes) <= maxChars {
+		return text
+	}
+
+	return string(runes[:maxChars])
+}
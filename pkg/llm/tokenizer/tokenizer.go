@@ -0,0 +1,39 @@
+package tokenizer
+
+import "fmt"
+
+// Counter is the common interface for all token counters. A model definition declares which counter
+// matches its tokenizer so that prompts can be measured against the model's context window.
+type Counter interface {
+	// Count returns the number of tokens the text is encoded to.
+	Count(text string) int
+	// Truncate shortens the text to at most maxTokens tokens.
+	Truncate(text string, maxTokens int) string
+}
+
+const (
+	TypeHeuristic = "heuristic"
+	TypeBPE       = "bpe"
+)
+
+// Config selects and configures a token counter.
+type Config struct {
+	Type          string  `yaml:"type"`          // heuristic (default) or bpe
+	VocabFile     string  `yaml:"vocabFile"`     // required for bpe; path to a tiktoken style vocab file
+	CharsPerToken float64 `yaml:"charsPerToken"` // optional for heuristic
+}
+
+// New creates the token counter described by the config.
+func New(cfg Config) (Counter, error) {
+	switch cfg.Type {
+	case "", TypeHeuristic:
+		return NewHeuristicCounter(cfg.CharsPerToken), nil
+	case TypeBPE:
+		if cfg.VocabFile == "" {
+			return nil, fmt.Errorf("bpe tokenizer requires a vocab file")
+		}
+		return LoadBPE(cfg.VocabFile)
+	default:
+		return nil, fmt.Errorf("unknown tokenizer type: %s", cfg.Type)
+	}
+}
@@ -0,0 +1,97 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeVocab(t *testing.T, tokens []string) string {
+	t.Helper()
+
+	var b strings.Builder
+	for rank, token := range tokens {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+
+	path := filepath.Join(t.TempDir(), "vocab.tiktoken")
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0644))
+
+	return path
+}
+
+func TestNewCounter(t *testing.T) {
+	counter, err := New(Config{})
+	assert.NoError(t, err)
+	assert.IsType(t, &HeuristicCounter{}, counter)
+
+	_, err = New(Config{Type: TypeBPE})
+	assert.Error(t, err)
+
+	_, err = New(Config{Type: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestHeuristicCounter(t *testing.T) {
+	counter := NewHeuristicCounter(0)
+
+	assert.Equal(t, 0, counter.Count(""))
+	assert.Equal(t, 1, counter.Count("abc"))
+	assert.Equal(t, 3, counter.Count("Hello World!"))
+	assert.Equal(t, "Hello Wo", counter.Truncate("Hello World!", 2))
+	assert.Equal(t, "Hi", counter.Truncate("Hi", 2))
+	assert.Equal(t, "", counter.Truncate("Hi", 0))
+}
+
+func TestBPECounter(t *testing.T) {
+	path := writeVocab(t, []string{"l", "o", "w", "e", "r", " ", "lo", "low", " low", "er", "lower"})
+
+	counter, err := LoadBPE(path)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		text  string
+		count int
+	}{
+		{text: "low", count: 1},
+		{text: "lower", count: 1},
+		{text: "low low", count: 2},
+		{text: "lowe", count: 2},
+		{text: "xyz", count: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.text, func(t *testing.T) {
+			assert.Equal(t, tc.count, counter.Count(tc.text))
+		})
+	}
+
+	assert.Equal(t, "low low", counter.Truncate("low low low", 2))
+	assert.Equal(t, "lo", counter.Truncate("lo", 5))
+}
+
+func TestBPETruncateKeepsValidUTF8(t *testing.T) {
+	path := writeVocab(t, []string{"a"})
+
+	counter, err := LoadBPE(path)
+	require.NoError(t, err)
+
+	// "ä" is two bytes that are not in the vocab and therefore two tokens.
+	assert.Equal(t, "a", counter.Truncate("aä", 2))
+}
+
+func TestLoadBPEInvalid(t *testing.T) {
+	_, err := LoadBPE(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "vocab")
+	require.NoError(t, os.WriteFile(path, []byte("not-base64! 1\n"), 0644))
+	_, err = LoadBPE(path)
+	assert.Error(t, err)
+}
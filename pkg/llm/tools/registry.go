@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Handler runs a tool with the arguments of a tool call and returns the result for the model.
+type Handler interface {
+	Call(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// HandlerFunc is a function used as Handler.
+type HandlerFunc func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+func (f HandlerFunc) Call(ctx context.Context, arguments json.RawMessage) (string, error) {
+	return f(ctx, arguments)
+}
+
+// Typed returns a handler that decodes the arguments into T, e.g. a type generated from the CUE
+// schema of the arguments.
+func Typed[T any](fn func(ctx context.Context, arguments T) (string, error)) Handler {
+	return HandlerFunc(func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var arguments T
+		if err := json.Unmarshal(raw, &arguments); err != nil {
+			return "", fmt.Errorf("decoding arguments: %w", err)
+		}
+
+		return fn(ctx, arguments)
+	})
+}
+
+// Registry holds the handlers of all tools by the name used in the prompt templates.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// defaultRegistry serves the tools of the application started by run.Run.
+var defaultRegistry = NewRegistry()
+
+// Register adds the handler of a tool to the default registry, e.g. from main before the server is
+// started. Registering a tool twice is an error.
+func Register(name string, handler Handler) error {
+	return defaultRegistry.Register(name, handler)
+}
+
+// Default returns the registry of the tools registered with Register.
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register adds the handler of a tool. Registering a tool twice is an error.
+func (r *Registry) Register(name string, handler Handler) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.handlers[name]; exists {
+		return fmt.Errorf("tool %s is already registered", name)
+	}
+	r.handlers[name] = handler
+
+	return nil
+}
+
+// Lookup returns the handler of the tool.
+func (r *Registry) Lookup(name string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	handler, ok := r.handlers[name]
+	return handler, ok
+}
+
+// Names returns the names of all registered tools in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	echo := HandlerFunc(func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		return string(arguments), nil
+	})
+	require.NoError(t, registry.Register("echo", echo))
+	require.NoError(t, registry.Register("add", echo))
+	assert.Error(t, registry.Register("echo", echo))
+
+	assert.Equal(t, []string{"add", "echo"}, registry.Names())
+
+	handler, ok := registry.Lookup("echo")
+	require.True(t, ok)
+	result, err := handler.Call(context.Background(), json.RawMessage(`{"a":1}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, result)
+
+	_, ok = registry.Lookup("unknown")
+	assert.False(t, ok)
+}
+
+func TestTyped(t *testing.T) {
+	type weather struct {
+		City string `json:"city"`
+	}
+	handler := Typed(func(ctx context.Context, arguments weather) (string, error) {
+		return "sunny in " + arguments.City, nil
+	})
+
+	result, err := handler.Call(context.Background(), json.RawMessage(`{"city":"Berlin"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "sunny in Berlin", result)
+
+	_, err = handler.Call(context.Background(), json.RawMessage(`{"city":1}`))
+	assert.ErrorContains(t, err, "decoding arguments")
+}
@@ -0,0 +1,234 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/config"
+)
+
+const (
+	defaultTimeout        = 60 * time.Second
+	defaultMaxAttempts    = 3
+	defaultInitialBackoff = 250 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+	defaultMaxRetryAfter  = 30 * time.Second
+
+	// maxErrorBodySize limits how much of an error response is kept in an UpstreamError.
+	maxErrorBodySize = 4096
+)
+
+// Config configures the HTTP transport of a model adapter.
+type Config struct {
+	Timeout config.Duration   `yaml:"timeout"` // optional; per attempt, default 60s
+	Retry   RetryConfig       `yaml:"retry"`
+	Headers map[string]string `yaml:"headers"` // optional; e.g. Authorization, ${VAR} is replaced by the environment variable
+}
+
+// RetryConfig configures how failed calls are retried. Only transient failures are retried.
+type RetryConfig struct {
+	MaxAttempts    int             `yaml:"maxAttempts"`    // optional; including the first call, default 3
+	InitialBackoff config.Duration `yaml:"initialBackoff"` // optional; default 250ms
+	MaxBackoff     config.Duration `yaml:"maxBackoff"`     // optional; default 5s
+	MaxRetryAfter  config.Duration `yaml:"maxRetryAfter"`  // optional; longest Retry-After that is waited for, longer ones end the retries, default 30s
+}
+
+// Client is the HTTP transport shared by all model adapters. It retries transient failures with
+// jittered exponential backoff and honours the Retry-After header of the backend.
+type Client struct {
+	httpClient     *http.Client
+	header         http.Header
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxRetryAfter  time.Duration
+
+	// sleep waits between attempts; replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewClient creates a client for the given config, unset values fall back to defaults.
+func NewClient(cfg Config) *Client {
+	maxAttempts := cfg.Retry.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	header := make(http.Header)
+	for key, value := range cfg.Headers {
+		header.Set(key, os.ExpandEnv(value))
+	}
+
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: cfg.Timeout.Or(defaultTimeout),
+		},
+		header:         header,
+		maxAttempts:    maxAttempts,
+		initialBackoff: cfg.Retry.InitialBackoff.Or(defaultInitialBackoff),
+		maxBackoff:     cfg.Retry.MaxBackoff.Or(defaultMaxBackoff),
+		maxRetryAfter:  cfg.Retry.MaxRetryAfter.Or(defaultMaxRetryAfter),
+		sleep:          sleepContext,
+	}
+}
+
+// Endpoints picks the base url of every attempt, e.g. one of the replicas of a model. The outcome of
+// the attempt is passed to release.
+type Endpoints interface {
+	Acquire() (url string, release func(error), err error)
+}
+
+// fixedEndpoint sends every attempt to the same url.
+type fixedEndpoint string
+
+func (e fixedEndpoint) Acquire() (string, func(error), error) {
+	return string(e), func(error) {}, nil
+}
+
+// PostJSON sends the payload as JSON to the url and returns the body of a successful response.
+// Failures are returned as *UpstreamError.
+func (c *Client) PostJSON(ctx context.Context, url string, payload any) ([]byte, error) {
+	return c.PostJSONTo(ctx, fixedEndpoint(url), "", payload)
+}
+
+// PostJSONTo is PostJSON with the url of every attempt picked by the endpoints, so that a retry can go
+// to another replica. The path is appended to the url. If no endpoint can be acquired, the error of
+// the endpoints is returned, or the failure of the previous attempt on a retry.
+func (c *Client) PostJSONTo(ctx context.Context, endpoints Endpoints, path string, payload any) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+
+	var lastErr *UpstreamError
+	for attempt := 1; attempt <= c.maxAttempts; attempt++ {
+		baseURL, release, err := endpoints.Acquire()
+		if err != nil {
+			if lastErr != nil {
+				break
+			}
+			return nil, err
+		}
+		url := baseURL + path
+
+		body, upstreamErr := c.post(ctx, url, data)
+		if upstreamErr == nil {
+			release(nil)
+			return body, nil
+		}
+		release(upstreamErr)
+
+		upstreamErr.Attempts = attempt
+		lastErr = upstreamErr
+		if !upstreamErr.Retryable() || attempt == c.maxAttempts {
+			break
+		}
+		// The backend asked for more time than we wait, retrying earlier would only hit it again. The
+		// error carries the requested wait for the caller and the circuit breaker.
+		if upstreamErr.RetryAfter > c.maxRetryAfter {
+			break
+		}
+
+		wait := max(c.backoff(attempt), upstreamErr.RetryAfter)
+
+		log.WithFields(log.Fields{
+			"url":     url,
+			"attempt": attempt,
+			"kind":    upstreamErr.Kind,
+			"status":  upstreamErr.StatusCode,
+			"wait":    wait,
+		}).Warn("Retrying model call")
+
+		if err := c.sleep(ctx, wait); err != nil {
+			lastErr = &UpstreamError{Kind: KindCanceled, Attempts: attempt, Err: err}
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+// post makes a single attempt. A failure carries the Retry-After duration requested by the backend.
+func (c *Client) post(ctx context.Context, url string, data []byte) ([]byte, *UpstreamError) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, &UpstreamError{Kind: KindClient, Err: fmt.Errorf("creating request: %w", err)}
+	}
+
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, &UpstreamError{Kind: classifyError(ctx, err), Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return nil, &UpstreamError{
+			Kind:       classifyStatus(resp.StatusCode),
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &UpstreamError{Kind: classifyError(ctx, err), StatusCode: resp.StatusCode, Err: err}
+	}
+
+	return body, nil
+}
+
+// backoff returns the wait time before the next attempt using exponential backoff with full jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.initialBackoff << (attempt - 1)
+	if ceiling <= 0 || ceiling > c.maxBackoff {
+		ceiling = c.maxBackoff
+	}
+
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,192 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient returns a client that records its waits instead of sleeping.
+func newTestClient(cfg Config) (*Client, *[]time.Duration) {
+	waits := &[]time.Duration{}
+	client := NewClient(cfg)
+	client.sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return ctx.Err()
+	}
+
+	return client, waits
+}
+
+// statusSequence serves the given status codes in order and 200 afterwards.
+func statusSequence(t *testing.T, header http.Header, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	calls := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		if call <= len(statuses) {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(statuses[call-1])
+			w.Write([]byte("upstream says no"))
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, calls
+}
+
+func TestPostJSONRetriesTransientFailures(t *testing.T) {
+	server, calls := statusSequence(t, nil, http.StatusServiceUnavailable, http.StatusBadGateway)
+	client, waits := newTestClient(Config{})
+
+	body, err := client.PostJSON(context.Background(), server.URL, map[string]string{"a": "b"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ok":true}`, string(body))
+	assert.Equal(t, int32(3), calls.Load())
+	require.Len(t, *waits, 2)
+	assert.LessOrEqual(t, (*waits)[0], defaultInitialBackoff)
+	assert.LessOrEqual(t, (*waits)[1], 2*defaultInitialBackoff)
+}
+
+func TestPostJSONDoesNotRetryClientErrors(t *testing.T) {
+	server, calls := statusSequence(t, nil, http.StatusBadRequest)
+	client, waits := newTestClient(Config{})
+
+	_, err := client.PostJSON(context.Background(), server.URL, nil)
+
+	var upstreamErr *UpstreamError
+	require.ErrorAs(t, err, &upstreamErr)
+	assert.Equal(t, KindClient, upstreamErr.Kind)
+	assert.Equal(t, http.StatusBadRequest, upstreamErr.StatusCode)
+	assert.Equal(t, "upstream says no", upstreamErr.Body)
+	assert.Equal(t, 1, upstreamErr.Attempts)
+	assert.False(t, upstreamErr.Retryable())
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Empty(t, *waits)
+}
+
+func TestPostJSONHonoursRetryAfter(t *testing.T) {
+	header := http.Header{"Retry-After": []string{"7"}}
+	server, _ := statusSequence(t, header, http.StatusTooManyRequests)
+	client, waits := newTestClient(Config{})
+
+	_, err := client.PostJSON(context.Background(), server.URL, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{7 * time.Second}, *waits)
+}
+
+func TestPostJSONStopsWhenRetryAfterExceedsLimit(t *testing.T) {
+	header := http.Header{"Retry-After": []string{"120"}}
+	server, calls := statusSequence(t, header, http.StatusServiceUnavailable)
+	client, waits := newTestClient(Config{})
+
+	_, err := client.PostJSON(context.Background(), server.URL, nil)
+
+	var upstreamErr *UpstreamError
+	require.ErrorAs(t, err, &upstreamErr)
+	assert.Equal(t, 2*time.Minute, upstreamErr.RetryAfter)
+	assert.Equal(t, 1, upstreamErr.Attempts)
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Empty(t, *waits)
+}
+
+func TestPostJSONGivesUpAfterMaxAttempts(t *testing.T) {
+	server, calls := statusSequence(t, nil, 500, 500, 500, 500)
+	client, _ := newTestClient(Config{Retry: RetryConfig{MaxAttempts: 2}})
+
+	_, err := client.PostJSON(context.Background(), server.URL, nil)
+
+	var upstreamErr *UpstreamError
+	require.ErrorAs(t, err, &upstreamErr)
+	assert.Equal(t, KindServer, upstreamErr.Kind)
+	assert.Equal(t, 2, upstreamErr.Attempts)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestPostJSONConnectionRefused(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	client, waits := newTestClient(Config{})
+	_, err := client.PostJSON(context.Background(), url, nil)
+
+	var upstreamErr *UpstreamError
+	require.ErrorAs(t, err, &upstreamErr)
+	assert.Equal(t, KindConnection, upstreamErr.Kind)
+	assert.Equal(t, defaultMaxAttempts, upstreamErr.Attempts)
+	assert.Len(t, *waits, defaultMaxAttempts-1)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	assert.InDelta(t, time.Minute, parseRetryAfter(date), float64(2*time.Second))
+}
+
+func TestPostJSONSendsConfiguredHeaders(t *testing.T) {
+	t.Setenv("TEST_API_KEY", "secret")
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client, _ := newTestClient(Config{Headers: map[string]string{"Authorization": "Bearer ${TEST_API_KEY}"}})
+	_, err := client.PostJSON(context.Background(), server.URL, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer secret", authorization)
+}
+
+// roundRobinEndpoints hands out the urls in turn and records the outcome of every attempt.
+type roundRobinEndpoints struct {
+	urls     []string
+	next     int
+	outcomes []error
+}
+
+func (e *roundRobinEndpoints) Acquire() (string, func(error), error) {
+	if len(e.urls) == 0 {
+		return "", nil, assert.AnError
+	}
+
+	url := e.urls[e.next%len(e.urls)]
+	e.next++
+	return url, func(err error) { e.outcomes = append(e.outcomes, err) }, nil
+}
+
+func TestPostJSONToRetriesOnNextEndpoint(t *testing.T) {
+	failing, failingCalls := statusSequence(t, nil, http.StatusServiceUnavailable)
+	healthy, healthyCalls := statusSequence(t, nil)
+	client, _ := newTestClient(Config{})
+
+	endpoints := &roundRobinEndpoints{urls: []string{failing.URL, healthy.URL}}
+	body, err := client.PostJSONTo(context.Background(), endpoints, "/chat/completions", map[string]string{"a": "b"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ok":true}`, string(body))
+	assert.Equal(t, int32(1), failingCalls.Load())
+	assert.Equal(t, int32(1), healthyCalls.Load())
+
+	require.Len(t, endpoints.outcomes, 2)
+	var upstreamErr *UpstreamError
+	require.ErrorAs(t, endpoints.outcomes[0], &upstreamErr)
+	assert.Equal(t, http.StatusServiceUnavailable, upstreamErr.StatusCode)
+	assert.Nil(t, endpoints.outcomes[1])
+
+	_, err = client.PostJSONTo(context.Background(), &roundRobinEndpoints{}, "/chat/completions", nil)
+	assert.ErrorIs(t, err, assert.AnError)
+}
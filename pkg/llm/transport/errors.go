@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Kind classifies why a call to a model backend failed.
+type Kind string
+
+const (
+	KindConnection  Kind = "connection"   // backend not reachable, e.g. connection refused or reset
+	KindTimeout     Kind = "timeout"      // no response in time
+	KindRateLimited Kind = "rate_limited" // 429 Too Many Requests
+	KindServer      Kind = "server"       // 5xx
+	KindClient      Kind = "client"       // 4xx other than 408 and 429; retrying does not help
+	KindCanceled    Kind = "canceled"     // the caller canceled the request
+)
+
+// UpstreamError is returned for every failed call to a model backend. It carries the upstream
+// status and body so that callers can tell backend failures apart from their own errors.
+type UpstreamError struct {
+	Kind       Kind
+	StatusCode int    // 0 if no response was received
+	Body       string // response body, truncated
+	Attempts   int
+	// RetryAfter is the wait requested by the Retry-After header of the last response, zero if none.
+	RetryAfter time.Duration
+	Err        error // underlying transport error, if any
+}
+
+func (e *UpstreamError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("upstream %s error: status %d after %d attempt(s): %s", e.Kind, e.StatusCode, e.Attempts, e.Body)
+	}
+
+	return fmt.Sprintf("upstream %s error after %d attempt(s): %v", e.Kind, e.Attempts, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the failure is transient and the call may succeed when repeated.
+func (e *UpstreamError) Retryable() bool {
+	switch e.Kind {
+	case KindConnection, KindTimeout, KindRateLimited, KindServer:
+		return true
+	default:
+		return false
+	}
+}
+
+// classifyStatus maps a non 2xx status code to its kind.
+func classifyStatus(statusCode int) Kind {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return KindRateLimited
+	case statusCode == http.StatusRequestTimeout:
+		return KindTimeout
+	case statusCode >= 500:
+		return KindServer
+	default:
+		return KindClient
+	}
+}
+
+// classifyError maps an error of http.Client.Do to its kind.
+func classifyError(ctx context.Context, err error) Kind {
+	if ctx.Err() != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return KindTimeout
+		}
+		return KindCanceled
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return KindTimeout
+	}
+
+	// Everything else, e.g. connection refused or reset, DNS failures or a closed connection,
+	// means the backend could not be reached.
+	return KindConnection
+}
@@ -0,0 +1,149 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const (
+	// FormatText renders a schema as a human readable list of fields and their constraints.
+	FormatText = "text"
+	// FormatJSONSchema renders a schema as a JSON Schema snippet.
+	FormatJSONSchema = "jsonschema"
+)
+
+// JSONSchema returns the JSON Schema of the given response schema as generated from its CUE definition.
+func (v *ResponseSchemaValidator) JSONSchema(schema string) (json.RawMessage, error) {
+	openapiSchema, exists := v.schemas[schema]
+	if !exists {
+		return nil, fmt.Errorf("schema not found: %s", schema)
+	}
+
+	data, err := json.Marshal(openapiSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema %s: %w", schema, err)
+	}
+
+	return data, nil
+}
+
+// DescribeSchema renders the given response schema as output format instructions for a model.
+// Field types, constraints and the CUE comments of the schema are part of the description.
+func (v *ResponseSchemaValidator) DescribeSchema(schema, format string) (string, error) {
+	openapiSchema, exists := v.schemas[schema]
+	if !exists {
+		return "", fmt.Errorf("schema not found: %s", schema)
+	}
+
+	switch format {
+	case FormatText:
+		var b strings.Builder
+		b.WriteString("Respond only with a JSON object")
+		if openapiSchema.Description != "" {
+			fmt.Fprintf(&b, " (%s)", openapiSchema.Description)
+		}
+		b.WriteString(" with exactly these fields:\n")
+		describeProperties(&b, openapiSchema, 0)
+
+		return strings.TrimRight(b.String(), "\n"), nil
+	case FormatJSONSchema:
+		data, err := json.MarshalIndent(openapiSchema, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal schema %s: %w", schema, err)
+		}
+
+		return fmt.Sprintf("Respond only with a JSON object that is valid against this JSON Schema:\n```json\n%s\n```", data), nil
+	default:
+		return "", fmt.Errorf("unknown schema description format: %s", format)
+	}
+}
+
+// describeProperties writes one line per property, nested objects are indented.
+func describeProperties(b *strings.Builder, schema *openapi3.Schema, depth int) {
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	indent := strings.Repeat("  ", depth)
+	for _, name := range names {
+		property := schema.Properties[name].Value
+		if property == nil {
+			continue
+		}
+
+		details := []string{describeType(property)}
+		if slices.Contains(schema.Required, name) {
+			details = append(details, "required")
+		} else {
+			details = append(details, "optional")
+		}
+		details = append(details, describeConstraints(property)...)
+
+		fmt.Fprintf(b, "%s- %s (%s)", indent, name, strings.Join(details, ", "))
+		if property.Description != "" {
+			fmt.Fprintf(b, ": %s", property.Description)
+		}
+		b.WriteString("\n")
+
+		if property.Type.Is(openapi3.TypeObject) {
+			describeProperties(b, property, depth+1)
+		}
+	}
+}
+
+func describeType(schema *openapi3.Schema) string {
+	if schema.Type.Is(openapi3.TypeArray) && schema.Items != nil && schema.Items.Value != nil {
+		return "array of " + describeType(schema.Items.Value)
+	}
+
+	if types := schema.Type.Slice(); len(types) > 0 {
+		return strings.Join(types, " or ")
+	}
+
+	return "any"
+}
+
+// describeConstraints renders the constraints of a schema in CUE notation, e.g. <=130.
+func describeConstraints(schema *openapi3.Schema) []string {
+	var constraints []string
+
+	if schema.Min != nil {
+		op := ">="
+		if schema.ExclusiveMin {
+			op = ">"
+		}
+		constraints = append(constraints, fmt.Sprintf("%s%v", op, *schema.Min))
+	}
+	if schema.Max != nil {
+		op := "<="
+		if schema.ExclusiveMax {
+			op = "<"
+		}
+		constraints = append(constraints, fmt.Sprintf("%s%v", op, *schema.Max))
+	}
+	if schema.MinLength > 0 {
+		constraints = append(constraints, fmt.Sprintf("at least %d characters", schema.MinLength))
+	}
+	if schema.MaxLength != nil {
+		constraints = append(constraints, fmt.Sprintf("at most %d characters", *schema.MaxLength))
+	}
+	if schema.Pattern != "" {
+		constraints = append(constraints, fmt.Sprintf("matching =~%q", schema.Pattern))
+	}
+	if len(schema.Enum) > 0 {
+		values := make([]string, len(schema.Enum))
+		for i, value := range schema.Enum {
+			encoded, _ := json.Marshal(value)
+			values[i] = string(encoded)
+		}
+		constraints = append(constraints, "one of "+strings.Join(values, " | "))
+	}
+
+	return constraints
+}
@@ -0,0 +1,46 @@
+package validation
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeSchemaText(t *testing.T) {
+	validator, err := NewResponseSchemaValidator([]string{"schemas/personResponse.cue"})
+	require.NoError(t, err)
+
+	description, err := validator.DescribeSchema("personResponse", FormatText)
+	require.NoError(t, err)
+
+	expected := "Respond only with a JSON object (A Person Response) with exactly these fields:\n" +
+		"- age (integer, required, <=130): Age of the person in years.\n" +
+		"- name (string, required): Full name of the person."
+	assert.Equal(t, expected, description)
+}
+
+func TestDescribeSchemaJSONSchema(t *testing.T) {
+	validator, err := NewResponseSchemaValidator([]string{"schemas/animalResponse.cue"})
+	require.NoError(t, err)
+
+	description, err := validator.DescribeSchema("animalResponse", FormatJSONSchema)
+	require.NoError(t, err)
+	assert.Contains(t, description, `"maximum": 50`)
+
+	schema, err := validator.JSONSchema("animalResponse")
+	require.NoError(t, err)
+	assert.True(t, json.Valid(schema))
+}
+
+func TestDescribeSchemaInvalid(t *testing.T) {
+	validator, err := NewResponseSchemaValidator([]string{"schemas/animalResponse.cue"})
+	require.NoError(t, err)
+
+	_, err = validator.DescribeSchema("personResponse", FormatText)
+	assert.Error(t, err)
+
+	_, err = validator.DescribeSchema("animalResponse", "xml")
+	assert.Error(t, err)
+}
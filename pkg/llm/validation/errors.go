@@ -0,0 +1,268 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// Violation is a constraint of a schema that the data does not satisfy.
+type Violation struct {
+	// Pointer is the JSON pointer of the value, empty for the whole document.
+	Pointer string `json:"pointer"`
+	// Value is the offending value, missing for required fields.
+	Value any `json:"value,omitempty"`
+	// Constraint is the violated constraint in CUE notation, e.g. <=130.
+	Constraint string `json:"constraint"`
+	Message    string `json:"message"`
+	// Source is the position of the constraint in the CUE schema, e.g. schemas/personResponse.cue:8:2.
+	Source string `json:"source,omitempty"`
+}
+
+// ValidationError lists every violation of a schema by the validated data.
+type ValidationError struct {
+	Schema     string
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		if violation.Pointer == "" {
+			messages[i] = violation.Message
+		} else {
+			messages[i] = violation.Pointer + ": " + violation.Message
+		}
+	}
+
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// Constraints of violations that have no CUE notation.
+const (
+	constraintJSON       = "valid JSON"
+	constraintRequired   = "required"
+	constraintNotAllowed = "not allowed"
+)
+
+var unsupportedProperty = regexp.MustCompile(`^property "(.*)" is unsupported$`)
+
+// newValidationError converts the errors of an OpenAPI schema validation into violations ordered by
+// pointer. Sources are the positions of the schema's fields by pattern, see schemaSources.
+func newValidationError(schema string, err error, sources map[string]string) *ValidationError {
+	var violations []Violation
+	for _, schemaErr := range schemaErrors(err) {
+		violation := Violation{
+			Pointer:    pointer(schemaErr.JSONPointer()),
+			Value:      schemaErr.Value,
+			Constraint: constraint(schemaErr),
+			Message:    schemaErr.Reason,
+		}
+
+		switch schemaErr.SchemaField {
+		case "required":
+			violation.Value = nil
+		case "properties", "additionalProperties":
+			// The error of a field that is not allowed points at its object.
+			if match := unsupportedProperty.FindStringSubmatch(schemaErr.Reason); match != nil {
+				violation.Pointer = pointer(slices.Concat(schemaErr.JSONPointer(), []string{match[1]}))
+				if object, ok := schemaErr.Value.(map[string]any); ok {
+					violation.Value = object[match[1]]
+				}
+				violation.Constraint = constraintNotAllowed
+				// Closed structs are declared by the definition.
+				violation.Source = sources[sourcePattern(schemaErr.JSONPointer())]
+			}
+		}
+		if violation.Source == "" {
+			violation.Source = sources[sourcePattern(pointerPath(violation.Pointer))]
+		}
+
+		violations = append(violations, violation)
+	}
+
+	slices.SortStableFunc(violations, func(a, b Violation) int {
+		return strings.Compare(a.Pointer, b.Pointer)
+	})
+
+	return &ValidationError{Schema: schema, Violations: violations}
+}
+
+// invalidJSONError reports data that is not JSON as a violation of the whole document.
+func invalidJSONError(schema string, err error) *ValidationError {
+	return &ValidationError{
+		Schema: schema,
+		Violations: []Violation{{
+			Constraint: constraintJSON,
+			Message:    "invalid JSON: " + err.Error(),
+		}},
+	}
+}
+
+// schemaErrors flattens the errors collected with openapi3.MultiErrors.
+func schemaErrors(err error) []*openapi3.SchemaError {
+	var multiErr openapi3.MultiError
+	if errors.As(err, &multiErr) {
+		var result []*openapi3.SchemaError
+		for _, err := range multiErr {
+			result = append(result, schemaErrors(err)...)
+		}
+		return result
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		return []*openapi3.SchemaError{schemaErr}
+	}
+
+	return []*openapi3.SchemaError{{Reason: err.Error()}}
+}
+
+// constraint renders the violated constraint of the error in CUE notation.
+func constraint(err *openapi3.SchemaError) string {
+	schema := err.Schema
+	if schema == nil {
+		return err.SchemaField
+	}
+
+	switch err.SchemaField {
+	case "type":
+		return cueType(schema)
+	case "required":
+		return constraintRequired
+	case "maximum":
+		if schema.Max != nil {
+			if schema.ExclusiveMax {
+				return fmt.Sprintf("<%v", *schema.Max)
+			}
+			return fmt.Sprintf("<=%v", *schema.Max)
+		}
+	case "minimum":
+		if schema.Min != nil {
+			if schema.ExclusiveMin {
+				return fmt.Sprintf(">%v", *schema.Min)
+			}
+			return fmt.Sprintf(">=%v", *schema.Min)
+		}
+	case "maxLength":
+		if schema.MaxLength != nil {
+			return fmt.Sprintf("strings.MaxRunes(%d)", *schema.MaxLength)
+		}
+	case "minLength":
+		return fmt.Sprintf("strings.MinRunes(%d)", schema.MinLength)
+	case "maxItems":
+		if schema.MaxItems != nil {
+			return fmt.Sprintf("list.MaxItems(%d)", *schema.MaxItems)
+		}
+	case "minItems":
+		return fmt.Sprintf("list.MinItems(%d)", schema.MinItems)
+	case "pattern":
+		return fmt.Sprintf("=~%q", schema.Pattern)
+	case "enum":
+		values := make([]string, len(schema.Enum))
+		for i, value := range schema.Enum {
+			encoded, _ := json.Marshal(value)
+			values[i] = string(encoded)
+		}
+		return strings.Join(values, " | ")
+	}
+
+	return err.SchemaField
+}
+
+// cueType returns the CUE type of an OpenAPI schema. The types are copied, the slice belongs to the
+// compiled schema shared by all validations.
+func cueType(schema *openapi3.Schema) string {
+	types := slices.Clone(schema.Type.Slice())
+	for i, typ := range types {
+		switch typ {
+		case openapi3.TypeInteger:
+			types[i] = "int"
+		case openapi3.TypeBoolean:
+			types[i] = "bool"
+		case openapi3.TypeArray:
+			types[i] = "[...]"
+		case openapi3.TypeObject:
+			types[i] = "{...}"
+		}
+	}
+
+	return strings.Join(types, " | ")
+}
+
+// pointer returns the JSON pointer (RFC 6901) of a path.
+func pointer(path []string) string {
+	var b strings.Builder
+	for _, segment := range path {
+		b.WriteString("/")
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(segment))
+	}
+
+	return b.String()
+}
+
+// pointerPath returns the path of a JSON pointer.
+func pointerPath(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+
+	path := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, segment := range path {
+		path[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+	}
+
+	return path
+}
+
+// sourcePattern returns the key of a path in the sources of a schema. List indices are replaced by *.
+func sourcePattern(path []string) string {
+	pattern := make([]string, len(path))
+	for i, segment := range path {
+		if _, err := strconv.Atoi(segment); err == nil {
+			segment = "*"
+		}
+		pattern[i] = segment
+	}
+
+	return pointer(pattern)
+}
+
+// schemaSources returns the positions of the fields of a CUE definition by their pointer pattern,
+// the definition itself has the empty pattern.
+func schemaSources(definition cue.Value) map[string]string {
+	sources := make(map[string]string)
+	add := func(path []string, value cue.Value) {
+		if pos := value.Pos(); pos.IsValid() {
+			sources[pointer(path)] = pos.String()
+		}
+	}
+	add(nil, definition)
+
+	var walk func(value cue.Value, path []string)
+	walk = func(value cue.Value, path []string) {
+		if elem := value.LookupPath(cue.MakePath(cue.AnyIndex)); elem.Exists() {
+			walk(elem, append(slices.Clone(path), "*"))
+		}
+
+		fields, err := value.Fields(cue.Optional(true))
+		if err != nil {
+			return
+		}
+		for fields.Next() {
+			fieldPath := append(slices.Clone(path), fields.Selector().Unquoted())
+			add(fieldPath, fields.Value())
+			walk(fields.Value(), fieldPath)
+		}
+	}
+	walk(definition, nil)
+
+	return sources
+}
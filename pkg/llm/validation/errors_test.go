@@ -0,0 +1,96 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validationError(t *testing.T, validator *ResponseSchemaValidator, schema, data string) *ValidationError {
+	t.Helper()
+	err := validator.Validate(schema, []byte(data))
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, schema, validationErr.Schema)
+	return validationErr
+}
+
+func TestValidationErrorViolations(t *testing.T) {
+	validator, err := NewResponseSchemaValidator([]string{"schemas/personResponse.cue", "schemas/weatherArguments.cue"})
+	require.NoError(t, err)
+
+	validationErr := validationError(t, validator, "personResponse", `{"name": 123, "age": 200, "extra": true}`)
+	assert.Equal(t, []Violation{
+		{
+			Pointer:    "/age",
+			Value:      float64(200),
+			Constraint: "<=130",
+			Message:    "number must be at most 130",
+			Source:     "schemas/personResponse.cue:8:2",
+		},
+		{
+			Pointer:    "/extra",
+			Value:      true,
+			Constraint: "not allowed",
+			Message:    `property "extra" is unsupported`,
+			Source:     "schemas/personResponse.cue:4:1",
+		},
+		{
+			Pointer:    "/name",
+			Value:      float64(123),
+			Constraint: "string",
+			Message:    "value must be a string",
+			Source:     "schemas/personResponse.cue:6:2",
+		},
+	}, validationErr.Violations)
+	assert.EqualError(t, validationErr, "validation failed: /age: number must be at most 130; "+
+		`/extra: property "extra" is unsupported; /name: value must be a string`)
+
+	validationErr = validationError(t, validator, "personResponse", `{"age": 1.5}`)
+	assert.Equal(t, []Violation{
+		{Pointer: "/age", Value: 1.5, Constraint: "int", Message: "value must be an integer", Source: "schemas/personResponse.cue:8:2"},
+		{Pointer: "/name", Constraint: "required", Message: `property "name" is missing`, Source: "schemas/personResponse.cue:6:2"},
+	}, validationErr.Violations)
+
+	validationErr = validationError(t, validator, "weatherArguments", `{"city": "Berlin", "unit": "kelvin"}`)
+	require.Len(t, validationErr.Violations, 1)
+	assert.Equal(t, "/unit", validationErr.Violations[0].Pointer)
+	assert.Equal(t, `"celsius" | "fahrenheit"`, validationErr.Violations[0].Constraint)
+	assert.Equal(t, "schemas/weatherArguments.cue:8:2", validationErr.Violations[0].Source)
+}
+
+func TestValidationErrorKeepsSchema(t *testing.T) {
+	validator, err := NewResponseSchemaValidator([]string{"schemas/personResponse.cue"})
+	require.NoError(t, err)
+	jsonSchema, err := validator.JSONSchema("personResponse")
+	require.NoError(t, err)
+
+	validationErr := validationError(t, validator, "personResponse", `{"name": "Ada", "age": "x"}`)
+	assert.Equal(t, "int", validationErr.Violations[0].Constraint)
+
+	// Describing a violation must not change the compiled schema shared by later validations.
+	assert.NoError(t, validator.Validate("personResponse", []byte(`{"name": "Ada", "age": 3}`)))
+	after, err := validator.JSONSchema("personResponse")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(jsonSchema), string(after))
+}
+
+func TestValidationErrorInvalidJSON(t *testing.T) {
+	validator, err := NewResponseSchemaValidator([]string{"schemas/personResponse.cue"})
+	require.NoError(t, err)
+
+	validationErr := validationError(t, validator, "personResponse", `{"name": "Ron",`)
+	require.Len(t, validationErr.Violations, 1)
+	assert.Equal(t, "", validationErr.Violations[0].Pointer)
+	assert.Equal(t, "valid JSON", validationErr.Violations[0].Constraint)
+	assert.Contains(t, validationErr.Error(), "validation failed: invalid JSON")
+}
+
+func TestPointer(t *testing.T) {
+	assert.Equal(t, "", pointer(nil))
+	assert.Equal(t, "/a~1b/c~0d/0", pointer([]string{"a/b", "c~d", "0"}))
+	assert.Equal(t, []string{"a/b", "c~d", "0"}, pointerPath("/a~1b/c~0d/0"))
+	assert.Equal(t, "/items/*/name", sourcePattern([]string{"items", "3", "name"}))
+}
@@ -0,0 +1,269 @@
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"cuelang.org/go/cue"
+)
+
+// Stages of the extraction, enabled per schema with an attribute of its definition, e.g.
+//
+//	#answerResponse: {...} @extract(fences, balanced, lenient)
+const (
+	// StageFences takes the content of the first markdown code fence.
+	StageFences = "fences"
+	// StageBalanced takes the first balanced JSON object or array and drops the text around it.
+	StageBalanced = "balanced"
+	// StageLenient accepts JSON5 style comments, single quotes, unquoted keys and trailing commas.
+	StageLenient = "lenient"
+)
+
+// Repairs recorded by the extraction.
+const (
+	RepairFences         = "fences"
+	RepairProse          = "prose"
+	RepairComments       = "comments"
+	RepairSingleQuotes   = "single_quotes"
+	RepairUnquotedKeys   = "unquoted_keys"
+	RepairTrailingCommas = "trailing_commas"
+)
+
+const extractAttribute = "extract"
+
+// ExtractConfig holds the extraction stages of a schema. The zero value is strict.
+type ExtractConfig struct {
+	Fences   bool
+	Balanced bool
+	Lenient  bool
+}
+
+// Extraction is the JSON extracted from a model response together with what was repaired.
+type Extraction struct {
+	Data    []byte
+	Repairs []string
+}
+
+// extractConfig reads the extract attribute of a schema definition.
+func extractConfig(definition cue.Value) (ExtractConfig, error) {
+	var cfg ExtractConfig
+
+	attr := definition.Attribute(extractAttribute)
+	if attr.Err() != nil {
+		return cfg, nil
+	}
+
+	for i := 0; i < attr.NumArgs(); i++ {
+		stage, _ := attr.Arg(i)
+		switch strings.TrimSpace(stage) {
+		case StageFences:
+			cfg.Fences = true
+		case StageBalanced:
+			cfg.Balanced = true
+		case StageLenient:
+			cfg.Lenient = true
+		default:
+			return cfg, fmt.Errorf("unknown extraction stage: %s", stage)
+		}
+	}
+
+	return cfg, nil
+}
+
+var fencePattern = regexp.MustCompile("(?s)```[A-Za-z0-9_-]*[ \t]*\r?\n(.*?)```")
+
+// Extract returns the JSON of a response with the enabled stages applied in order. Valid JSON is
+// returned unchanged. The result is not guaranteed to be valid, validation reports what is left.
+func Extract(data []byte, cfg ExtractConfig) Extraction {
+	text := bytes.TrimSpace(data)
+	if json.Valid(text) {
+		return Extraction{Data: text}
+	}
+
+	var repairs []string
+
+	if cfg.Fences {
+		if match := fencePattern.FindSubmatch(text); match != nil {
+			text = bytes.TrimSpace(match[1])
+			repairs = append(repairs, RepairFences)
+		}
+	}
+
+	if cfg.Balanced {
+		if value, ok := firstBalanced(text, cfg.Lenient); ok && len(value) != len(text) {
+			text = value
+			repairs = append(repairs, RepairProse)
+		}
+	}
+
+	// Only objects and arrays are rewritten, prose would get its words quoted as keys.
+	if cfg.Lenient && !json.Valid(text) && len(text) > 0 && (text[0] == '{' || text[0] == '[') {
+		var lenientRepairs []string
+		text, lenientRepairs = lenient(text)
+		repairs = append(repairs, lenientRepairs...)
+	}
+
+	return Extraction{Data: text, Repairs: repairs}
+}
+
+// firstBalanced returns the first JSON object or array of the text whose brackets are balanced.
+// Single quoted strings are skipped if lenient is set.
+func firstBalanced(text []byte, lenient bool) ([]byte, bool) {
+	start := bytes.IndexAny(text, "{[")
+	if start < 0 {
+		return nil, false
+	}
+
+	depth := 0
+	var quote byte
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || (lenient && c == '\''):
+			quote = c
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return text[start : i+1], true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// lenient rewrites JSON5 style syntax as JSON: comments are removed, single quoted strings are double
+// quoted, keys are quoted and trailing commas are dropped.
+func lenient(text []byte) ([]byte, []string) {
+	var repairs []string
+	repaired := func(repair string) {
+		if !slices.Contains(repairs, repair) {
+			repairs = append(repairs, repair)
+		}
+	}
+
+	var out bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '"':
+			end := stringEnd(text, i, '"')
+			out.Write(text[i:end])
+			i = end - 1
+		case c == '\'':
+			end := stringEnd(text, i, '\'')
+			content := text[i+1 : end]
+			if end-1 > i && text[end-1] == '\'' {
+				content = text[i+1 : end-1]
+			}
+			out.WriteString(requote(content))
+			i = end - 1
+			repaired(RepairSingleQuotes)
+		case c == '/' && i+1 < len(text) && text[i+1] == '/':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+			i--
+			repaired(RepairComments)
+		case c == '/' && i+1 < len(text) && text[i+1] == '*':
+			end := bytes.Index(text[i+2:], []byte("*/"))
+			if end < 0 {
+				i = len(text)
+			} else {
+				i += end + 3
+			}
+			repaired(RepairComments)
+		case c == ',' && closesNext(text, i+1):
+			repaired(RepairTrailingCommas)
+		case isIdentStart(c):
+			end := i
+			for end < len(text) && isIdentPart(text[end]) {
+				end++
+			}
+			ident := text[i:end]
+			if nextNonSpace(text, end) == ':' {
+				out.WriteString(`"` + string(ident) + `"`)
+				repaired(RepairUnquotedKeys)
+			} else {
+				out.Write(ident)
+			}
+			i = end - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	return out.Bytes(), repairs
+}
+
+// stringEnd returns the index after the closing quote of the string starting at start.
+func stringEnd(text []byte, start int, quote byte) int {
+	for i := start + 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+
+	return len(text)
+}
+
+// requote returns the content of a single quoted string as a double quoted JSON string.
+func requote(content []byte) string {
+	s := strings.ReplaceAll(string(content), `\'`, `'`)
+	s = strings.ReplaceAll(s, `\"`, `"`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// closesNext reports whether the next character after whitespace and comments closes an object or array.
+func closesNext(text []byte, i int) bool {
+	c := nextNonSpace(text, i)
+	return c == '}' || c == ']'
+}
+
+// nextNonSpace returns the next character from i that is neither whitespace nor part of a comment.
+func nextNonSpace(text []byte, i int) byte {
+	for i < len(text) {
+		switch {
+		case text[i] == ' ' || text[i] == '\t' || text[i] == '\n' || text[i] == '\r':
+			i++
+		case bytes.HasPrefix(text[i:], []byte("//")):
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case bytes.HasPrefix(text[i:], []byte("/*")):
+			end := bytes.Index(text[i+2:], []byte("*/"))
+			if end < 0 {
+				return 0
+			}
+			i += end + 4
+		default:
+			return text[i]
+		}
+	}
+
+	return 0
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,112 @@
+package validation
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtract(t *testing.T) {
+	all := ExtractConfig{Fences: true, Balanced: true, Lenient: true}
+
+	testCases := []struct {
+		name     string
+		data     string
+		cfg      ExtractConfig
+		expected string
+		repairs  []string
+	}{
+		{
+			name:     "valid JSON is unchanged",
+			data:     ` {"name": "Ron"} `,
+			cfg:      all,
+			expected: `{"name": "Ron"}`,
+		},
+		{
+			name:     "markdown fence",
+			data:     "```json\n{\"name\": \"Ron\"}\n```",
+			cfg:      all,
+			expected: `{"name": "Ron"}`,
+			repairs:  []string{RepairFences},
+		},
+		{
+			name:     "prose around the object",
+			data:     `Sure! Here's the person: {"name": "Ron {the} 2nd", "age": 56} Hope that helps.`,
+			cfg:      all,
+			expected: `{"name": "Ron {the} 2nd", "age": 56}`,
+			repairs:  []string{RepairProse},
+		},
+		{
+			name:     "fence with prose and JSON5",
+			data:     "The answer:\n```\nPerson:\n{name: 'Ron', // first name\n'age': 56, tags: ['a \"b\"',],}\n```",
+			cfg:      all,
+			expected: "{\"name\": \"Ron\", \n\"age\": 56, \"tags\": [\"a \\\"b\\\"\"]}",
+			repairs:  []string{RepairFences, RepairProse, RepairUnquotedKeys, RepairSingleQuotes, RepairComments, RepairTrailingCommas},
+		},
+		{
+			name:     "block comment and apostrophe in string",
+			data:     `{"name": "Ron's" /* nickname */, "age": 56}`,
+			cfg:      all,
+			expected: `{"name": "Ron's" , "age": 56}`,
+			repairs:  []string{RepairComments},
+		},
+		{
+			name:     "stages are disabled",
+			data:     "```json\n{\"name\": \"Ron\"}\n```",
+			cfg:      ExtractConfig{Lenient: true},
+			expected: "```json\n{\"name\": \"Ron\"}\n```",
+		},
+		{
+			name:     "unbalanced object is left for validation",
+			data:     `Here: {"name": "Ron"`,
+			cfg:      all,
+			expected: `Here: {"name": "Ron"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			extraction := Extract([]byte(tc.data), tc.cfg)
+			assert.Equal(t, tc.expected, string(extraction.Data))
+			assert.Equal(t, tc.repairs, extraction.Repairs)
+		})
+	}
+}
+
+func TestExtractConfig(t *testing.T) {
+	cueCtx := cuecontext.New()
+
+	value := cueCtx.CompileString("#a: {name: string} @extract(fences, lenient)\n#b: {name: string}\n#c: {name: string} @extract(loose)")
+	require.NoError(t, value.Err())
+
+	cfg, err := extractConfig(value.LookupPath(cue.ParsePath("#a")))
+	require.NoError(t, err)
+	assert.Equal(t, ExtractConfig{Fences: true, Lenient: true}, cfg)
+
+	cfg, err = extractConfig(value.LookupPath(cue.ParsePath("#b")))
+	require.NoError(t, err)
+	assert.Equal(t, ExtractConfig{}, cfg)
+
+	_, err = extractConfig(value.LookupPath(cue.ParsePath("#c")))
+	assert.EqualError(t, err, "unknown extraction stage: loose")
+}
+
+func TestResponseValidatorExtract(t *testing.T) {
+	validator, err := NewResponseSchemaValidator([]string{"schemas/answerResponse.cue", "schemas/personResponse.cue"})
+	require.NoError(t, err)
+
+	response := []byte("```json\n{answer: 'Ron is 56',}\n```")
+
+	extraction := validator.Extract("answerResponse", response)
+	assert.Equal(t, `{"answer": "Ron is 56"}`, string(extraction.Data))
+	assert.NoError(t, validator.Validate("answerResponse", extraction.Data))
+
+	// The person schema is strict.
+	response = []byte("```json\n{name: 'Ron', age: 56,}\n```")
+	extraction = validator.Extract("personResponse", response)
+	assert.Equal(t, response, extraction.Data)
+	assert.Empty(t, extraction.Repairs)
+}
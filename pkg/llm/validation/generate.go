@@ -0,0 +1,245 @@
+package validation
+
+//go:generate go run ../../../cmd/schemagen -schemas schemas -out schematypes/schematypes_gen.go
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+)
+
+// GenerateGoTypes emits Go structs for the definitions of all CUE schema files in fsys. Fields get
+// JSON tags, optional fields are pointers and the CUE comments become doc comments. Definitions
+// referencing other definitions of the same file use their generated type, inline structs get a
+// type named after the enclosing type and the field.
+func GenerateGoTypes(fsys fs.FS, pkgName string) ([]byte, error) {
+	files, err := fs.Glob(fsys, "*.cue")
+	if err != nil {
+		return nil, fmt.Errorf("listing schema files: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no schema files found")
+	}
+	slices.Sort(files)
+
+	g := &generator{cueCtx: cuecontext.New()}
+	fmt.Fprintf(&g.buf, "// Code generated by schemagen from the CUE response schemas. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&g.buf, "package %s\n", pkgName)
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("reading schema file: %w", err)
+		}
+
+		if err := g.generateFile(path.Base(file), data); err != nil {
+			return nil, fmt.Errorf("generating types for %s: %w", file, err)
+		}
+	}
+
+	source, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+
+	return source, nil
+}
+
+type generator struct {
+	cueCtx *cue.Context
+	buf    bytes.Buffer
+	// definitions maps the definitions of the current file to their Go type names.
+	definitions map[string]string
+}
+
+func (g *generator) generateFile(file string, data []byte) error {
+	value, err := processSchema(g.cueCtx, data)
+	if err != nil {
+		return err
+	}
+
+	iter, err := value.Fields(cue.Definitions(true))
+	if err != nil {
+		return err
+	}
+
+	type definition struct {
+		name  string
+		value cue.Value
+	}
+	var definitions []definition
+	g.definitions = make(map[string]string)
+	for iter.Next() {
+		if !iter.Selector().IsDefinition() {
+			continue
+		}
+		name := strings.TrimPrefix(iter.Selector().String(), "#")
+		definitions = append(definitions, definition{name: name, value: iter.Value()})
+		g.definitions[iter.Selector().String()] = goName(name)
+	}
+
+	for _, d := range definitions {
+		if err := g.generateStruct(goName(d.name), "#"+d.name+" in "+file, d.value); err != nil {
+			return fmt.Errorf("#%s: %w", d.name, err)
+		}
+	}
+
+	return nil
+}
+
+// generateStruct writes the struct for the value and afterwards the structs of its inline fields.
+func (g *generator) generateStruct(name, source string, value cue.Value) error {
+	iter, err := value.Fields(cue.Optional(true))
+	if err != nil {
+		return err
+	}
+
+	var fields []string
+	var nested []func() error
+	for iter.Next() {
+		field := iter.Selector().Unquoted()
+		fieldName := goName(field)
+		fieldValue := iter.Value()
+
+		typ, err := g.goType(fieldValue, name+fieldName, &nested)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field, err)
+		}
+
+		tag := field
+		if iter.IsOptional() {
+			if !strings.HasPrefix(typ, "[]") && !strings.HasPrefix(typ, "map[") && typ != "any" {
+				typ = "*" + typ
+			}
+			tag += ",omitempty"
+		}
+
+		var b bytes.Buffer
+		writeDoc(&b, "\t", docText(fieldValue))
+		fmt.Fprintf(&b, "\t%s %s `json:%q`\n", fieldName, typ, tag)
+		fields = append(fields, b.String())
+	}
+
+	fmt.Fprintf(&g.buf, "\n// %s is generated from %s.\n", name, source)
+	if doc := docText(value); doc != "" {
+		g.buf.WriteString("//\n")
+		writeDoc(&g.buf, "", doc)
+	}
+	fmt.Fprintf(&g.buf, "type %s struct {\n%s}\n", name, strings.Join(fields, "\n"))
+
+	for _, generate := range nested {
+		if err := generate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// goType returns the Go type of the value. Inline structs are added to nested and generated later.
+func (g *generator) goType(value cue.Value, inlineName string, nested *[]func() error) (string, error) {
+	if _, p := value.ReferencePath(); len(p.Selectors()) > 0 {
+		if name, ok := g.definitions[p.Selectors()[len(p.Selectors())-1].String()]; ok {
+			return name, nil
+		}
+	}
+
+	kind := value.IncompleteKind()
+	nullable := kind&cue.NullKind != 0 && kind != cue.NullKind
+	kind &^= cue.NullKind
+
+	var typ string
+	switch kind {
+	case cue.StringKind:
+		typ = "string"
+	case cue.IntKind:
+		typ = "int"
+	case cue.FloatKind, cue.NumberKind:
+		typ = "float64"
+	case cue.BoolKind:
+		typ = "bool"
+	case cue.ListKind:
+		elem, err := g.goType(value.LookupPath(cue.MakePath(cue.AnyIndex)), inlineName+"Item", nested)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + elem, nil
+	case cue.StructKind:
+		if elem := value.LookupPath(cue.MakePath(cue.AnyString)); elem.Exists() {
+			typ, err := g.goType(elem, inlineName+"Value", nested)
+			if err != nil {
+				return "", err
+			}
+			return "map[string]" + typ, nil
+		}
+		*nested = append(*nested, func() error {
+			return g.generateStruct(inlineName, "an inline struct", value)
+		})
+		typ = inlineName
+	case cue.TopKind:
+		return "any", nil
+	default:
+		return "", fmt.Errorf("unsupported kind %s", kind)
+	}
+
+	if nullable {
+		typ = "*" + typ
+	}
+
+	return typ, nil
+}
+
+// docText returns the comments attached to the value.
+func docText(value cue.Value) string {
+	var lines []string
+	for _, doc := range value.Doc() {
+		lines = append(lines, strings.TrimSpace(doc.Text()))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func writeDoc(b *bytes.Buffer, indent, doc string) {
+	if doc == "" {
+		return
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		fmt.Fprintf(b, "%s// %s\n", indent, line)
+	}
+}
+
+// initialisms are written in upper case like in the Go standard library.
+var initialisms = map[string]bool{"id": true, "url": true, "uri": true, "api": true, "json": true, "http": true, "ip": true}
+
+// goName turns a CUE label like personResponse or first_name into an exported Go identifier.
+func goName(label string) string {
+	words := strings.FieldsFunc(label, func(r rune) bool {
+		return r == '_' || r == '-' || r == ' ' || r == '.'
+	})
+
+	var b strings.Builder
+	for _, word := range words {
+		if initialisms[strings.ToLower(word)] {
+			b.WriteString(strings.ToUpper(word))
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+
+	return name
+}
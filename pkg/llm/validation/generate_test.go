@@ -0,0 +1,106 @@
+package validation
+
+import (
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation/schematypes"
+)
+
+func TestGenerateGoTypes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"order.cue": {Data: []byte(`package order
+
+#address: {
+	street: string
+}
+
+// An order of a customer.
+#order: {
+	// Identifies the order.
+	order_id: string
+	total?:   number
+	paid:     bool
+	items: [...{
+		sku:      string
+		quantity: int & >0
+	}]
+	shipping?: #address
+	notes:     string | null
+	labels: [string]: string
+}
+`)},
+	}
+
+	source, err := GenerateGoTypes(fsys, "orders")
+	require.NoError(t, err)
+
+	expected := `// Code generated by schemagen from the CUE response schemas. DO NOT EDIT.
+
+package orders
+
+// Address is generated from #address in order.cue.
+type Address struct {
+	Street string ` + "`json:\"street\"`" + `
+}
+
+// Order is generated from #order in order.cue.
+//
+// An order of a customer.
+type Order struct {
+	// Identifies the order.
+	OrderID string ` + "`json:\"order_id\"`" + `
+
+	Total *float64 ` + "`json:\"total,omitempty\"`" + `
+
+	Paid bool ` + "`json:\"paid\"`" + `
+
+	Items []OrderItemsItem ` + "`json:\"items\"`" + `
+
+	Shipping *Address ` + "`json:\"shipping,omitempty\"`" + `
+
+	Notes *string ` + "`json:\"notes\"`" + `
+
+	Labels map[string]string ` + "`json:\"labels\"`" + `
+}
+
+// OrderItemsItem is generated from an inline struct.
+type OrderItemsItem struct {
+	Sku string ` + "`json:\"sku\"`" + `
+
+	Quantity int ` + "`json:\"quantity\"`" + `
+}
+`
+	assert.Equal(t, expected, string(source))
+}
+
+func TestGenerateGoTypesNoSchemas(t *testing.T) {
+	_, err := GenerateGoTypes(fstest.MapFS{}, "empty")
+	assert.Error(t, err)
+}
+
+// TestGeneratedTypesUpToDate fails if the schemas changed without running go generate.
+func TestGeneratedTypesUpToDate(t *testing.T) {
+	schemas, err := fs.Sub(schemaFS, "schemas")
+	require.NoError(t, err)
+
+	source, err := GenerateGoTypes(schemas, "schematypes")
+	require.NoError(t, err)
+
+	committed, err := os.ReadFile("schematypes/schematypes_gen.go")
+	require.NoError(t, err)
+	assert.Equal(t, string(committed), string(source), "run go generate ./pkg/llm/validation/")
+}
+
+func TestGeneratedTypesMatchSchemas(t *testing.T) {
+	validator, err := NewResponseSchemaValidator([]string{"schemas/personResponse.cue", "schemas/animalResponse.cue"})
+	require.NoError(t, err)
+
+	assert.NoError(t, validator.CheckType("personResponse", reflect.TypeFor[schematypes.PersonResponse]()))
+	assert.NoError(t, validator.CheckType("animalResponse", reflect.TypeFor[schematypes.AnimalResponse]()))
+}
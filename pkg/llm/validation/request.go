@@ -0,0 +1,156 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+var requestViolations = metrics.NewCounter("request_violations_total")
+
+// DefaultMaxRequestBytes is the size limit of request bodies if none is configured.
+const DefaultMaxRequestBytes = 1 << 20
+
+const constraintMaxBytes = "<=%d bytes"
+
+// RequestConfig configures the validation of API requests.
+type RequestConfig struct {
+	// MaxBytes is the size limit of request bodies, DefaultMaxRequestBytes if zero.
+	MaxBytes int64 `yaml:"maxBytes"`
+	// Variables maps a task to the CUE schema file of its template variables, e.g. chat: schemas/chatVariables.cue.
+	// Tasks without a schema are not validated.
+	Variables map[string]string `yaml:"variables"`
+}
+
+// RequestSchemaValidator validates API payloads and the template variables of tasks against CUE
+// definitions. Unlike responses, optional fields of a request stay optional, but fields that are not
+// declared by the definition are rejected at every level.
+type RequestSchemaValidator struct {
+	schemas     map[string]*openapi3.Schema
+	definitions map[string]definition
+	maxBytes    int64
+	variables   map[string]string // schema of the template variables by task
+}
+
+// NewRequestSchemaValidator implements the Validation interface and loads the schema files of the
+// requests and of the configured template variables.
+func NewRequestSchemaValidator(schemaFiles []string, cfg RequestConfig) (*RequestSchemaValidator, error) {
+	if cfg.MaxBytes < 0 {
+		return nil, fmt.Errorf("maxBytes must not be negative: %d", cfg.MaxBytes)
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = DefaultMaxRequestBytes
+	}
+
+	variables := make(map[string]string, len(cfg.Variables))
+	files := append([]string{}, schemaFiles...)
+	for task, file := range cfg.Variables {
+		data, err := schemaFS.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read variables schema of task %s: %w", task, err)
+		}
+		name, err := getPackageName(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get package name of task %s: %w", task, err)
+		}
+		variables[task] = name
+		files = append(files, file)
+	}
+	slices.Sort(files)
+	files = slices.Compact(files)
+
+	log.Debugf("Loading request schemas: %s", files)
+
+	schemas, definitions, err := loadSchemas(files, closeSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading schemas: %w", err)
+	}
+
+	return &RequestSchemaValidator{
+		schemas:     schemas,
+		definitions: definitions,
+		maxBytes:    cfg.MaxBytes,
+		variables:   variables,
+	}, nil
+}
+
+// MaxBytes returns the size limit of request bodies.
+func (v *RequestSchemaValidator) MaxBytes() int64 {
+	return v.maxBytes
+}
+
+// Validate validates a request body against the schema. Bodies over the size limit, that are not
+// valid JSON or violate the schema are reported as a *ValidationError.
+func (v *RequestSchemaValidator) Validate(schema string, data []byte) error {
+	if int64(len(data)) > v.maxBytes {
+		return v.count(TooLargeError(schema, v.maxBytes))
+	}
+
+	return v.count(validate(v.schemas, v.definitions, schema, data))
+}
+
+// ValidateVariables validates the template variables of a task against its schema. Tasks without a
+// schema accept any variables.
+func (v *RequestSchemaValidator) ValidateVariables(task string, variables map[string]any) error {
+	schema, ok := v.variables[task]
+	if !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(variables)
+	if err != nil {
+		return fmt.Errorf("encoding variables of task %s: %w", task, err)
+	}
+
+	return v.count(validate(v.schemas, v.definitions, schema, data))
+}
+
+// count counts the violations of a validation error by schema and constraint.
+func (v *RequestSchemaValidator) count(err error) error {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		for _, violation := range validationErr.Violations {
+			requestViolations.Inc(validationErr.Schema, violation.Constraint)
+		}
+	}
+
+	return err
+}
+
+// TooLargeError reports a request body over the size limit as a violation of the whole document.
+func TooLargeError(schema string, maxBytes int64) *ValidationError {
+	return &ValidationError{
+		Schema: schema,
+		Violations: []Violation{{
+			Constraint: fmt.Sprintf(constraintMaxBytes, maxBytes),
+			Message:    fmt.Sprintf("request body is larger than %d bytes", maxBytes),
+		}},
+	}
+}
+
+// closeSchema disallows additional properties of the schema and of all nested objects. Required
+// properties are kept as declared by the CUE definition.
+func closeSchema(schema *openapi3.Schema) {
+	if schema == nil {
+		return
+	}
+
+	if schema.Type.Is(openapi3.TypeObject) || len(schema.Properties) > 0 {
+		schema.AdditionalProperties = openapi3.AdditionalProperties{
+			Has: openapi3.BoolPtr(false),
+		}
+	}
+	for _, property := range schema.Properties {
+		if property != nil {
+			closeSchema(property.Value)
+		}
+	}
+	if schema.Items != nil {
+		closeSchema(schema.Items.Value)
+	}
+}
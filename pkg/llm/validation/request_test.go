@@ -0,0 +1,97 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequestValidator(t *testing.T, cfg RequestConfig) *RequestSchemaValidator {
+	t.Helper()
+	validator, err := NewRequestSchemaValidator([]string{"schemas/queryRequest.cue"}, cfg)
+	require.NoError(t, err)
+	return validator
+}
+
+func TestRequestValidatorValidate(t *testing.T) {
+	validator := newRequestValidator(t, RequestConfig{})
+	assert.Equal(t, int64(DefaultMaxRequestBytes), validator.MaxBytes())
+
+	// Optional fields stay optional.
+	assert.NoError(t, validator.Validate("queryRequest", []byte(`{"prompt": "Who is Ron?"}`)))
+	assert.NoError(t, validator.Validate("queryRequest", []byte(`{"prompt": "Who is Ron?", "model": "gpt", "noCache": true}`)))
+
+	err := validator.Validate("queryRequest", []byte(`{"prompt": "", "nocache": true, "model": 1}`))
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "queryRequest", validationErr.Schema)
+	assert.Equal(t, []Violation{
+		{
+			Pointer:    "/model",
+			Value:      float64(1),
+			Constraint: "string",
+			Message:    "value must be a string",
+			Source:     "schemas/queryRequest.cue:8:2",
+		},
+		{
+			Pointer:    "/nocache",
+			Value:      true,
+			Constraint: "not allowed",
+			Message:    `property "nocache" is unsupported`,
+			Source:     "schemas/queryRequest.cue:6:1",
+		},
+		{
+			Pointer:    "/prompt",
+			Value:      "",
+			Constraint: "strings.MinRunes(1)",
+			Message:    "minimum string length is 1",
+			Source:     "schemas/queryRequest.cue:10:2",
+		},
+	}, validationErr.Violations)
+
+	err = validator.Validate("queryRequest", []byte(`{"model": "gpt"}`))
+	require.ErrorAs(t, err, &validationErr)
+	require.Len(t, validationErr.Violations, 1)
+	assert.Equal(t, "/prompt", validationErr.Violations[0].Pointer)
+	assert.Equal(t, "required", validationErr.Violations[0].Constraint)
+
+	assert.EqualError(t, validator.Validate("unknown", []byte(`{}`)), "validation failed schema not found: unknown")
+}
+
+func TestRequestValidatorMaxBytes(t *testing.T) {
+	validator := newRequestValidator(t, RequestConfig{MaxBytes: 32})
+	assert.Equal(t, int64(32), validator.MaxBytes())
+
+	err := validator.Validate("queryRequest", []byte(`{"prompt": "`+strings.Repeat("a", 32)+`"}`))
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []Violation{{Constraint: "<=32 bytes", Message: "request body is larger than 32 bytes"}}, validationErr.Violations)
+
+	_, err = NewRequestSchemaValidator(nil, RequestConfig{MaxBytes: -1})
+	assert.EqualError(t, err, "maxBytes must not be negative: -1")
+}
+
+func TestRequestValidatorValidateVariables(t *testing.T) {
+	validator := newRequestValidator(t, RequestConfig{
+		Variables: map[string]string{"chat": "schemas/chatVariables.cue"},
+	})
+
+	assert.NoError(t, validator.ValidateVariables("chat", map[string]any{"input": "Who is Ron?"}))
+	// Tasks without a schema accept any variables.
+	assert.NoError(t, validator.ValidateVariables("summary", map[string]any{"text": 1}))
+
+	err := validator.ValidateVariables("chat", map[string]any{"input": strings.Repeat("a", 8001), "user": "ron"})
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "chatVariables", validationErr.Schema)
+	require.Len(t, validationErr.Violations, 2)
+	assert.Equal(t, "/input", validationErr.Violations[0].Pointer)
+	assert.Equal(t, "strings.MaxRunes(8000)", validationErr.Violations[0].Constraint)
+	assert.Equal(t, "/user", validationErr.Violations[1].Pointer)
+	assert.Equal(t, "not allowed", validationErr.Violations[1].Constraint)
+
+	_, err = NewRequestSchemaValidator(nil, RequestConfig{Variables: map[string]string{"chat": "schemas/missing.cue"}})
+	assert.ErrorContains(t, err, "failed to read variables schema of task chat")
+}
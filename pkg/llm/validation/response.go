@@ -12,10 +12,20 @@ import (
 	"cuelang.org/go/encoding/openapi"
 	"github.com/getkin/kin-openapi/openapi3"
 	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
 )
 
+var jsonRepairs = metrics.NewCounter("json_repairs_total")
+
 type ResponseSchemaValidator struct {
-	schemas map[string]*openapi3.Schema
+	schemas     map[string]*openapi3.Schema
+	definitions map[string]definition
+}
+
+// definition holds what is read from the CUE definition of a schema besides its OpenAPI schema.
+type definition struct {
+	extract ExtractConfig     // extraction stages, strict if empty
+	sources map[string]string // positions of the fields by pointer pattern, see schemaSources
 }
 
 //go:embed schemas/*.cue
@@ -28,19 +38,49 @@ func NewResponseSchemaValidator(schemaFiles []string) (*ResponseSchemaValidator,
 
 	log.Debugf("Loading schemas: %s", schemaFiles)
 
-	schemas, err := loadSchemas(schemaFiles)
+	schemas, definitions, err := loadSchemas(schemaFiles, setStrictSchemaValidationRules)
 	if err != nil {
 		return nil, fmt.Errorf("failed loading schemas: %w", err)
 	}
 
 	return &ResponseSchemaValidator{
-		schemas: schemas,
+		schemas:     schemas,
+		definitions: definitions,
 	}, nil
 }
 
+// Extract applies the extraction stages of the schema to a response and counts the repairs. Data of
+// strict and unknown schemas is returned unchanged.
+func (v *ResponseSchemaValidator) Extract(schema string, data []byte) Extraction {
+	cfg := v.definitions[schema].extract
+	if cfg == (ExtractConfig{}) {
+		return Extraction{Data: data}
+	}
+
+	extraction := Extract(data, cfg)
+	for _, repair := range extraction.Repairs {
+		jsonRepairs.Inc(schema, repair)
+	}
+	if len(extraction.Repairs) > 0 {
+		log.WithFields(log.Fields{
+			"schema":  schema,
+			"repairs": extraction.Repairs,
+		}).Debug("Repaired response before validation")
+	}
+
+	return extraction
+}
+
+// Validate validates the data against the schema. Data that is not valid JSON or violates the schema
+// is reported as a *ValidationError.
 func (v *ResponseSchemaValidator) Validate(schema string, data []byte) error {
+	return validate(v.schemas, v.definitions, schema, data)
+}
+
+// validate validates the data against one of the schemas and collects all violations into a *ValidationError.
+func validate(schemas map[string]*openapi3.Schema, definitions map[string]definition, schema string, data []byte) error {
 	// Get the schema from the map
-	opeapiSchema, exists := v.schemas[schema]
+	opeapiSchema, exists := schemas[schema]
 	if !exists {
 		return fmt.Errorf("validation failed schema not found: %s", schema)
 	}
@@ -48,57 +88,83 @@ func (v *ResponseSchemaValidator) Validate(schema string, data []byte) error {
 	var jsonData interface{}
 	// Parse JSON
 	if err := json.Unmarshal(data, &jsonData); err != nil {
-		return fmt.Errorf("invalid JSON: %w", err)
+		return invalidJSONError(schema, err)
 	}
 
 	log.Debugf("Validating against schema: %s\n", schema)
 
-	// Validate against schema
-	err := opeapiSchema.VisitJSON(jsonData)
+	// Validate against schema, all violations are collected into a *ValidationError
+	err := opeapiSchema.VisitJSON(jsonData, openapi3.MultiErrors())
 	if err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+		return newValidationError(schema, err, definitions[schema].sources)
 	}
 
 	return nil
 }
 
-func loadSchemas(schemaFiles []string) (map[string]*openapi3.Schema, error) {
+// loadSchemas generates the OpenAPI schema of each schema file and applies the validation rules to it.
+func loadSchemas(schemaFiles []string, rules func(*openapi3.Schema)) (map[string]*openapi3.Schema, map[string]definition, error) {
 	cueCtx := cuecontext.New()
 
 	schemas := make(map[string]*openapi3.Schema)
+	definitions := make(map[string]definition)
 
 	for _, schema := range schemaFiles {
 		schemaData, err := schemaFS.ReadFile(schema)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read schema file: %w", err)
+			return nil, nil, fmt.Errorf("failed to read schema file: %w", err)
 		}
 
 		name, err := getPackageName(schemaData)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get package name: %w", err)
+			return nil, nil, fmt.Errorf("failed to get package name: %w", err)
 		}
 
 		openAPISchema, err := generateOpenAPISchema(cueCtx, schemaData, name, version)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate openapi schema: %w", err)
+			return nil, nil, fmt.Errorf("failed to generate openapi schema: %w", err)
 		}
 
 		loader := openapi3.NewLoader()
 		doc, err := loader.LoadFromData(openAPISchema)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load openapi schema: %w", err)
+			return nil, nil, fmt.Errorf("failed to load openapi schema: %w", err)
 		}
 
 		if doc.Components.Schemas[name] == nil {
-			return nil, fmt.Errorf("schema not found: %s", name)
+			return nil, nil, fmt.Errorf("schema not found: %s", name)
 		}
 
-		setStrictSchemaValidationRules(doc.Components.Schemas[name].Value)
+		definitions[name], err = loadDefinition(cueCtx, schemaData, schema, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rules(doc.Components.Schemas[name].Value)
 		// Store schema
 		schemas[name] = doc.Components.Schemas[name].Value
 	}
 
-	return schemas, nil
+	return schemas, definitions, nil
+}
+
+// loadDefinition reads the extraction stages and the field positions of the schema's definition.
+func loadDefinition(cueCtx *cue.Context, cueData []byte, file, name string) (definition, error) {
+	cueValue := cueCtx.CompileBytes(cueData, cue.Filename(file))
+	if cueValue.Err() != nil {
+		return definition{}, fmt.Errorf("compiling CUE schema failed: %w", cueValue.Err())
+	}
+	value := cueValue.LookupPath(cue.ParsePath("#" + name))
+
+	extract, err := extractConfig(value)
+	if err != nil {
+		return definition{}, fmt.Errorf("invalid extraction of schema %s: %w", name, err)
+	}
+
+	return definition{
+		extract: extract,
+		sources: schemaSources(value),
+	}, nil
 }
 
 // getPackageName gets the package name from the cue schema file.
@@ -2,6 +2,8 @@ package animalResponse
 
 // A Animal Response
 #animalResponse: {
+	// Common name of the animal.
 	name?: string
+	// Age of the animal in years.
 	age?: int & <=50
 }
\ No newline at end of file
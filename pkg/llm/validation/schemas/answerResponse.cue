@@ -0,0 +1,7 @@
+package answerResponse
+
+// A free text answer
+#answerResponse: {
+	// Answer to the question of the user.
+	answer: string
+} @extract(fences, balanced, lenient)
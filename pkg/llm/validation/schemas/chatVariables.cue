@@ -0,0 +1,9 @@
+package chatVariables
+
+import "strings"
+
+// Template variables of the chat task
+#chatVariables: {
+	// User input of the chat.
+	input: string & strings.MaxRunes(8000)
+}
@@ -2,6 +2,8 @@ package personResponse
 
 // A Person Response
 #personResponse: {
+	// Full name of the person.
 	name?: string
+	// Age of the person in years.
 	age?: int & <=130
-}
\ No newline at end of file
+}
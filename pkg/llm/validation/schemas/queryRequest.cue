@@ -0,0 +1,17 @@
+package queryRequest
+
+import "strings"
+
+// A query request
+#queryRequest: {
+	// Logical name of the model.
+	model?: string & strings.MaxRunes(128)
+	// Prompt for the model, its length is limited by the variables schema of the task.
+	prompt: string & strings.MinRunes(1)
+	// Keeps the caller on the same prompt version, e.g. a user or session id.
+	selectionKey?: string & strings.MaxRunes(256)
+	// Always calls the model instead of serving a cached response.
+	noCache?: bool
+	// Task of the prompt template, the default task of the server if unset.
+	task?: string & strings.MaxRunes(128)
+}
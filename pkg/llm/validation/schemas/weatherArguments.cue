@@ -0,0 +1,9 @@
+package weatherArguments
+
+// Arguments of the get_weather tool
+#weatherArguments: {
+	// City to get the current weather for.
+	city: string
+	// Unit of the temperatures.
+	unit: "celsius" | "fahrenheit"
+}
@@ -0,0 +1,72 @@
+// Code generated by schemagen from the CUE response schemas. DO NOT EDIT.
+
+package schematypes
+
+// AnimalResponse is generated from #animalResponse in animalResponse.cue.
+//
+// A Animal Response
+type AnimalResponse struct {
+	// Common name of the animal.
+	Name *string `json:"name,omitempty"`
+
+	// Age of the animal in years.
+	Age *int `json:"age,omitempty"`
+}
+
+// AnswerResponse is generated from #answerResponse in answerResponse.cue.
+//
+// A free text answer
+type AnswerResponse struct {
+	// Answer to the question of the user.
+	Answer string `json:"answer"`
+}
+
+// ChatVariables is generated from #chatVariables in chatVariables.cue.
+//
+// Template variables of the chat task
+type ChatVariables struct {
+	// User input of the chat.
+	Input string `json:"input"`
+}
+
+// PersonResponse is generated from #personResponse in personResponse.cue.
+//
+// A Person Response
+type PersonResponse struct {
+	// Full name of the person.
+	Name *string `json:"name,omitempty"`
+
+	// Age of the person in years.
+	Age *int `json:"age,omitempty"`
+}
+
+// QueryRequest is generated from #queryRequest in queryRequest.cue.
+//
+// A query request
+type QueryRequest struct {
+	// Logical name of the model.
+	Model *string `json:"model,omitempty"`
+
+	// Prompt for the model, its length is limited by the variables schema of the task.
+	Prompt string `json:"prompt"`
+
+	// Keeps the caller on the same prompt version, e.g. a user or session id.
+	SelectionKey *string `json:"selectionKey,omitempty"`
+
+	// Always calls the model instead of serving a cached response.
+	NoCache *bool `json:"noCache,omitempty"`
+
+	// Task of the prompt template, the default task of the server if unset.
+	Task *string `json:"task,omitempty"`
+}
+
+// WeatherArguments is generated from #weatherArguments in weatherArguments.cue.
+//
+// Arguments of the get_weather tool
+type WeatherArguments struct {
+	// City to get the current weather for.
+	City string `json:"city"`
+
+	// Unit of the temperatures.
+	Unit string `json:"unit"`
+}
@@ -0,0 +1,205 @@
+package validation
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// TypeChecker is implemented by validators that can check Go types against their schemas.
+type TypeChecker interface {
+	CheckType(schema string, t reflect.Type) error
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	rawMessageType      = reflect.TypeFor[json.RawMessage]()
+)
+
+// CheckType reports whether every response valid against the schema decodes into the Go type
+// without losing data: each schema field needs a Go field of a matching type and each Go field
+// needs a schema field. All mismatches are returned at once, each with the path of the field.
+func (v *ResponseSchemaValidator) CheckType(schema string, t reflect.Type) error {
+	openapiSchema, exists := v.schemas[schema]
+	if !exists {
+		return fmt.Errorf("schema not found: %s", schema)
+	}
+
+	if errs := checkType(openapiSchema, t, schema); len(errs) > 0 {
+		return fmt.Errorf("type %s does not match schema %s: %w", t, schema, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// checkType compares the schema with the type and returns one error per mismatch.
+func checkType(schema *openapi3.Schema, t reflect.Type, path string) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Types that decode themselves or hold any value are not checked any further.
+	if t == rawMessageType || t.Kind() == reflect.Interface ||
+		reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+
+	schemaTypes := schema.Type.Slice()
+	if len(schemaTypes) == 0 {
+		return []error{fmt.Errorf("%s: schema allows any value, %s cannot hold it", path, t)}
+	}
+
+	var errs []error
+	for _, schemaType := range schemaTypes {
+		if !kindMatches(schemaType, t) {
+			errs = append(errs, fmt.Errorf("%s: schema type %s does not decode into %s", path, schemaType, t))
+			continue
+		}
+
+		switch schemaType {
+		case openapi3.TypeArray:
+			if schema.Items != nil && schema.Items.Value != nil {
+				errs = append(errs, checkType(schema.Items.Value, t.Elem(), path+"[]")...)
+			}
+		case openapi3.TypeObject:
+			errs = append(errs, checkObject(schema, t, path)...)
+		}
+	}
+
+	return errs
+}
+
+func kindMatches(schemaType string, t reflect.Type) bool {
+	switch schemaType {
+	case openapi3.TypeString:
+		return t.Kind() == reflect.String || reflect.PointerTo(t).Implements(textUnmarshalerType)
+	case openapi3.TypeInteger:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return true
+		}
+	case openapi3.TypeNumber:
+		return t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+	case openapi3.TypeBoolean:
+		return t.Kind() == reflect.Bool
+	case openapi3.TypeArray:
+		return t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+	case openapi3.TypeObject:
+		return t.Kind() == reflect.Struct || (t.Kind() == reflect.Map && t.Key().Kind() == reflect.String)
+	case openapi3.TypeNull:
+		return true
+	}
+
+	return false
+}
+
+// checkObject matches the schema properties with the fields of a struct like encoding/json does.
+func checkObject(schema *openapi3.Schema, t reflect.Type, path string) []error {
+	if t.Kind() == reflect.Map {
+		var errs []error
+		for name, property := range schema.Properties {
+			if property.Value != nil {
+				errs = append(errs, checkType(property.Value, t.Elem(), path+"."+name)...)
+			}
+		}
+		return errs
+	}
+
+	fields := jsonFields(t)
+
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var errs []error
+	matched := make(map[string]bool)
+	for _, name := range names {
+		field, ok := findField(fields, name)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s.%s: no matching field in %s", path, name, t))
+			continue
+		}
+		matched[field.name] = true
+
+		if property := schema.Properties[name].Value; property != nil {
+			errs = append(errs, checkType(property, field.typ, path+"."+name)...)
+		}
+	}
+
+	// The response schemas disallow additional properties, so extra fields would never be set.
+	for _, field := range fields {
+		if !matched[field.name] {
+			errs = append(errs, fmt.Errorf("%s.%s: field %s is not in the schema", path, field.name, field.goName))
+		}
+	}
+
+	return errs
+}
+
+type jsonField struct {
+	name   string
+	goName string
+	typ    reflect.Type
+}
+
+// jsonFields returns the fields encoding/json decodes into, including promoted fields of
+// embedded structs.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				fields = append(fields, jsonFields(embedded)...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, jsonField{name: name, goName: field.Name, typ: field.Type})
+	}
+
+	return fields
+}
+
+// findField finds the field for a JSON key, an exact match is preferred over a case-insensitive one.
+func findField(fields []jsonField, key string) (jsonField, bool) {
+	for _, field := range fields {
+		if field.name == key {
+			return field, true
+		}
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.name, key) {
+			return field, true
+		}
+	}
+
+	return jsonField{}, false
+}
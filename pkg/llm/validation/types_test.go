@@ -0,0 +1,88 @@
+package validation
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTypePersonResponse(t *testing.T) {
+	validator, err := NewResponseSchemaValidator([]string{"schemas/personResponse.cue"})
+	require.NoError(t, err)
+
+	type person struct {
+		Name string `json:"name"`
+		Age  *int   `json:"age,omitempty"`
+	}
+	assert.NoError(t, validator.CheckType("personResponse", reflect.TypeFor[person]()))
+
+	// Field names match case-insensitively like in encoding/json.
+	type untagged struct {
+		Name string
+		Age  uint8
+	}
+	assert.NoError(t, validator.CheckType("personResponse", reflect.TypeFor[untagged]()))
+
+	type mismatched struct {
+		Name  string `json:"name"`
+		Age   string `json:"age"`
+		Email string `json:"email"`
+	}
+	err = validator.CheckType("personResponse", reflect.TypeFor[mismatched]())
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "personResponse.age: schema type integer does not decode into string")
+	assert.ErrorContains(t, err, "personResponse.email: field Email is not in the schema")
+
+	type missing struct {
+		Name string `json:"name"`
+	}
+	err = validator.CheckType("personResponse", reflect.TypeFor[missing]())
+	assert.ErrorContains(t, err, "personResponse.age: no matching field")
+
+	assert.Error(t, validator.CheckType("unknownResponse", reflect.TypeFor[person]()))
+}
+
+func TestCheckTypeNested(t *testing.T) {
+	schema := &openapi3.Schema{
+		Type: &openapi3.Types{openapi3.TypeObject},
+		Properties: openapi3.Schemas{
+			"tags":  openapi3.NewArraySchema().WithItems(openapi3.NewStringSchema()).NewRef(),
+			"score": openapi3.NewFloat64Schema().NewRef(),
+			"meta": openapi3.NewObjectSchema().
+				WithProperty("created", openapi3.NewStringSchema()).
+				WithProperty("raw", openapi3.NewSchema()).NewRef(),
+		},
+	}
+
+	type meta struct {
+		Created time.Time       `json:"created"`
+		Raw     json.RawMessage `json:"raw"`
+	}
+	type base struct {
+		Score float64 `json:"score"`
+	}
+	type valid struct {
+		base
+		Tags     []string `json:"tags"`
+		Meta     meta     `json:"meta"`
+		internal string
+		Ignored  string `json:"-"`
+	}
+	assert.Empty(t, checkType(schema, reflect.TypeFor[valid](), "root"))
+
+	type invalid struct {
+		Score int               `json:"score"`
+		Tags  []int             `json:"tags"`
+		Meta  map[string]string `json:"meta"`
+	}
+	errs := checkType(schema, reflect.TypeFor[invalid](), "root")
+	require.Len(t, errs, 3)
+	assert.ErrorContains(t, errs[0], "root.meta.raw: schema allows any value, string cannot hold it")
+	assert.ErrorContains(t, errs[1], "root.score: schema type number does not decode into int")
+	assert.ErrorContains(t, errs[2], "root.tags[]: schema type string does not decode into int")
+}
@@ -5,3 +5,10 @@ type Validation interface {
 	// Validate validates the data against a given schema. Defining a specific schema allows to handle different task from a llm that produces different outputs.
 	Validate(schema string, data []byte) error
 }
+
+// Extractor is implemented by validators that extract the JSON of malformed responses before they
+// are validated.
+type Extractor interface {
+	// Extract applies the extraction stages enabled for the schema to the data.
+	Extract(schema string, data []byte) Extraction
+}
@@ -0,0 +1,174 @@
+package vectorindex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrLocked is returned by Lock if another process keeps the index locked.
+var ErrLocked = errors.New("index is locked by another process")
+
+// fileFormat is the on-disk format of an index.
+type fileFormat struct {
+	Version int    `json:"version"`
+	Items   []Item `json:"items"`
+}
+
+const (
+	fileVersion = 1
+
+	lockSuffix       = ".lock"
+	lockPollInterval = 50 * time.Millisecond
+	// lockWriteGrace is how long a lock file may be empty, its process may not have written its pid yet.
+	lockWriteGrace = 5 * time.Second
+)
+
+// Load reads an index written by Save. A missing file is an empty index.
+func Load(path string) (*Index, error) {
+	idx := NewIndex()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+
+	var file fileFormat
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing index: %w", err)
+	}
+	if file.Version != fileVersion {
+		return nil, fmt.Errorf("unsupported index version %d", file.Version)
+	}
+
+	for _, item := range file.Items {
+		if err := idx.Add(item); err != nil {
+			return nil, fmt.Errorf("loading item %s: %w", item.ID, err)
+		}
+	}
+
+	return idx, nil
+}
+
+// Save writes the index to the file. The file is replaced atomically, so a crash never leaves a
+// partially written index behind.
+func (idx *Index) Save(path string) error {
+	items := idx.Items(nil)
+
+	// A stable order keeps the file diffable.
+	slices.SortFunc(items, func(a, b Item) int {
+		if a.ID < b.ID {
+			return -1
+		}
+		return 1
+	})
+
+	data, err := json.Marshal(fileFormat{Version: fileVersion, Items: items})
+	if err != nil {
+		return fmt.Errorf("encoding index: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating index directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".index-*")
+	if err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+
+	return nil
+}
+
+// Lock takes the lock file next to the index at path, so that processes sharing the index file do
+// not overwrite each other's changes. It waits up to timeout for the current holder. The lock file
+// holds the pid of its process, a lock file left behind by a process that no longer runs is removed.
+// The returned function releases the lock.
+func Lock(path string, timeout time.Duration) (func(), error) {
+	lockPath := path + lockSuffix
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0o755); err != nil {
+		return nil, fmt.Errorf("creating index directory: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("locking index: %w", err)
+		}
+		if staleLock(lockPath) {
+			// The check and the removal are not atomic: a process that finds the same stale lock at
+			// the same moment may remove the lock another one took in between. That needs a crash
+			// and two processes racing for the lock within the same instant.
+			if err := os.Remove(lockPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("removing stale lock: %w", err)
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w, remove %s if no other process uses it", ErrLocked, lockPath)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// staleLock reports whether the lock file was left behind by a process that no longer runs.
+func staleLock(lockPath string) bool {
+	info, err := os.Stat(lockPath)
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		// The lock is taken before the pid is written, only a lock that stays empty is stale.
+		return time.Since(info.ModTime()) > lockWriteGrace
+	}
+
+	return !processRunning(pid)
+}
+
+// processRunning reports whether a process with the pid exists. Signal 0 only checks for the process.
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
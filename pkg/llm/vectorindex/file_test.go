@@ -0,0 +1,91 @@
+package vectorindex
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index", "docs.json")
+
+	idx := NewIndex()
+	require.NoError(t, idx.Add(Item{ID: "a", Vector: []float32{3, 4}, Metadata: map[string]string{"text": "alpha"}}))
+	require.NoError(t, idx.Add(Item{ID: "b", Vector: []float32{0, 1}}))
+	require.NoError(t, idx.Save(path))
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, loaded.Len())
+
+	item, ok := loaded.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, "alpha", item.Metadata["text"])
+	assert.InDeltaSlice(t, []float32{0.6, 0.8}, item.Vector, 1e-6)
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	idx, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, idx.Len())
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":2,"items":[]}`), 0o644))
+	_, err := Load(path)
+	assert.ErrorContains(t, err, "unsupported index version")
+
+	require.NoError(t, os.WriteFile(path, []byte(`not json`), 0o644))
+	_, err = Load(path)
+	assert.Error(t, err)
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index", "docs.json")
+
+	unlock, err := Lock(path, time.Second)
+	require.NoError(t, err)
+
+	_, err = Lock(path, 0)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	unlock()
+	unlock, err = Lock(path, 0)
+	require.NoError(t, err)
+	unlock()
+}
+
+func TestLockRemovesStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "docs.json")
+
+	// A process that exited leaves its pid behind.
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	require.NoError(t, os.WriteFile(path+lockSuffix, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0o644))
+
+	unlock, err := Lock(path, 0)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path + lockSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(data))
+	unlock()
+
+	// An empty lock file is only stale once its process had time to write its pid.
+	require.NoError(t, os.WriteFile(path+lockSuffix, nil, 0o644))
+	_, err = Lock(path, 0)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	old := time.Now().Add(-time.Minute)
+	require.NoError(t, os.Chtimes(path+lockSuffix, old, old))
+	unlock, err = Lock(path, 0)
+	require.NoError(t, err)
+	unlock()
+}
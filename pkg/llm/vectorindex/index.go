@@ -0,0 +1,187 @@
+package vectorindex
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sync"
+)
+
+// Item is a vector together with its id and metadata, e.g. the task it belongs to.
+type Item struct {
+	ID       string            `json:"id"`
+	Vector   []float32         `json:"vector"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Match is an item found by a search together with its cosine similarity to the query.
+type Match struct {
+	Item
+	Score float64
+}
+
+// Filter selects the items a search or delete applies to. A nil filter selects all items.
+type Filter func(Item) bool
+
+// MetadataEquals selects the items whose metadata has the given value for every key.
+func MetadataEquals(values map[string]string) Filter {
+	return func(item Item) bool {
+		for key, value := range values {
+			if item.Metadata[key] != value {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Index is an in-process vector index with exact cosine similarity search. Vectors are normalized
+// when they are added, so a search is a dot product with every stored vector. This is fast enough
+// for the tens of thousands of entries a single service holds.
+type Index struct {
+	mu    sync.RWMutex
+	items map[string]Item
+}
+
+// NewIndex creates an empty index.
+func NewIndex() *Index {
+	return &Index{
+		items: make(map[string]Item),
+	}
+}
+
+// Add adds the item or replaces the item with the same id.
+func (idx *Index) Add(item Item) error {
+	vector, err := normalize(item.Vector)
+	if err != nil {
+		return err
+	}
+	item.Vector = vector
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.items[item.ID] = item
+	return nil
+}
+
+// Get returns the item with the given id.
+func (idx *Index) Get(id string) (Item, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	item, ok := idx.items[id]
+	return item, ok
+}
+
+// Search returns up to k items most similar to the query, most similar first.
+func (idx *Index) Search(query []float32, k int, filter Filter) ([]Match, error) {
+	query, err := normalize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var matches []Match
+	for _, item := range idx.items {
+		if len(item.Vector) != len(query) {
+			continue
+		}
+		if filter != nil && !filter(item) {
+			continue
+		}
+		matches = append(matches, Match{Item: item, Score: dot(query, item.Vector)})
+	}
+
+	slices.SortFunc(matches, func(a, b Match) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		// Equal scores are ordered by id to keep results stable.
+		if a.ID < b.ID {
+			return -1
+		}
+		return 1
+	})
+
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+
+	return matches, nil
+}
+
+// Items returns the items selected by the filter in no particular order.
+func (idx *Index) Items(filter Filter) []Item {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var items []Item
+	for _, item := range idx.items {
+		if filter == nil || filter(item) {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Remove removes the item with the given id.
+func (idx *Index) Remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.items, id)
+}
+
+// Delete removes the items selected by the filter and returns their ids.
+func (idx *Index) Delete(filter Filter) []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var ids []string
+	for id, item := range idx.items {
+		if filter == nil || filter(item) {
+			delete(idx.items, id)
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// Len returns the number of items in the index.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.items)
+}
+
+func normalize(vector []float32) ([]float32, error) {
+	norm := math.Sqrt(dot(vector, vector))
+	if norm == 0 {
+		return nil, errors.New("vector must not be empty or zero")
+	}
+
+	normalized := make([]float32, len(vector))
+	for i, v := range vector {
+		normalized[i] = float32(float64(v) / norm)
+	}
+
+	return normalized, nil
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+
+	return sum
+}
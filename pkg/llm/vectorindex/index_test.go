@@ -0,0 +1,62 @@
+package vectorindex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndexSearch(t *testing.T) {
+	idx := NewIndex()
+	require.NoError(t, idx.Add(Item{ID: "x", Vector: []float32{1, 0}, Metadata: map[string]string{"task": "a"}}))
+	require.NoError(t, idx.Add(Item{ID: "xy", Vector: []float32{1, 1}, Metadata: map[string]string{"task": "a"}}))
+	require.NoError(t, idx.Add(Item{ID: "y", Vector: []float32{0, 3}, Metadata: map[string]string{"task": "b"}}))
+
+	matches, err := idx.Search([]float32{2, 0}, 2, nil)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	assert.Equal(t, "x", matches[0].ID)
+	assert.InDelta(t, 1.0, matches[0].Score, 1e-6)
+	assert.Equal(t, "xy", matches[1].ID)
+	assert.InDelta(t, 0.7071, matches[1].Score, 1e-4)
+
+	matches, err = idx.Search([]float32{2, 0}, 5, MetadataEquals(map[string]string{"task": "b"}))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "y", matches[0].ID)
+	assert.InDelta(t, 0.0, matches[0].Score, 1e-6)
+}
+
+func TestIndexRejectsZeroVectors(t *testing.T) {
+	idx := NewIndex()
+	assert.Error(t, idx.Add(Item{ID: "zero", Vector: []float32{0, 0}}))
+
+	_, err := idx.Search(nil, 1, nil)
+	assert.Error(t, err)
+}
+
+func TestIndexDelete(t *testing.T) {
+	idx := NewIndex()
+	require.NoError(t, idx.Add(Item{ID: "a", Vector: []float32{1}, Metadata: map[string]string{"task": "a"}}))
+	require.NoError(t, idx.Add(Item{ID: "b", Vector: []float32{1}, Metadata: map[string]string{"task": "b"}}))
+
+	deleted := idx.Delete(MetadataEquals(map[string]string{"task": "a"}))
+	assert.Equal(t, []string{"a"}, deleted)
+	assert.Equal(t, 1, idx.Len())
+
+	_, ok := idx.Get("a")
+	assert.False(t, ok)
+}
+
+func TestIndexItems(t *testing.T) {
+	idx := NewIndex()
+	require.NoError(t, idx.Add(Item{ID: "x", Vector: []float32{1, 0}, Metadata: map[string]string{"task": "a"}}))
+	require.NoError(t, idx.Add(Item{ID: "y", Vector: []float32{0, 1}, Metadata: map[string]string{"task": "b"}}))
+
+	assert.Len(t, idx.Items(nil), 2)
+
+	items := idx.Items(MetadataEquals(map[string]string{"task": "b"}))
+	require.Len(t, items, 1)
+	assert.Equal(t, "y", items[0].ID)
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+	"strings"
+)
+
+// Counter is a monotonically increasing metric with optional labels. It is published via expvar,
+// every label combination is a key of the metric's map.
+type Counter struct {
+	values *expvar.Map
+}
+
+// NewCounter registers a new counter. Like expvar, it panics if the name is already registered.
+func NewCounter(name string) *Counter {
+	return &Counter{
+		values: expvar.NewMap(name),
+	}
+}
+
+// Inc increments the counter for the given labels by one.
+func (c *Counter) Inc(labels ...string) {
+	c.values.Add(labelKey(labels), 1)
+}
+
+// Gauge is a metric that can go up and down, e.g. a queue depth or a state.
+type Gauge struct {
+	values *expvar.Map
+}
+
+// NewGauge registers a new gauge. Like expvar, it panics if the name is already registered.
+func NewGauge(name string) *Gauge {
+	return &Gauge{
+		values: expvar.NewMap(name),
+	}
+}
+
+// Add adds delta to the gauge for the given labels.
+func (g *Gauge) Add(delta int64, labels ...string) {
+	g.values.Add(labelKey(labels), delta)
+}
+
+// Set sets the gauge for the given labels.
+func (g *Gauge) Set(value int64, labels ...string) {
+	v := new(expvar.Int)
+	v.Set(value)
+	g.values.Set(labelKey(labels), v)
+}
+
+// Handler returns the http.Handler exposing all metrics as JSON.
+func Handler() http.Handler {
+	return expvar.Handler()
+}
+
+func labelKey(labels []string) string {
+	if len(labels) == 0 {
+		return "total"
+	}
+
+	return strings.Join(labels, ",")
+}
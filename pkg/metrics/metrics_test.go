@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterAndGauge(t *testing.T) {
+	counter := NewCounter("test_requests_total")
+	counter.Inc("llama", "chat")
+	counter.Inc("llama", "chat")
+	counter.Inc()
+
+	gauge := NewGauge("test_queue_depth")
+	gauge.Add(3, "llama")
+	gauge.Add(-1, "llama")
+	gauge.Set(7, "other")
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var vars map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &vars))
+
+	assert.JSONEq(t, `{"llama,chat": 2, "total": 1}`, string(vars["test_requests_total"]))
+	assert.JSONEq(t, `{"llama": 2, "other": 7}`, string(vars["test_queue_depth"]))
+}
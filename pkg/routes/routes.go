@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/yreinhar/llm-go-blueprint/pkg/handlers"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
 )
 
 // AddRoutes configures all HTTP routes for the app.
@@ -11,4 +12,10 @@ func AddRoutes(mux *http.ServeMux, h *handlers.Handler) {
 	mux.Handle("/", http.NotFoundHandler())
 	mux.HandleFunc("/hello", h.HandleHelloWorld)
 	mux.HandleFunc("/query", h.CallModelHandler)
+	mux.HandleFunc("DELETE /cache", h.InvalidateCacheHandler)
+	mux.HandleFunc("GET /documents", h.ListDocumentsHandler)
+	mux.HandleFunc("POST /documents", h.AddDocumentsHandler)
+	mux.HandleFunc("DELETE /documents/{id...}", h.RemoveDocumentHandler)
+	mux.HandleFunc("/readyz", h.HandleReady)
+	mux.Handle("/metrics", metrics.Handler())
 }
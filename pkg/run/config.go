@@ -4,16 +4,48 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/yreinhar/llm-go-blueprint/pkg/cache"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/guard"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/rag"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/redact"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
 	Port string `yaml:"port" env:"PORT"`
+	// Model is the logical name of the model, or fallback chain, that serves queries.
+	Model string `yaml:"model"`
+	// Models adds model definitions or replaces the built-in ones by their logical name.
+	Models map[string]model.Definition `yaml:"models"`
+	// PromptTemplates are the prompt template files, the built-in chat templates if empty.
+	PromptTemplates []string `yaml:"promptTemplates"`
+	// ResponseSchemas are the CUE schemas of responses and tool arguments, personResponse if empty.
+	ResponseSchemas []string `yaml:"responseSchemas"`
+	// Tasks maps the tasks /query serves to their response schema, only chat if empty.
+	Tasks map[string]string `yaml:"tasks"`
+	// DefaultTask is the task of /query payloads without one.
+	DefaultTask string `yaml:"defaultTask"`
+	// MaxToolSteps limits the rounds of tool calls per request, default 5. Tool handlers are registered
+	// with tools.Register before Run is called.
+	MaxToolSteps int `yaml:"maxToolSteps"`
+	// Cache serves identical prompt requests without calling the model, disabled by default.
+	Cache cache.Config `yaml:"cache"`
+	// Retrieval injects passages of local documents into prompts of templates with a retrieval block.
+	Retrieval rag.Config `yaml:"retrieval"`
+	// InputGuards check the user input before the prompt is built.
+	InputGuards guard.InputConfig `yaml:"inputGuards"`
+	// Redaction handles personal data in prompts and responses. Logs are always masked.
+	Redaction redact.Config `yaml:"redaction"`
+	// RequestValidation limits the size of request bodies and validates the template variables of tasks.
+	RequestValidation validation.RequestConfig `yaml:"requestValidation"`
 }
 
 func newDefaultConfig() *Config {
 	return &Config{
-		Port: "8080",
+		Port:  "8080",
+		Model: "LlamaLocal",
 	}
 }
 
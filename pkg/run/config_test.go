@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -91,3 +92,30 @@ func TestLoadConfig_YAMLOverrideDefaultConfig(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "9090", config.Port)
 }
+
+func TestLoadConfig_ModelDefinitions(t *testing.T) {
+	mockEnv := func(key string) string { return "" }
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	content := `
+models:
+  Hosted:
+    modelName: "gpt"
+    baseURL: "https://example.com/v1"
+    transport:
+      timeout: "10s"
+      retry:
+        maxAttempts: 5
+`
+	err := os.WriteFile(configPath, []byte(content), 0666)
+	require.NoError(t, err)
+
+	config, err := loadConfig(configPath, mockEnv)
+	require.NoError(t, err)
+	require.Contains(t, config.Models, "Hosted")
+	assert.Equal(t, "gpt", config.Models["Hosted"].ModelName)
+	assert.Equal(t, 10*time.Second, config.Models["Hosted"].Transport.Timeout.Std())
+	assert.Equal(t, 5, config.Models["Hosted"].Transport.Retry.MaxAttempts)
+}
@@ -0,0 +1,90 @@
+package run
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/app"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/rag"
+)
+
+const ingestCommand = "ingest"
+
+// runIngest updates the retrieval index without starting the server. The arguments are directories
+// and files to ingest, or document ids to remove with -remove. The index file may be shared with a
+// running server, which reloads it once it changed.
+func runIngest(ctx context.Context, args []string, getenv func(string) string, stdout io.Writer) error {
+	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath, "path to default config file")
+	id := flags.String("id", "", "id of the document when ingesting a single file; default the file name")
+	remove := flags.Bool("remove", false, "remove the documents with the given ids")
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("parsing flags: %w", err)
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("usage: %s [-config file] [-id id] [-remove] paths or ids...", args[0])
+	}
+	if *id != "" && flags.NArg() > 1 {
+		return fmt.Errorf("-id requires a single file")
+	}
+
+	config, err := loadConfig(*configPath, getenv)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if config.Retrieval.Embedder == "" {
+		return fmt.Errorf("retrieval is not configured")
+	}
+
+	store, err := app.NewRetrievalStore(config.Retrieval, model.NewDefinitions(config.Models))
+	if err != nil {
+		return err
+	}
+
+	if *remove {
+		for _, documentID := range flags.Args() {
+			removed, err := store.Remove(documentID)
+			if err != nil {
+				return err
+			}
+			if !removed {
+				return fmt.Errorf("unknown document: %s", documentID)
+			}
+			fmt.Fprintf(stdout, "removed %s\n", documentID)
+		}
+		return nil
+	}
+
+	for _, path := range flags.Args() {
+		infos, err := ingestPath(ctx, store, path, *id)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			fmt.Fprintf(stdout, "%s %s (%d chunks)\n", info.Status, info.ID, info.Chunks)
+		}
+	}
+
+	return nil
+}
+
+func ingestPath(ctx context.Context, store *rag.Store, path, id string) ([]rag.DocumentInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return store.IngestDir(ctx, path)
+	}
+
+	if id == "" {
+		id = filepath.Base(path)
+	}
+
+	return store.IngestFile(ctx, path, id)
+}
@@ -0,0 +1,66 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunIngest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Input []string `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+
+		var data []string
+		for i := range request.Input {
+			data = append(data, fmt.Sprintf(`{"index":%d,"embedding":[1,%d]}`, i, i))
+		}
+		fmt.Fprintf(w, `{"data":[%s]}`, strings.Join(data, ","))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "docs")
+	require.NoError(t, os.MkdirAll(docs, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(docs, "vacation.md"), []byte("Employees have 30 vacation days."), 0o644))
+	notes := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(notes, []byte("Travel is booked by the office."), 0o644))
+
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`
+retrieval:
+  embedder: "IngestEmbeddings"
+  index: %q
+models:
+  IngestEmbeddings:
+    modelName: "nomic-embed"
+    baseURL: %q
+`, filepath.Join(dir, "index.json"), server.URL)), 0o644))
+
+	getenv := func(string) string { return "" }
+
+	var stdout bytes.Buffer
+	err := Run(context.Background(), []string{"app", "ingest", "-config", configPath, docs, notes}, getenv, &stdout, &stdout)
+	require.NoError(t, err)
+	assert.Equal(t, "added vacation.md (1 chunks)\nadded notes.txt (1 chunks)\n", stdout.String())
+
+	stdout.Reset()
+	err = Run(context.Background(), []string{"app", "ingest", "-config", configPath, "-remove", "notes.txt"}, getenv, &stdout, &stdout)
+	require.NoError(t, err)
+	assert.Equal(t, "removed notes.txt\n", stdout.String())
+
+	err = Run(context.Background(), []string{"app", "ingest", "-config", configPath, "-remove", "notes.txt"}, getenv, &stdout, &stdout)
+	assert.ErrorContains(t, err, "unknown document")
+}
@@ -5,12 +5,16 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	stdlog "log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/yreinhar/llm-go-blueprint/pkg/app"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/redact"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tools"
 )
 
 const defaultConfigPath = "files/config.yaml"
@@ -23,6 +27,10 @@ func Run(
 	// stdin io.Reader, // For reading input.
 	stdout, stderr io.Writer, // For writing output.
 ) error {
+	if len(args) > 1 && args[1] == ingestCommand {
+		return runIngest(ctx, args[1:], getenv, stdout)
+	}
+
 	// Parse command line flags.
 	flags := flag.NewFlagSet(args[0], flag.ExitOnError)
 	configPath := flags.String("config", defaultConfigPath, "path to default config file")
@@ -36,8 +44,36 @@ func Run(
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// Personal data is masked in all logs, whatever the redaction mode of prompts is.
+	redactor, err := redact.New(config.Redaction)
+	if err != nil {
+		return fmt.Errorf("failed to create redactor: %w", err)
+	}
+	log.SetFormatter(redact.NewLogFormatter(log.StandardLogger().Formatter, redactor))
+	stdlog.SetOutput(redact.NewWriter(stdlog.Writer(), redactor))
+
+	serverOpts := []app.ServerOption{
+		app.WithModel(config.Model),
+		app.WithModelDefinitions(config.Models),
+		app.WithCache(config.Cache),
+		app.WithRetrieval(config.Retrieval),
+		app.WithInputGuards(config.InputGuards),
+		app.WithRedaction(config.Redaction),
+		app.WithRequestValidation(config.RequestValidation),
+		app.WithTools(tools.Default(), config.MaxToolSteps),
+	}
+	if len(config.PromptTemplates) > 0 {
+		serverOpts = append(serverOpts, app.WithPromptTemplates(config.PromptTemplates))
+	}
+	if len(config.ResponseSchemas) > 0 {
+		serverOpts = append(serverOpts, app.WithResponseSchemas(config.ResponseSchemas))
+	}
+	if len(config.Tasks) > 0 {
+		serverOpts = append(serverOpts, app.WithTasks(config.Tasks, config.DefaultTask))
+	}
+
 	// Create a new server instance
-	srv, err := app.NewServer()
+	srv, err := app.NewServer(serverOpts...)
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/guard"
+	model "github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/redact"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+var agentRuns = metrics.NewCounter("agent_runs_total")
+
+var (
+	// ErrAgentStepLimit is returned if the model did not answer within the maximum steps.
+	ErrAgentStepLimit = errors.New("agent reached the maximum number of steps")
+	// ErrAgentTokenBudget is returned if the model calls of a run used more tokens than allowed.
+	ErrAgentTokenBudget = errors.New("agent exceeded its token budget")
+)
+
+const defaultAgentMaxSteps = 10
+
+// AgentConfig limits an agent run.
+type AgentConfig struct {
+	MaxSteps     int           // model calls per run, default 10
+	StepTimeout  time.Duration // per model call, zero only limits the run by TotalTimeout
+	TotalTimeout time.Duration // per run, zero for no limit
+	ToolTimeout  time.Duration // per tool call, default 30s
+	TokenBudget  int           // tokens of all model calls of a run, zero for no limit
+}
+
+// Agent runs the model → tool → model loop of the query service within limits and records every
+// step in a transcript.
+type Agent struct {
+	service *QueryService
+	config  AgentConfig
+	store   TranscriptStore
+	// counter estimates the tokens of backends that do not report their usage.
+	counter tokenizer.Counter
+}
+
+// AgentOption represents an agent configuration option
+type AgentOption func(*Agent)
+
+// WithTranscriptStore persists the transcript of every run, whatever its outcome.
+func WithTranscriptStore(store TranscriptStore) AgentOption {
+	return func(a *Agent) {
+		a.store = store
+	}
+}
+
+// NewAgent creates an agent on top of the query service, it uses its models, prompt templates,
+// validator and tools.
+func NewAgent(s *QueryService, cfg AgentConfig, opts ...AgentOption) *Agent {
+	if cfg.MaxSteps <= 0 {
+		cfg.MaxSteps = defaultAgentMaxSteps
+	}
+
+	a := &Agent{
+		service: s,
+		config:  cfg,
+		counter: tokenizer.NewHeuristicCounter(0),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// AgentRun is the result of an agent run together with its transcript.
+type AgentRun struct {
+	// Result is the validated final answer, nil if the run failed.
+	Result     *Result
+	Transcript *Transcript
+}
+
+// Run lets the model answer the input, calling tools as often as it needs within the limits of the
+// agent. The run and its transcript are returned even if the run fails. If the model is a fallback
+// chain, the next model is only used as long as no tool was called.
+func (a *Agent) Run(ctx context.Context, input, responseSchema, task string, opts ...QueryOption) (*AgentRun, error) {
+	cfg := &queryConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	transcript := &Transcript{
+		ID:        newTranscriptID(),
+		Task:      task,
+		Input:     input,
+		StartedAt: time.Now(),
+	}
+	run := &AgentRun{Transcript: transcript}
+
+	runCtx := ctx
+	if a.config.TotalTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, a.config.TotalTimeout)
+		defer cancel()
+	}
+
+	input, vault := a.service.redactPrompt(input)
+
+	input, verdicts, err := a.service.checkInput(runCtx, input, task)
+	if err != nil {
+		a.finish(ctx, runCtx, transcript, err)
+		return run, err
+	}
+
+	candidates, _ := a.service.candidates()
+
+	for i, llm := range candidates {
+		run.Result, err = a.runModel(runCtx, llm, input, responseSchema, task, cfg, transcript)
+		if err == nil {
+			break
+		}
+		if transcript.ranTools() || runCtx.Err() != nil || !shouldFallback(err) || i == len(candidates)-1 {
+			break
+		}
+
+		log.WithFields(log.Fields{
+			"agent": transcript.ID,
+			"model": llm.Name(),
+			"next":  candidates[i+1].Name(),
+		}).Warnf("Falling back to next model: %v", err)
+		modelFallbacks.Inc(a.service.LlmModel.Name(), llm.Name())
+	}
+
+	if run.Result != nil {
+		run.Result.InputVerdicts = verdicts
+		run.Result.Response = a.service.redactResponse(run.Result.Response, vault)
+	}
+	a.finish(ctx, runCtx, transcript, err)
+
+	return run, err
+}
+
+// runModel runs the loop with a single model.
+func (a *Agent) runModel(ctx context.Context, llm model.Llm, input, responseSchema, task string, cfg *queryConfig, transcript *Transcript) (*Result, error) {
+	request, err := a.service.PromptBuilder.BuildPromptRequest(
+		input,
+		llm.Name(),
+		task,
+		prompt.WithContext(ctx),
+		prompt.WithSelectionKey(cfg.selectionKey),
+		prompt.WithResponseSchema(responseSchema),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build prompt request: %w", err)
+	}
+	transcript.Model = llm.Name()
+	transcript.PromptVersion = request.TemplateVersion
+
+	loop := toolLoop{
+		maxCalls: a.config.MaxSteps,
+		limitErr: fmt.Errorf("%w: %d", ErrAgentStepLimit, a.config.MaxSteps),
+		call: func(ctx context.Context, llm model.Llm, request prompt.PromptRequest) (model.Completion, error) {
+			return a.callModel(ctx, llm, request, transcript)
+		},
+		checkCall: func(completion model.Completion) error {
+			return a.checkBudget(transcript, len(completion.ToolCalls) == 0)
+		},
+		runTool: func(ctx context.Context, tools []prompt.Tool, call prompt.ToolCall) string {
+			return a.callTool(ctx, tools, call, transcript)
+		},
+	}
+
+	content, err := loop.run(ctx, llm, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.service.answer(ctx, llm, request, responseSchema, task, content)
+}
+
+// checkBudget fails the run once the model calls used more tokens than the budget, or used all of it
+// while the model still needs another call.
+func (a *Agent) checkBudget(transcript *Transcript, answered bool) error {
+	budget := a.config.TokenBudget
+	if budget <= 0 {
+		return nil
+	}
+
+	if transcript.TotalTokens > budget || (!answered && transcript.TotalTokens >= budget) {
+		return fmt.Errorf("%w: used %d of %d tokens", ErrAgentTokenBudget, transcript.TotalTokens, budget)
+	}
+
+	return nil
+}
+
+// callModel makes a single model call within the step timeout and records it.
+func (a *Agent) callModel(ctx context.Context, llm model.Llm, request prompt.PromptRequest, transcript *Transcript) (model.Completion, error) {
+	step := TranscriptStep{
+		Kind:      StepModel,
+		Model:     llm.Name(),
+		StartedAt: time.Now(),
+	}
+
+	if a.config.StepTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.config.StepTimeout)
+		defer cancel()
+	}
+
+	completion, err := callCompletion(ctx, llm, request)
+	if err != nil {
+		step.Error = err.Error()
+		transcript.addStep(step)
+		return model.Completion{}, err
+	}
+
+	step.Content = completion.Content
+	step.ToolCalls = completion.ToolCalls
+	step.Tokens = a.tokens(request, completion)
+	transcript.TotalTokens += step.Tokens
+	transcript.addStep(step)
+
+	return completion, nil
+}
+
+// callTool runs a tool call in the sandbox of the query service and records it. The returned
+// content is sent back to the model.
+func (a *Agent) callTool(ctx context.Context, tools []prompt.Tool, call prompt.ToolCall, transcript *Transcript) string {
+	step := TranscriptStep{
+		Kind:       StepTool,
+		Tool:       call.Name,
+		ToolCallID: call.ID,
+		Arguments:  call.Arguments,
+		StartedAt:  time.Now(),
+	}
+
+	content, err := a.service.callTool(ctx, tools, call, a.config.ToolTimeout)
+	if err != nil {
+		step.Error = err.Error()
+		content = toolError(err)
+	}
+	step.Content = content
+	transcript.addStep(step)
+
+	return content
+}
+
+// tokens returns the tokens of a model call as reported by the backend or estimated.
+func (a *Agent) tokens(request prompt.PromptRequest, completion model.Completion) int {
+	if completion.Usage.TotalTokens > 0 {
+		return completion.Usage.TotalTokens
+	}
+
+	tokens := a.counter.Count(completion.Content)
+	for _, message := range request.Messages {
+		tokens += a.counter.Count(message.Content)
+	}
+	for _, call := range completion.ToolCalls {
+		tokens += a.counter.Count(string(call.Arguments))
+	}
+
+	return tokens
+}
+
+// finish records the outcome of the run and persists the transcript.
+func (a *Agent) finish(ctx, runCtx context.Context, transcript *Transcript, err error) {
+	transcript.FinishedAt = time.Now()
+	transcript.Outcome = outcome(ctx, runCtx, err)
+	if err != nil {
+		transcript.Error = err.Error()
+	}
+	agentRuns.Inc(transcript.Task, transcript.Outcome)
+
+	log.WithFields(log.Fields{
+		"agent":   transcript.ID,
+		"task":    transcript.Task,
+		"outcome": transcript.Outcome,
+		"steps":   len(transcript.Steps),
+		"tokens":  transcript.TotalTokens,
+	}).Info("Agent run finished")
+
+	if a.store == nil {
+		return
+	}
+	// Transcripts are audit records, personal data is always masked in them. Without a redactor of
+	// the service the default detectors mask them.
+	redactor := a.service.Redactor
+	if redactor == nil {
+		var err error
+		if redactor, err = redact.New(redact.Config{}); err != nil {
+			log.WithField("agent", transcript.ID).Errorf("Failed to mask transcript: %v", err)
+			return
+		}
+	}
+	transcript = transcript.masked(redactor)
+	if err := a.store.Save(transcript); err != nil {
+		log.WithField("agent", transcript.ID).Errorf("Failed to persist transcript: %v", err)
+	}
+}
+
+func outcome(ctx, runCtx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return OutcomeAnswered
+	case errors.Is(err, ErrAgentStepLimit):
+		return OutcomeStepLimit
+	case errors.Is(err, ErrAgentTokenBudget):
+		return OutcomeTokenBudget
+	case errors.Is(err, guard.ErrBlocked):
+		return OutcomeBlocked
+	case ctx.Err() != nil:
+		return OutcomeCanceled
+	case runCtx.Err() != nil:
+		return OutcomeTimeout
+	default:
+		return OutcomeFailed
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/cache"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+var responseCacheRequests = metrics.NewCounter("response_cache_requests_total")
+
+// requestKey is the canonical hash of everything that determines a model response: the final
+// prompt request including the sampling parameters and tools, the template version and the response
+// schema.
+// It identifies identical requests for the response cache and for coalescing concurrent requests.
+func requestKey(request prompt.PromptRequest, responseSchema string) (string, error) {
+	data, err := json.Marshal(struct {
+		Request         prompt.PromptRequest `json:"request"`
+		TemplateVersion string               `json:"templateVersion"`
+		ResponseSchema  string               `json:"responseSchema"`
+		Tools           []prompt.Tool        `json:"tools,omitempty"`
+	}{
+		Request:         request,
+		TemplateVersion: request.TemplateVersion,
+		ResponseSchema:  responseSchema,
+		Tools:           request.Tools,
+	})
+	if err != nil {
+		return "", fmt.Errorf("encoding request key: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cachedResult returns the cached result for the key, if any.
+func (s *QueryService) cachedResult(key string) (*Result, bool) {
+	data, ok := s.Cache.Get(key)
+	if !ok {
+		responseCacheRequests.Inc("miss")
+		return nil, false
+	}
+
+	result, err := decodeResult(data)
+	if err != nil {
+		log.Warnf("Ignoring invalid cached response %s: %v", key, err)
+		responseCacheRequests.Inc("miss")
+		return nil, false
+	}
+	responseCacheRequests.Inc("hit")
+
+	return result, true
+}
+
+// cacheResult stores a validated result under the key.
+func (s *QueryService) cacheResult(key string, result *Result) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Warnf("Failed to cache response %s: %v", key, err)
+		return
+	}
+
+	s.Cache.Set(key, data, s.CacheTTL)
+}
+
+// semanticScope returns what the response to the request depends on besides the input: the task,
+// schema, model, template version and the retrieved passages.
+func semanticScope(request prompt.PromptRequest, responseSchema, task string) cache.SemanticScope {
+	scope := cache.SemanticScope{
+		Task:            task,
+		ResponseSchema:  responseSchema,
+		Model:           request.Model,
+		TemplateVersion: request.TemplateVersion,
+	}
+	if len(request.Passages) > 0 {
+		hash := sha256.New()
+		for _, passage := range request.Passages {
+			fmt.Fprintf(hash, "%s\x00%s\x00", passage.ID, passage.Text)
+		}
+		scope.Context = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	return scope
+}
+
+// semanticLookup embeds the input and returns the cached result of a similar prompt of the scope,
+// if any. The embedding is returned to store the result on a miss. The semantic cache is skipped if
+// the input cannot be embedded, e.g. because the embedding model is down.
+func (s *QueryService) semanticLookup(ctx context.Context, input string, scope cache.SemanticScope, cfg *queryConfig) (*Result, []float32) {
+	vector, err := s.SemanticCache.Embed(ctx, input)
+	if err != nil {
+		log.Warnf("Skipping semantic cache: %v", err)
+		return nil, nil
+	}
+	if cfg.bypassCache {
+		return nil, vector
+	}
+
+	data, ok := s.SemanticCache.Lookup(scope, vector)
+	if !ok {
+		return nil, vector
+	}
+
+	result, err := decodeResult(data)
+	if err != nil {
+		log.Warnf("Ignoring invalid semantically cached response: %v", err)
+		return nil, vector
+	}
+
+	return result, vector
+}
+
+// semanticStore caches a validated result for prompts of the scope similar to the input.
+func (s *QueryService) semanticStore(scope cache.SemanticScope, vector []float32, result *Result) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Warnf("Failed to cache response: %v", err)
+		return
+	}
+
+	if err := s.SemanticCache.Store(scope, vector, data); err != nil {
+		log.Warnf("Failed to cache response: %v", err)
+	}
+}
+
+// InvalidateCache removes the semantically cached responses of the task, e.g. after its prompt
+// template changed. It returns the number of removed responses. Exact match entries are keyed by
+// the full prompt request and become unreachable by themselves when the template changes.
+func (s *QueryService) InvalidateCache(task string) int {
+	if s.SemanticCache == nil {
+		return 0
+	}
+
+	removed := s.SemanticCache.InvalidateTask(task)
+	log.WithField("task", task).Infof("Invalidated %d cached responses", removed)
+
+	return removed
+}
+
+func decodeResult(data []byte) (*Result, error) {
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	result.Cached = true
+
+	return &result, nil
+}
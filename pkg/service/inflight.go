@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup coalesces identical concurrent requests into a single call. Unlike a plain
+// singleflight, every caller waits with its own context: a caller that gives up returns right away
+// while the others keep waiting, and the shared call is only canceled once all callers are gone.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done    chan struct{}
+	result  *Result
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do runs fn once for all concurrent callers with the same key and returns its result to each of
+// them. shared reports whether the caller joined a call started by another caller.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) (*Result, error)) (result *Result, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	call, shared := g.calls[key]
+	if !shared {
+		// The call must outlive the caller that started it, it keeps the context values only.
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		g.calls[key] = call
+
+		go func() {
+			call.result, call.err = fn(callCtx)
+			g.forget(key, call)
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, shared, call.err
+		}
+		// Every caller gets its own copy of the result.
+		result := *call.result
+		return &result, shared, nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			g.forgetLocked(key, call)
+		}
+		g.mu.Unlock()
+		return nil, shared, ctx.Err()
+	}
+}
+
+// forget removes the call so that later requests start a new one.
+func (g *flightGroup) forget(key string, call *flightCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.forgetLocked(key, call)
+}
+
+func (g *flightGroup) forgetLocked(key string, call *flightCall) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,175 @@
+package service_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/service"
+)
+
+// blockingLLM answers once release is closed and counts its calls.
+type blockingLLM struct {
+	calls    atomic.Int32
+	started  chan struct{}
+	release  chan struct{}
+	response []byte
+}
+
+func newBlockingLLM(response string) *blockingLLM {
+	return &blockingLLM{
+		started:  make(chan struct{}, 10),
+		release:  make(chan struct{}),
+		response: []byte(response),
+	}
+}
+
+func (m *blockingLLM) Name() string { return "local" }
+
+func (m *blockingLLM) CallModel(ctx context.Context, request prompt.PromptRequest) ([]byte, error) {
+	m.calls.Add(1)
+	m.started <- struct{}{}
+	select {
+	case <-m.release:
+		return m.response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newInflightService(llm *blockingLLM, validateErr error) *service.QueryService {
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "prompt", "local", "chat").Return(prompt.PromptRequest{Model: "local"}, nil)
+
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", mock.Anything, mock.Anything).Return(validateErr)
+
+	return &service.QueryService{
+		LlmModel:      llm,
+		Validator:     mockValidator,
+		PromptBuilder: mockPromptBuilder,
+	}
+}
+
+func TestQueryServiceCoalescesIdenticalRequests(t *testing.T) {
+	llm := newBlockingLLM(`{"name":"Ada"}`)
+	queryService := newInflightService(llm, nil)
+
+	const callers = 5
+	results := make([]*service.Result, callers)
+	errs := make([]error, callers)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], errs[0] = queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+	}()
+	<-llm.started
+
+	for i := 1; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+		}()
+	}
+
+	// Give the other callers time to join the in-flight call.
+	time.Sleep(50 * time.Millisecond)
+	close(llm.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), llm.calls.Load())
+	for i := range callers {
+		require.NoError(t, errs[i])
+		assert.Equal(t, `{"name":"Ada"}`, results[i].Response)
+	}
+}
+
+func TestQueryServiceCoalescedRequestsShareErrors(t *testing.T) {
+	llm := newBlockingLLM(`{}`)
+	queryService := newInflightService(llm, assert.AnError)
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+		errs <- err
+	}()
+	<-llm.started
+	go func() {
+		_, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+		errs <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(llm.release)
+
+	for range 2 {
+		err := <-errs
+		assert.ErrorIs(t, err, service.ErrValidationFailed)
+		assert.ErrorIs(t, err, assert.AnError)
+	}
+	assert.Equal(t, int32(1), llm.calls.Load())
+}
+
+func TestQueryServiceCoalescedRequestsRespectCancellation(t *testing.T) {
+	llm := newBlockingLLM(`{"name":"Ada"}`)
+	queryService := newInflightService(llm, nil)
+
+	// The caller that started the call gives up, the one that joined it keeps waiting.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := queryService.ProcessPrompt(ctx, "prompt", "personResponse", "chat")
+		first <- err
+	}()
+	<-llm.started
+
+	second := make(chan *service.Result, 1)
+	go func() {
+		result, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+		assert.NoError(t, err)
+		second <- result
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+
+	close(llm.release)
+	assert.Equal(t, `{"name":"Ada"}`, (<-second).Response)
+	assert.Equal(t, int32(1), llm.calls.Load())
+}
+
+func TestQueryServiceCancelsCallWithoutWaiters(t *testing.T) {
+	llm := newBlockingLLM(`{"name":"Ada"}`)
+	queryService := newInflightService(llm, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := queryService.ProcessPrompt(ctx, "prompt", "personResponse", "chat")
+		done <- err
+	}()
+	<-llm.started
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	// The abandoned call is canceled, so a new request starts a new call.
+	go func() {
+		_, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+		done <- err
+	}()
+	<-llm.started
+	close(llm.release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, int32(2), llm.calls.Load())
+}
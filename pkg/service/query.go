@@ -1,27 +1,172 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 
+	log "github.com/sirupsen/logrus"
+	"github.com/yreinhar/llm-go-blueprint/pkg/cache"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/guard"
 	model "github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
 	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/redact"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tokenizer"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tools"
 	validation "github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
 )
 
+var (
+	promptVersionSelections = metrics.NewCounter("prompt_version_selections_total")
+	modelFallbacks          = metrics.NewCounter("model_fallbacks_total")
+	coalescedRequests       = metrics.NewCounter("coalesced_requests_total")
+)
+
+// ErrValidationFailed is returned if the model response does not match the response schema.
+var ErrValidationFailed = errors.New("failed to validate response")
+
 // QueryService handles requests to LanguageModel.
 type QueryService struct {
 	LlmModel      model.Llm
 	Validator     validation.Validation
 	PromptBuilder prompt.Prompt
+
+	// Cache stores validated responses of identical prompt requests, nil disables caching.
+	Cache cache.Cache
+	// CacheTTL is the time a cached response is served, zero keeps it until it is evicted.
+	CacheTTL time.Duration
+	// SemanticCache serves validated responses of similar prompts, nil disables it.
+	SemanticCache *cache.Semantic
+
+	// Redactor handles personal data in prompts and responses and masks it in transcripts, nil disables it.
+	Redactor *redact.Redactor
+
+	// InputGuards check the user input before the prompt is built, nil disables them.
+	InputGuards *guard.InputChain
+	// outputChains holds the *guard.OutputChain of each *prompt.OutputGuardsConfig of the templates.
+	outputChains sync.Map
+
+	// Definitions are the models the service and the judges of its output guards are created from,
+	// nil uses the default definitions.
+	Definitions *model.Definitions
+
+	// Tools holds the handlers of the tools declared in the prompt templates.
+	Tools *tools.Registry
+	// MaxToolSteps limits the rounds of tool calls before the model has to answer, default 5.
+	MaxToolSteps int
+	// ToolTimeout limits the run time of a single tool call, default 30s.
+	ToolTimeout time.Duration
+
+	inflight flightGroup
+
+	typesMu     sync.RWMutex
+	typeSchemas map[reflect.Type]string // response schema of the Go types registered for Query
 }
 
-// QueryService creates a new query service for the given large language model.
-func NewQueryService(modelName string, schemaPaths []string, promptFiles []string) (*QueryService, error) {
-	llmModel, err := model.GetLlmFactory(modelName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get llm model:: %w", err)
+// Option represents a configuration option of the query service
+type Option func(*QueryService)
+
+// WithResponseCache serves identical prompt requests from the cache instead of calling the model.
+func WithResponseCache(c cache.Cache, ttl time.Duration) Option {
+	return func(s *QueryService) {
+		s.Cache = c
+		s.CacheTTL = ttl
+	}
+}
+
+// Result is the validated model response together with details on how it was produced.
+type Result struct {
+	Response      string
+	Model         string
+	PromptVersion string
+	// Cached is true if the response was served from the response cache.
+	Cached bool
+	// Citations are the ids of the retrieved passages the response cites.
+	Citations []string
+	// InputVerdicts are the verdicts of the input guards that flagged the input.
+	InputVerdicts []guard.Verdict
+	// OutputVerdicts are the verdicts of the output guards of the template that flagged the response.
+	OutputVerdicts []guard.Verdict
+	// Repairs are what the extraction of the response schema repaired before validation, e.g. fences.
+	Repairs []string
+}
+
+// WithModelDefinitions creates the models from the given definitions instead of the default ones.
+func WithModelDefinitions(definitions *model.Definitions) Option {
+	return func(s *QueryService) {
+		s.Definitions = definitions
+	}
+}
+
+// WithSemanticCache serves prompts similar to a previous one from the cache if the exact match cache misses.
+func WithSemanticCache(c *cache.Semantic) Option {
+	return func(s *QueryService) {
+		s.SemanticCache = c
+	}
+}
+
+// WithRetriever injects passages found by the retriever into the prompts of templates with a
+// retrieval block.
+func WithRetriever(retriever prompt.Retriever) Option {
+	return func(s *QueryService) {
+		if builder, ok := s.PromptBuilder.(interface{ SetRetriever(prompt.Retriever) }); ok {
+			builder.SetRetriever(retriever)
+		}
+	}
+}
+
+// WithRedactor handles personal data in prompts and responses according to the mode of the redactor.
+func WithRedactor(r *redact.Redactor) Option {
+	return func(s *QueryService) {
+		s.Redactor = r
+	}
+}
+
+// WithInputGuards checks the user input with the guards before the prompt is built.
+func WithInputGuards(chain *guard.InputChain) Option {
+	return func(s *QueryService) {
+		s.InputGuards = chain
 	}
+}
+
+// WithTools runs the tools the model asks for with the handlers of the registry.
+func WithTools(registry *tools.Registry, maxSteps int) Option {
+	return func(s *QueryService) {
+		s.Tools = registry
+		s.MaxToolSteps = maxSteps
+	}
+}
 
+// QueryOption represents a per request option of the query service
+type QueryOption func(*queryConfig)
+
+type queryConfig struct {
+	selectionKey string
+	bypassCache  bool
+}
+
+// WithSelectionKey pins the prompt template version chosen for a caller, e.g. per user or session.
+func WithSelectionKey(key string) QueryOption {
+	return func(c *queryConfig) {
+		c.selectionKey = key
+	}
+}
+
+// WithCacheBypass always calls the model for this request instead of serving a cached response.
+// The fresh response is still cached.
+func WithCacheBypass() QueryOption {
+	return func(c *queryConfig) {
+		c.bypassCache = true
+	}
+}
+
+// QueryService creates a new query service for the given large language model.
+func NewQueryService(modelName string, schemaPaths []string, promptFiles []string, opts ...Option) (*QueryService, error) {
 	promptBuilder, err := prompt.NewPromptBuilder(promptFiles)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create prompt builder: %w", err)
@@ -31,31 +176,362 @@ func NewQueryService(modelName string, schemaPaths []string, promptFiles []strin
 	if err != nil {
 		return nil, fmt.Errorf("failed to create response validator: %w", err)
 	}
+	promptBuilder.SetSchemaDescriber(validator)
 
-	return &QueryService{
-		LlmModel:      llmModel,
+	s := &QueryService{
 		Validator:     validator,
 		PromptBuilder: promptBuilder,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// The model is created after the options, they may replace the definitions.
+	s.LlmModel, err = s.definitions().NewLlm(modelName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get llm model:: %w", err)
+	}
+	if err := configureModels(promptBuilder, s.definitions(), modelName); err != nil {
+		return nil, err
+	}
+
+	if err := checkTools(promptBuilder, validator, s.Tools); err != nil {
+		return nil, err
+	}
+	if err := promptBuilder.CheckRetrieval(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// configureModels enforces the context window of the model, or of every model of a chain, on prompts
+// and enables the response schema for models with structured output support. A chain is rejected if
+// one of its models has no template for a task another model of the chain serves, it could never
+// fall back for that task.
+func configureModels(promptBuilder *prompt.PromptBuilder, definitions *model.Definitions, modelName string) error {
+	definition, err := definitions.Get(modelName)
+	if err != nil {
+		return fmt.Errorf("failed to get model definition: %w", err)
+	}
+
+	modelNames := definition.Chain
+	if len(modelNames) == 0 {
+		modelNames = []string{modelName}
+	}
+
+	backends := make([]string, 0, len(modelNames))
+	for _, name := range modelNames {
+		definition, err := definitions.Get(name)
+		if err != nil {
+			return fmt.Errorf("failed to get model definition: %w", err)
+		}
+		backends = append(backends, definition.ModelName)
+
+		if definition.StructuredOutput != "" && definition.StructuredOutput != model.StructuredOutputNone {
+			promptBuilder.SetStructuredOutput(definition.ModelName)
+		}
+		if definition.ContextLength <= 0 {
+			continue
+		}
+
+		counter, err := tokenizer.New(definition.Tokenizer)
+		if err != nil {
+			return fmt.Errorf("failed to create token counter for %s: %w", name, err)
+		}
+
+		promptBuilder.SetBudget(definition.ModelName, prompt.Budget{
+			ContextLength: definition.ContextLength,
+			Counter:       counter,
+		})
+	}
+
+	if len(definition.Chain) == 0 {
+		return nil
+	}
+
+	for _, task := range promptBuilder.Tasks() {
+		var missing []string
+		for i, backend := range backends {
+			if !promptBuilder.HasTemplate(backend, task) {
+				missing = append(missing, modelNames[i])
+			}
+		}
+		if len(missing) > 0 && len(missing) < len(backends) {
+			return fmt.Errorf("chain %s: %s has no prompt template for task %s", modelName, strings.Join(missing, ", "), task)
+		}
+	}
+
+	return nil
 }
 
 // ProcessPrompt processes the input prompt using the specified model and can perform validation
-// on the LLM response based a specified output schema.
-func (s *QueryService) ProcessPrompt(prompt, responseSchema, task string) (string, error) {
-	// TODO: 1. validate input promp 2. sanitize input prompt 3. call model 4. postprocess repsonse/handle/validate response
-	request, err := s.PromptBuilder.BuildPromptRequest(prompt, s.LlmModel.Name(), task)
+// on the LLM response based a specified output schema. If the model is a fallback chain, the next
+// model is used when a model fails or keeps returning invalid responses.
+func (s *QueryService) ProcessPrompt(ctx context.Context, input, responseSchema, task string, opts ...QueryOption) (*Result, error) {
+	cfg := &queryConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	// Personal data is redacted before the input reaches any model, including guard classifiers.
+	input, vault := s.redactPrompt(input)
+
+	input, verdicts, err := s.checkInput(ctx, input, task)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, attempts := s.candidates()
+
+	var lastErr error
+	for i, llm := range candidates {
+		for attempt := 1; attempt <= attempts; attempt++ {
+			result, err := s.queryModel(ctx, llm, input, responseSchema, task, cfg)
+			if err == nil {
+				result.InputVerdicts = verdicts
+				result.Response = s.redactResponse(result.Response, vault)
+				return result, nil
+			}
+			lastErr = err
+
+			// Only invalid responses are retried with the same model.
+			if !errors.Is(err, ErrValidationFailed) {
+				break
+			}
+		}
+
+		if ctx.Err() != nil || !shouldFallback(lastErr) || i == len(candidates)-1 {
+			break
+		}
+
+		log.WithFields(log.Fields{
+			"chain": s.LlmModel.Name(),
+			"model": llm.Name(),
+			"next":  candidates[i+1].Name(),
+		}).Warnf("Falling back to next model: %v", lastErr)
+		modelFallbacks.Inc(s.LlmModel.Name(), llm.Name())
+	}
+
+	return nil, lastErr
+}
+
+// candidates returns the models tried in order and the attempts per model on invalid responses.
+func (s *QueryService) candidates() ([]model.Llm, int) {
+	if chain, ok := s.LlmModel.(*model.Chain); ok {
+		return chain.Members(), chain.ValidationAttempts()
+	}
+
+	return []model.Llm{s.LlmModel}, 1
+}
+
+// redactPrompt handles personal data in the input according to the mode of the redactor. The vault
+// restores tokenized values in the response.
+func (s *QueryService) redactPrompt(input string) (string, *redact.Vault) {
+	if s.Redactor == nil {
+		return input, nil
+	}
+
+	return s.Redactor.RedactPrompt(input)
+}
+
+// redactResponse restores the tokenized values of the prompt in the response. Cached responses keep
+// the tokens, so personal data is never cached.
+func (s *QueryService) redactResponse(response string, vault *redact.Vault) string {
+	if s.Redactor == nil {
+		return response
+	}
+
+	return s.Redactor.RedactResponse(response, vault)
+}
+
+// checkInput runs the input guards. It returns the input rewritten by the guards and the verdicts
+// that flagged it, or a *guard.BlockedError.
+func (s *QueryService) checkInput(ctx context.Context, input, task string) (string, []guard.Verdict, error) {
+	if s.InputGuards == nil {
+		return input, nil, nil
+	}
+
+	return s.InputGuards.Check(ctx, task, input)
+}
+
+// queryModel builds the prompt for the model, calls it and validates the response.
+func (s *QueryService) queryModel(ctx context.Context, llm model.Llm, input, responseSchema, task string, cfg *queryConfig) (*Result, error) {
+	// TODO: postprocess repsonse/handle response
+	modelName := llm.Name()
+	request, err := s.PromptBuilder.BuildPromptRequest(
+		input,
+		modelName,
+		task,
+		prompt.WithContext(ctx),
+		prompt.WithSelectionKey(cfg.selectionKey),
+		prompt.WithResponseSchema(responseSchema),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build prompt request: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"model":         modelName,
+		"task":          task,
+		"promptVersion": request.TemplateVersion,
+	}).Info("Built prompt request")
+	promptVersionSelections.Inc(modelName, task, request.TemplateVersion)
+
+	key, err := requestKey(request, responseSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Cache != nil && !cfg.bypassCache {
+		if result, ok := s.cachedResult(key); ok {
+			log.WithField("model", modelName).Debug("Serving response from cache")
+			return result, nil
+		}
+	}
+
+	// The semantic cache is only asked after the exact match cache missed, it needs an embedding call.
+	var semanticVector []float32
+	scope := semanticScope(request, responseSchema, task)
+	if s.SemanticCache != nil {
+		var cached *Result
+		cached, semanticVector = s.semanticLookup(ctx, input, scope, cfg)
+		if cached != nil {
+			log.WithField("model", modelName).Debug("Serving response from semantic cache")
+			return cached, nil
+		}
+	}
+
+	// Identical concurrent requests share one model call and its validated result or error.
+	result, shared, err := s.inflight.do(ctx, key, func(ctx context.Context) (*Result, error) {
+		return s.callModel(ctx, llm, request, responseSchema, task, key)
+	})
+	if shared {
+		log.WithField("model", modelName).Debug("Joined identical in-flight request")
+		coalescedRequests.Inc(modelName)
+	} else if err == nil && semanticVector != nil {
+		s.semanticStore(scope, semanticVector, result)
+	}
+
+	return result, err
+}
+
+// callModel calls the model with the prompt request, runs the tools it asks for, validates and checks
+// the response and caches it.
+func (s *QueryService) callModel(ctx context.Context, llm model.Llm, request prompt.PromptRequest, responseSchema, task, key string) (*Result, error) {
+	response, err := s.complete(ctx, llm, request)
 	if err != nil {
-		return "", fmt.Errorf("failed to build prompt request: %w", err)
+		return nil, err
 	}
 
-	response, err := s.LlmModel.CallModel(request)
+	result, err := s.answer(ctx, llm, request, responseSchema, task, response)
 	if err != nil {
-		return "", fmt.Errorf("failed to call model: %w", err)
+		return nil, err
 	}
 
+	// Only responses that passed validation and the output guards are cached.
+	if s.Cache != nil {
+		s.cacheResult(key, result)
+	}
+
+	return result, nil
+}
+
+// answer extracts and validates the final answer of the model to the request and runs the output
+// guards of the template on it.
+func (s *QueryService) answer(ctx context.Context, llm model.Llm, request prompt.PromptRequest, responseSchema, task string, response []byte) (*Result, error) {
+	response, repairs := s.extract(responseSchema, response)
 	if err := s.Validator.Validate(responseSchema, response); err != nil {
-		return "", fmt.Errorf("failed to validate response: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	verdicts, err := s.checkOutput(ctx, request, task, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Response:       string(response),
+		Model:          llm.Name(),
+		PromptVersion:  request.TemplateVersion,
+		Citations:      prompt.Citations(string(response), request.Passages),
+		OutputVerdicts: verdicts,
+		Repairs:        repairs,
+	}, nil
+}
+
+// extract applies the extraction stages of the response schema, if the validator supports them, and
+// returns the extracted response with what was repaired.
+func (s *QueryService) extract(responseSchema string, response []byte) ([]byte, []string) {
+	extractor, ok := s.Validator.(validation.Extractor)
+	if !ok {
+		return response, nil
+	}
+
+	extraction := extractor.Extract(responseSchema, response)
+
+	return extraction.Data, extraction.Repairs
+}
+
+// checkOutput runs the output guards of the request's template on the validated response and returns
+// the verdicts that flagged it. A blocked response fails like an invalid one, so it is retried and
+// falls back to the next model of a chain.
+func (s *QueryService) checkOutput(ctx context.Context, request prompt.PromptRequest, task string, response []byte) ([]guard.Verdict, error) {
+	if request.OutputGuards == nil {
+		return nil, nil
+	}
+
+	chain, err := s.outputChain(request.OutputGuards)
+	if err != nil {
+		return nil, err
+	}
+
+	verdicts, err := chain.Check(ctx, task, string(response))
+	if errors.Is(err, guard.ErrOutputBlocked) {
+		return nil, fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	return verdicts, err
+}
+
+// outputChain returns the chain of the output guards of a template. Chains are created on first use
+// and shared by all requests of the template.
+func (s *QueryService) outputChain(cfg *prompt.OutputGuardsConfig) (*guard.OutputChain, error) {
+	if chain, ok := s.outputChains.Load(cfg); ok {
+		return chain.(*guard.OutputChain), nil
+	}
+
+	checks, err := guard.NewOutputChecks(*cfg, s.definitions())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output guards: %w", err)
+	}
+
+	chain, _ := s.outputChains.LoadOrStore(cfg, guard.NewOutputChain(checks...))
+
+	return chain.(*guard.OutputChain), nil
+}
+
+// shouldFallback reports whether the next model of a chain may succeed where the last one failed.
+func shouldFallback(err error) bool {
+	var budgetErr *prompt.BudgetError
+	return model.IsFallbackError(err) || errors.Is(err, ErrValidationFailed) || errors.As(err, &budgetErr)
+}
+
+// Backends reports the health of the model backends, e.g. the state of their circuit breakers.
+func (s *QueryService) Backends() []model.BackendStatus {
+	reporter, ok := s.LlmModel.(model.StatusReporter)
+	if !ok {
+		return nil
+	}
+
+	return reporter.Status()
+}
+
+// definitions returns the model definitions of the service.
+func (s *QueryService) definitions() *model.Definitions {
+	if s.Definitions == nil {
+		return model.DefaultDefinitions()
 	}
 
-	return string(response), nil
+	return s.Definitions
 }
@@ -1,12 +1,23 @@
 package service_test
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/cache"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/guard"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
 	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/redact"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/transport"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
 	"github.com/yreinhar/llm-go-blueprint/pkg/service"
 )
 
@@ -22,7 +33,7 @@ type MockPromptBuilder struct {
 	mock.Mock
 }
 
-func (m *MockPromptBuilder) BuildPromptRequest(userInput, model, task string) (prompt.PromptRequest, error) {
+func (m *MockPromptBuilder) BuildPromptRequest(userInput, model, task string, opts ...prompt.BuildOption) (prompt.PromptRequest, error) {
 	args := m.Called(userInput, model, task)
 	return args.Get(0).(prompt.PromptRequest), args.Error(1)
 }
@@ -32,7 +43,7 @@ func (m *MockLLM) Name() string {
 	return args.String(0)
 }
 
-func (m *MockLLM) CallModel(prompt prompt.PromptRequest) ([]byte, error) {
+func (m *MockLLM) CallModel(ctx context.Context, prompt prompt.PromptRequest) ([]byte, error) {
 	args := m.Called(prompt)
 	// Get the first argument as []byte directly
 	if bytes, ok := args.Get(0).([]byte); ok {
@@ -59,7 +70,7 @@ func TestNewQueryServiceValid(t *testing.T) {
 			name:        "valid model LlamaLocal with empty schema",
 			modelName:   "LlamaLocal",
 			schemaPaths: []string{},
-			promptFiles: []string{},
+			promptFiles: []string{"prompts/promptTemplateDefault.yaml"},
 		},
 		{
 			name:        "valid model LlamaLocal with schema and prompt files",
@@ -78,6 +89,11 @@ func TestNewQueryServiceValid(t *testing.T) {
 	}
 }
 
+func TestNewQueryServiceWithoutPromptTemplates(t *testing.T) {
+	_, err := service.NewQueryService("LlamaLocal", []string{"schemas/personResponse.cue"}, []string{})
+	assert.ErrorContains(t, err, "no prompt templates found")
+}
+
 func TestNewQueryServiceInvalidModel(t *testing.T) {
 	tests := struct {
 		name        string
@@ -146,11 +162,14 @@ func TestQueryServiceProcessPromptSuccess(t *testing.T) {
 		schema := mock.Anything
 		task := mock.Anything
 
-		mockPromptBuilder := new(MockPromptBuilder) // Todo
-		mockPromptBuilder.On("BuildPromptRequest", testCase.prompt, testCase.modelName, "").Return(prompt.PromptRequest{}, nil)
+		request := prompt.PromptRequest{Model: testCase.modelName, TemplateVersion: "v2"}
+
+		mockPromptBuilder := new(MockPromptBuilder)
+		mockPromptBuilder.On("BuildPromptRequest", testCase.prompt, testCase.modelName, task).Return(request, nil)
 
 		mockLLM := new(MockLLM)
-		mockLLM.On("CallModel", testCase.prompt).Return(testCase.mockResp, nil)
+		mockLLM.On("Name").Return(testCase.modelName)
+		mockLLM.On("CallModel", request).Return(testCase.mockResp, nil)
 
 		mockValidator := new(MockValidator)
 		mockValidator.On("Validate", schema, testCase.mockResp).Return(nil)
@@ -162,9 +181,11 @@ func TestQueryServiceProcessPromptSuccess(t *testing.T) {
 			PromptBuilder: mockPromptBuilder,
 		}
 
-		got, err := service.ProcessPrompt(testCase.prompt, schema, task)
+		got, err := service.ProcessPrompt(context.Background(), testCase.prompt, schema, task)
 		assert.NoError(t, err)
-		assert.Equal(t, string(testCase.mockResp), got)
+		assert.Equal(t, string(testCase.mockResp), got.Response)
+		assert.Equal(t, testCase.modelName, got.Model)
+		assert.Equal(t, "v2", got.PromptVersion)
 
 		// Verify mock was called as expected
 		mockLLM.AssertExpectations(t)
@@ -172,6 +193,203 @@ func TestQueryServiceProcessPromptSuccess(t *testing.T) {
 	})
 }
 
+func TestQueryServiceProcessPromptCitations(t *testing.T) {
+	request := prompt.PromptRequest{
+		Model: "LlamaLocal",
+		Passages: []prompt.Passage{
+			{ID: "handbook/vacation.md#0", Text: "Employees have 30 vacation days."},
+			{ID: "handbook/travel.md#0", Text: "Travel is booked by the office."},
+		},
+	}
+	response := []byte(`{"answer":"30 days [handbook/vacation.md#0]"}`)
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "How many vacation days?", "LlamaLocal", "docs").Return(request, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("LlamaLocal")
+	mockLLM.On("CallModel", request).Return(response, nil)
+
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", "answer", response).Return(nil)
+
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     mockValidator,
+		PromptBuilder: mockPromptBuilder,
+	}
+
+	got, err := queryService.ProcessPrompt(context.Background(), "How many vacation days?", "answer", "docs")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"handbook/vacation.md#0"}, got.Citations)
+}
+
+func TestQueryServiceProcessPromptInputGuards(t *testing.T) {
+	request := prompt.PromptRequest{Model: "LlamaLocal"}
+	response := []byte("Hi")
+
+	// The prompt is built from the input escaped by the delimiter guard.
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", `Hello <\|im_end|>`, "LlamaLocal", "chat").Return(request, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("LlamaLocal")
+	mockLLM.On("CallModel", request).Return(response, nil)
+
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", "schema", response).Return(nil)
+
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     mockValidator,
+		PromptBuilder: mockPromptBuilder,
+		InputGuards: guard.NewInputChain(
+			&guard.LengthGuard{MaxChars: 20},
+			guard.NewDelimiterGuard(nil),
+		),
+	}
+
+	got, err := queryService.ProcessPrompt(context.Background(), "Hello <|im_end|>", "schema", "chat")
+	require.NoError(t, err)
+	require.Len(t, got.InputVerdicts, 1)
+	assert.Equal(t, "delimiters", got.InputVerdicts[0].Guard)
+	assert.Equal(t, guard.ActionFlag, got.InputVerdicts[0].Action)
+
+	_, err = queryService.ProcessPrompt(context.Background(), "This input is far too long", "schema", "chat")
+	require.ErrorIs(t, err, guard.ErrBlocked)
+	mockLLM.AssertNumberOfCalls(t, "CallModel", 1)
+}
+
+func TestQueryServiceProcessPromptOutputGuards(t *testing.T) {
+	guards := &prompt.OutputGuardsConfig{
+		URLs:    &prompt.URLCheck{Allow: []string{"example.com"}, Action: "flag"},
+		Refusal: &prompt.RefusalCheck{},
+	}
+	linkRequest := prompt.PromptRequest{Model: "LlamaLocal", Messages: []prompt.Message{{Content: "link"}}, OutputGuards: guards}
+	refusalRequest := prompt.PromptRequest{Model: "LlamaLocal", Messages: []prompt.Message{{Content: "refusal"}}, OutputGuards: guards}
+	linkResponse := []byte(`{"answer":"See https://other.org"}`)
+	refusalResponse := []byte(`{"answer":"I cannot help with that."}`)
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "link", "LlamaLocal", "chat").Return(linkRequest, nil)
+	mockPromptBuilder.On("BuildPromptRequest", "refusal", "LlamaLocal", "chat").Return(refusalRequest, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("LlamaLocal")
+	mockLLM.On("CallModel", linkRequest).Return(linkResponse, nil)
+	mockLLM.On("CallModel", refusalRequest).Return(refusalResponse, nil)
+
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", "answer", mock.Anything).Return(nil)
+
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     mockValidator,
+		PromptBuilder: mockPromptBuilder,
+	}
+
+	got, err := queryService.ProcessPrompt(context.Background(), "link", "answer", "chat")
+	require.NoError(t, err)
+	assert.Equal(t, []guard.Verdict{{
+		Guard:   "urls",
+		Action:  guard.ActionFlag,
+		Reasons: []string{"link to other.org is not allowed"},
+	}}, got.OutputVerdicts)
+
+	// Blocked responses fail like invalid ones.
+	_, err = queryService.ProcessPrompt(context.Background(), "refusal", "answer", "chat")
+	require.ErrorIs(t, err, service.ErrValidationFailed)
+	require.ErrorIs(t, err, guard.ErrOutputBlocked)
+
+	var blockedErr *guard.OutputBlockedError
+	require.ErrorAs(t, err, &blockedErr)
+	assert.Equal(t, "refusal", blockedErr.Verdicts[0].Guard)
+}
+
+func TestQueryServiceProcessPromptExtraction(t *testing.T) {
+	validator, err := validation.NewResponseSchemaValidator([]string{"schemas/answerResponse.cue"})
+	require.NoError(t, err)
+
+	request := prompt.PromptRequest{Model: "LlamaLocal"}
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "How old is Ron?", "LlamaLocal", "answer").Return(request, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("LlamaLocal")
+	mockLLM.On("CallModel", request).Return([]byte("Sure:\n```json\n{answer: 'Ron is 56',}\n```"), nil)
+
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     validator,
+		PromptBuilder: mockPromptBuilder,
+	}
+
+	got, err := queryService.ProcessPrompt(context.Background(), "How old is Ron?", "answerResponse", "answer")
+	require.NoError(t, err)
+	assert.Equal(t, `{"answer": "Ron is 56"}`, got.Response)
+	assert.Equal(t, []string{"fences", "unquoted_keys", "single_quotes", "trailing_commas"}, got.Repairs)
+}
+
+func TestQueryServiceProcessPromptValidationViolations(t *testing.T) {
+	validator, err := validation.NewResponseSchemaValidator([]string{"schemas/personResponse.cue"})
+	require.NoError(t, err)
+
+	request := prompt.PromptRequest{Model: "LlamaLocal"}
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "Ron is 200", "LlamaLocal", "person").Return(request, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("LlamaLocal")
+	mockLLM.On("CallModel", request).Return([]byte(`{"name": "Ron", "age": 200}`), nil)
+
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     validator,
+		PromptBuilder: mockPromptBuilder,
+	}
+
+	_, err = queryService.ProcessPrompt(context.Background(), "Ron is 200", "personResponse", "person")
+	require.ErrorIs(t, err, service.ErrValidationFailed)
+
+	var validationErr *validation.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Len(t, validationErr.Violations, 1)
+	assert.Equal(t, "/age", validationErr.Violations[0].Pointer)
+	assert.Equal(t, "<=130", validationErr.Violations[0].Constraint)
+}
+
+func TestQueryServiceProcessPromptRedaction(t *testing.T) {
+	redactor, err := redact.New(redact.Config{Mode: redact.ModeTokenize})
+	require.NoError(t, err)
+
+	request := prompt.PromptRequest{Model: "LlamaLocal"}
+	response := []byte(`{"email":"[EMAIL_1]"}`)
+
+	// The model only sees the token of the email address.
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "Who is [EMAIL_1]?", "LlamaLocal", "chat").Return(request, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("LlamaLocal")
+	mockLLM.On("CallModel", request).Return(response, nil)
+
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", "schema", response).Return(nil)
+
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     mockValidator,
+		PromptBuilder: mockPromptBuilder,
+		Redactor:      redactor,
+	}
+
+	got, err := queryService.ProcessPrompt(context.Background(), "Who is ada@example.com?", "schema", "chat")
+	require.NoError(t, err)
+	assert.Equal(t, `{"email":"ada@example.com"}`, got.Response)
+}
+
 func TestQueryServiceProcessPromptCallModelError(t *testing.T) {
 	testCase := struct {
 		name      string
@@ -191,16 +409,23 @@ func TestQueryServiceProcessPromptCallModelError(t *testing.T) {
 		schema := mock.Anything
 		task := mock.Anything
 
+		request := prompt.PromptRequest{Model: testCase.modelName}
+
+		mockPromptBuilder := new(MockPromptBuilder)
+		mockPromptBuilder.On("BuildPromptRequest", testCase.prompt, testCase.modelName, task).Return(request, nil)
+
 		mockLLM := new(MockLLM)
-		mockLLM.On("CallModel", testCase.prompt).Return(testCase.mockResp, assert.AnError)
+		mockLLM.On("Name").Return(testCase.modelName)
+		mockLLM.On("CallModel", request).Return(testCase.mockResp, assert.AnError)
 
 		service := &service.QueryService{
-			LlmModel:  mockLLM,
-			Validator: nil,
+			LlmModel:      mockLLM,
+			Validator:     nil,
+			PromptBuilder: mockPromptBuilder,
 		}
 
 		// Model call failed
-		_, err := service.ProcessPrompt(testCase.prompt, schema, task)
+		_, err := service.ProcessPrompt(context.Background(), testCase.prompt, schema, task)
 		assert.Error(t, err)
 
 		// Verify mock was called as expected
@@ -227,20 +452,27 @@ func TestQueryServiceProcessPromptValidateError(t *testing.T) {
 		schema := mock.Anything
 		task := mock.Anything
 
+		request := prompt.PromptRequest{Model: testCase.modelName}
+
+		mockPromptBuilder := new(MockPromptBuilder)
+		mockPromptBuilder.On("BuildPromptRequest", testCase.prompt, testCase.modelName, task).Return(request, nil)
+
 		mockLLM := new(MockLLM)
-		mockLLM.On("CallModel", testCase.prompt).Return(testCase.mockResp, nil)
+		mockLLM.On("Name").Return(testCase.modelName)
+		mockLLM.On("CallModel", request).Return(testCase.mockResp, nil)
 
 		mockValidator := new(MockValidator)
 		mockValidator.On("Validate", schema, testCase.mockResp).Return(assert.AnError)
 
 		// Create service with mock
 		service := &service.QueryService{
-			LlmModel:  mockLLM,
-			Validator: mockValidator,
+			LlmModel:      mockLLM,
+			Validator:     mockValidator,
+			PromptBuilder: mockPromptBuilder,
 		}
 
 		// Model call was successful, but validation failed
-		_, err := service.ProcessPrompt(testCase.prompt, schema, task)
+		_, err := service.ProcessPrompt(context.Background(), testCase.prompt, schema, task)
 		assert.Error(t, err)
 
 		// Verify mock was called as expected
@@ -248,3 +480,367 @@ func TestQueryServiceProcessPromptValidateError(t *testing.T) {
 		mockValidator.AssertExpectations(t)
 	})
 }
+
+func TestQueryServiceProcessPromptFallbackChain(t *testing.T) {
+	upstreamErr := &transport.UpstreamError{Kind: transport.KindConnection}
+	response := []byte(`{"name": "Ron", "age": 56}`)
+
+	testCases := []struct {
+		name          string
+		localResp     []byte
+		localErr      error
+		localValid    error
+		expectedCalls int
+	}{
+		{
+			name:          "upstream error moves to next model",
+			localErr:      upstreamErr,
+			expectedCalls: 1,
+		},
+		{
+			name:          "repeated validation failures move to next model",
+			localResp:     []byte("not json"),
+			localValid:    assert.AnError,
+			expectedCalls: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			task := "chat"
+			schema := "personResponse"
+
+			mockPromptBuilder := new(MockPromptBuilder)
+			mockPromptBuilder.On("BuildPromptRequest", "prompt", "local", task).Return(prompt.PromptRequest{Model: "local"}, nil)
+			mockPromptBuilder.On("BuildPromptRequest", "prompt", "hosted", task).Return(prompt.PromptRequest{Model: "hosted"}, nil)
+
+			local := new(MockLLM)
+			local.On("Name").Return("local")
+			local.On("CallModel", prompt.PromptRequest{Model: "local"}).Return(tc.localResp, tc.localErr)
+
+			hosted := new(MockLLM)
+			hosted.On("Name").Return("hosted")
+			hosted.On("CallModel", prompt.PromptRequest{Model: "hosted"}).Return(response, nil)
+
+			mockValidator := new(MockValidator)
+			mockValidator.On("Validate", schema, tc.localResp).Return(tc.localValid)
+			mockValidator.On("Validate", schema, response).Return(nil)
+
+			service := &service.QueryService{
+				LlmModel:      model.NewChain("chain", []model.Llm{local, hosted}, 2),
+				Validator:     mockValidator,
+				PromptBuilder: mockPromptBuilder,
+			}
+
+			got, err := service.ProcessPrompt(context.Background(), "prompt", schema, task)
+			require.NoError(t, err)
+			assert.Equal(t, "hosted", got.Model)
+			assert.Equal(t, string(response), got.Response)
+			local.AssertNumberOfCalls(t, "CallModel", tc.expectedCalls)
+		})
+	}
+}
+
+// newChatServer serves chat completions whose message content is the given response.
+func newChatServer(t *testing.T, status int, content string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"choices": [{"message": {"role": "assistant", "content": %q}}]}`, content)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestQueryServiceValidatesAnswerContent(t *testing.T) {
+	valid := newChatServer(t, http.StatusOK, `{"name": "Ron", "age": 56}`)
+	invalid := newChatServer(t, http.StatusOK, `{"name": "Ron", "age": 200}`)
+
+	noRetry := transport.Config{Retry: transport.RetryConfig{MaxAttempts: 1}}
+	model.RegisterDefinition("ContentValid", model.Definition{ModelName: "llama-3-1b-chat", BaseURL: valid.URL, Transport: noRetry})
+	model.RegisterDefinition("ContentInvalid", model.Definition{ModelName: "llama-3-1b-chat", BaseURL: invalid.URL, Transport: noRetry})
+
+	// Without tools the content of the first choice is validated and returned, not the envelope.
+	queryService, err := service.NewQueryService("ContentValid", []string{"schemas/personResponse.cue"}, []string{"prompts/promptTemplateDefault.yaml"})
+	require.NoError(t, err)
+	got, err := queryService.ProcessPrompt(context.Background(), "Who is Ron?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name": "Ron", "age": 56}`, got.Response)
+
+	queryService, err = service.NewQueryService("ContentInvalid", []string{"schemas/personResponse.cue"}, []string{"prompts/promptTemplateDefault.yaml"})
+	require.NoError(t, err)
+	_, err = queryService.ProcessPrompt(context.Background(), "Who is Ron?", "personResponse", "chat")
+	assert.ErrorIs(t, err, service.ErrValidationFailed)
+
+	// A backend that returns the bare content is validated as is.
+	bare := []byte(`{"name":"Ada"}`)
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "prompt", "local", "chat").Return(prompt.PromptRequest{Model: "local"}, nil)
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("local")
+	mockLLM.On("CallModel", prompt.PromptRequest{Model: "local"}).Return(bare, nil)
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", "personResponse", bare).Return(nil)
+
+	got, err = (&service.QueryService{LlmModel: mockLLM, Validator: mockValidator, PromptBuilder: mockPromptBuilder}).
+		ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.Equal(t, string(bare), got.Response)
+}
+
+func TestQueryServiceFallbackChainDefinitions(t *testing.T) {
+	local := newChatServer(t, http.StatusInternalServerError, "")
+	hosted := newChatServer(t, http.StatusOK, `{"name": "Ron", "age": 56}`)
+
+	noRetry := transport.Config{Retry: transport.RetryConfig{MaxAttempts: 1}}
+	model.RegisterDefinition("FallbackLocal", model.Definition{ModelName: "llama-3-1b-chat", BaseURL: local.URL, Transport: noRetry})
+	model.RegisterDefinition("FallbackHosted", model.Definition{ModelName: "gpt-4o-mini", BaseURL: hosted.URL, Transport: noRetry})
+	model.RegisterDefinition("FallbackChain", model.Definition{Chain: []string{"FallbackLocal", "FallbackHosted"}})
+
+	// The hosted model has no chat template of its own and falls back to the one without a model.
+	queryService, err := service.NewQueryService("FallbackChain",
+		[]string{"schemas/personResponse.cue"},
+		[]string{"prompts/promptTemplateDefault.yaml", "prompts/promptTemplateChat.yaml"},
+	)
+	require.NoError(t, err)
+
+	got, err := queryService.ProcessPrompt(context.Background(), "Who is Ron?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4o-mini", got.Model)
+	assert.JSONEq(t, `{"name": "Ron", "age": 56}`, got.Response)
+
+	// Without a template for every model the chain could never fall back.
+	_, err = service.NewQueryService("FallbackChain",
+		[]string{"schemas/personResponse.cue"},
+		[]string{"prompts/promptTemplateDefault.yaml"},
+	)
+	assert.EqualError(t, err, "chain FallbackChain: FallbackHosted has no prompt template for task chat")
+}
+
+func TestQueryServiceProcessPromptNoFallbackOnCanceled(t *testing.T) {
+	canceledErr := &transport.UpstreamError{Kind: transport.KindCanceled, Err: context.Canceled}
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "prompt", "local", "chat").Return(prompt.PromptRequest{Model: "local"}, nil)
+
+	local := new(MockLLM)
+	local.On("Name").Return("local")
+	local.On("CallModel", prompt.PromptRequest{Model: "local"}).Return([]byte{}, canceledErr)
+
+	hosted := new(MockLLM)
+	hosted.On("Name").Return("hosted")
+
+	service := &service.QueryService{
+		LlmModel:      model.NewChain("chain", []model.Llm{local, hosted}, 1),
+		PromptBuilder: mockPromptBuilder,
+	}
+
+	_, err := service.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+	assert.ErrorIs(t, err, context.Canceled)
+	hosted.AssertNotCalled(t, "CallModel", mock.Anything, mock.Anything)
+}
+
+func TestQueryServiceProcessPromptCache(t *testing.T) {
+	request := prompt.PromptRequest{
+		Model:           "local",
+		Messages:        []prompt.Message{{Role: "user", Content: "prompt"}},
+		TemplateVersion: "v1",
+	}
+	valid := []byte(`{"name":"Ada"}`)
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "prompt", "local", "chat").Return(request, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("local")
+	mockLLM.On("CallModel", request).Return(valid, nil)
+
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", "personResponse", valid).Return(nil)
+
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     mockValidator,
+		PromptBuilder: mockPromptBuilder,
+		Cache:         cache.NewLRU(10),
+	}
+
+	got, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.False(t, got.Cached)
+
+	got, err = queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.True(t, got.Cached)
+	assert.Equal(t, string(valid), got.Response)
+	assert.Equal(t, "local", got.Model)
+	assert.Equal(t, "v1", got.PromptVersion)
+	mockLLM.AssertNumberOfCalls(t, "CallModel", 1)
+
+	// A different schema is a different cache entry.
+	mockValidator.On("Validate", "otherResponse", valid).Return(nil)
+	got, err = queryService.ProcessPrompt(context.Background(), "prompt", "otherResponse", "chat")
+	require.NoError(t, err)
+	assert.False(t, got.Cached)
+	mockLLM.AssertNumberOfCalls(t, "CallModel", 2)
+
+	got, err = queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat", service.WithCacheBypass())
+	require.NoError(t, err)
+	assert.False(t, got.Cached)
+	mockLLM.AssertNumberOfCalls(t, "CallModel", 3)
+}
+
+func TestQueryServiceProcessPromptCacheSkipsInvalidResponses(t *testing.T) {
+	request := prompt.PromptRequest{Model: "local"}
+	invalid := []byte(`{}`)
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "prompt", "local", "chat").Return(request, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("local")
+	mockLLM.On("CallModel", request).Return(invalid, nil)
+
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", "personResponse", invalid).Return(assert.AnError)
+
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     mockValidator,
+		PromptBuilder: mockPromptBuilder,
+		Cache:         cache.NewLRU(10),
+	}
+
+	for range 2 {
+		_, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+		assert.ErrorIs(t, err, assert.AnError)
+	}
+	mockLLM.AssertNumberOfCalls(t, "CallModel", 2)
+}
+
+// fakeEmbedder embeds every text as the same vector, so all prompts are similar.
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) Name() string { return "fake" }
+
+func (fakeEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	vectors := make([][]float32, len(input))
+	for i := range input {
+		vectors[i] = []float32{1, 0}
+	}
+	return vectors, nil
+}
+
+// countingEmbedder is a fakeEmbedder that counts its calls.
+type countingEmbedder struct {
+	fakeEmbedder
+	calls int
+}
+
+func (e *countingEmbedder) Embed(ctx context.Context, input []string) ([][]float32, error) {
+	e.calls++
+	return e.fakeEmbedder.Embed(ctx, input)
+}
+
+func TestQueryServiceProcessPromptSemanticCacheScope(t *testing.T) {
+	valid := []byte(`{"name":"Ada"}`)
+	v1 := prompt.PromptRequest{Model: "local", TemplateVersion: "v1"}
+	v2 := prompt.PromptRequest{Model: "local", TemplateVersion: "v2"}
+	docs := prompt.PromptRequest{
+		Model:           "local",
+		Messages:        []prompt.Message{{Role: "user", Content: "What did Ada do?"}},
+		TemplateVersion: "v1",
+		Passages:        []prompt.Passage{{ID: "notes.txt#0", Text: "Ada wrote programs."}},
+	}
+	changedDocs := docs
+	changedDocs.Messages = []prompt.Message{{Role: "user", Content: "What did Ada do then?"}}
+	changedDocs.Passages = []prompt.Passage{{ID: "notes.txt#0", Text: "Ada was a mathematician."}}
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "Who is Ada?", "local", "chat").Return(v1, nil)
+	mockPromptBuilder.On("BuildPromptRequest", "Who was Ada?", "local", "chat").Return(v2, nil)
+	mockPromptBuilder.On("BuildPromptRequest", "What did Ada do?", "local", "chat").Return(docs, nil)
+	mockPromptBuilder.On("BuildPromptRequest", "What did Ada do then?", "local", "chat").Return(changedDocs, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("local")
+	mockLLM.On("CallModel", mock.Anything).Return(valid, nil)
+
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", "personResponse", valid).Return(nil)
+
+	embedder := &countingEmbedder{}
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     mockValidator,
+		PromptBuilder: mockPromptBuilder,
+		Cache:         cache.NewLRU(10),
+		SemanticCache: cache.NewSemantic(embedder, cache.SemanticConfig{}),
+	}
+
+	got, err := queryService.ProcessPrompt(context.Background(), "Who is Ada?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.False(t, got.Cached)
+
+	// A similar prompt of another template version is not served the answer of v1.
+	got, err = queryService.ProcessPrompt(context.Background(), "Who was Ada?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.False(t, got.Cached)
+	assert.Equal(t, "v2", got.PromptVersion)
+	mockLLM.AssertNumberOfCalls(t, "CallModel", 2)
+
+	// The exact match cache answers without embedding the prompt.
+	got, err = queryService.ProcessPrompt(context.Background(), "Who was Ada?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.True(t, got.Cached)
+	assert.Equal(t, 2, embedder.calls)
+
+	// Answers based on other retrieved passages are not served.
+	got, err = queryService.ProcessPrompt(context.Background(), "What did Ada do?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.False(t, got.Cached)
+	got, err = queryService.ProcessPrompt(context.Background(), "What did Ada do then?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.False(t, got.Cached)
+	mockLLM.AssertNumberOfCalls(t, "CallModel", 4)
+}
+
+func TestQueryServiceProcessPromptSemanticCache(t *testing.T) {
+	valid := []byte(`{"name":"Ada"}`)
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", mock.Anything, "local", "chat").Return(prompt.PromptRequest{Model: "local"}, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("local")
+	mockLLM.On("CallModel", prompt.PromptRequest{Model: "local"}).Return(valid, nil)
+
+	mockValidator := new(MockValidator)
+	mockValidator.On("Validate", "personResponse", valid).Return(nil)
+
+	semanticCache := cache.NewSemantic(fakeEmbedder{}, cache.SemanticConfig{})
+	queryService := &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     mockValidator,
+		PromptBuilder: mockPromptBuilder,
+		SemanticCache: semanticCache,
+	}
+
+	got, err := queryService.ProcessPrompt(context.Background(), "Who is Ada?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.False(t, got.Cached)
+
+	got, err = queryService.ProcessPrompt(context.Background(), "Who was Ada?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.True(t, got.Cached)
+	assert.Equal(t, "local", got.Model)
+	mockLLM.AssertNumberOfCalls(t, "CallModel", 1)
+
+	assert.Equal(t, 1, queryService.InvalidateCache("chat"))
+
+	got, err = queryService.ProcessPrompt(context.Background(), "Who was Ada?", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.False(t, got.Cached)
+	mockLLM.AssertNumberOfCalls(t, "CallModel", 2)
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	model "github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tools"
+	validation "github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+	"github.com/yreinhar/llm-go-blueprint/pkg/metrics"
+)
+
+var toolCalls = metrics.NewCounter("tool_calls_total")
+
+// ErrToolStepsExceeded is returned if the model keeps calling tools instead of answering.
+var ErrToolStepsExceeded = errors.New("model did not answer within the maximum tool steps")
+
+const (
+	defaultMaxToolSteps = 5
+	defaultToolTimeout  = 30 * time.Second
+)
+
+// toolLoop is the model → tool → model loop of ProcessPrompt and the agent. The model is called until
+// it answers without tool calls, the tools it asks for are run in between and their results are sent
+// back. The hooks let the agent record every step in its transcript and enforce its limits.
+type toolLoop struct {
+	// maxCalls limits the model calls, the last one has to answer.
+	maxCalls int
+	// limitErr is returned if the model still calls tools with the last allowed call.
+	limitErr error
+	// call makes a single model call.
+	call func(ctx context.Context, llm model.Llm, request prompt.PromptRequest) (model.Completion, error)
+	// checkCall, if set, runs after every model call including the answer, e.g. for a token budget.
+	checkCall func(completion model.Completion) error
+	// runTool runs a tool call and returns the result sent back to the model.
+	runTool func(ctx context.Context, tools []prompt.Tool, call prompt.ToolCall) string
+}
+
+// run returns the content of the final answer of the model.
+func (l toolLoop) run(ctx context.Context, llm model.Llm, request prompt.PromptRequest) ([]byte, error) {
+	// The conversation grows with every step, it must not share the messages of the caller.
+	request.Messages = slices.Clone(request.Messages)
+
+	for calls := 1; ; calls++ {
+		completion, err := l.call(ctx, llm, request)
+		if err != nil {
+			return nil, err
+		}
+		if l.checkCall != nil {
+			if err := l.checkCall(completion); err != nil {
+				return nil, err
+			}
+		}
+		if len(completion.ToolCalls) == 0 {
+			return []byte(completion.Content), nil
+		}
+
+		if len(request.Tools) == 0 {
+			return nil, fmt.Errorf("model called tools although none were declared")
+		}
+		if calls >= l.maxCalls {
+			return nil, l.limitErr
+		}
+
+		request.Messages = append(request.Messages, prompt.Message{
+			Role:      "assistant",
+			Content:   completion.Content,
+			ToolCalls: completion.ToolCalls,
+		})
+		for _, call := range completion.ToolCalls {
+			request.Messages = append(request.Messages, prompt.Message{
+				Role:       "tool",
+				ToolCallID: call.ID,
+				Content:    l.runTool(ctx, request.Tools, call),
+			})
+		}
+	}
+}
+
+// complete calls the model and returns the content of its final answer. If the request declares
+// tools, the tools the model asks for are run and their results are sent back until the model
+// answers, but for no more than MaxToolSteps rounds.
+func (s *QueryService) complete(ctx context.Context, llm model.Llm, request prompt.PromptRequest) ([]byte, error) {
+	maxSteps := s.MaxToolSteps
+	if maxSteps <= 0 {
+		maxSteps = defaultMaxToolSteps
+	}
+
+	loop := toolLoop{
+		maxCalls: maxSteps + 1,
+		limitErr: fmt.Errorf("%w: %d", ErrToolStepsExceeded, maxSteps),
+		call:     callCompletion,
+		runTool: func(ctx context.Context, tools []prompt.Tool, call prompt.ToolCall) string {
+			return s.runTool(ctx, tools, call, s.ToolTimeout)
+		},
+	}
+
+	return loop.run(ctx, llm, request)
+}
+
+// callCompletion calls the model and parses its chat completion.
+func callCompletion(ctx context.Context, llm model.Llm, request prompt.PromptRequest) (model.Completion, error) {
+	body, err := llm.CallModel(ctx, request)
+	if err != nil {
+		return model.Completion{}, fmt.Errorf("failed to call model: %w", err)
+	}
+
+	completion, err := model.ParseCompletion(body)
+	if err != nil {
+		return model.Completion{}, fmt.Errorf("failed to parse model response: %w", err)
+	}
+
+	return completion, nil
+}
+
+// checkTools fails if the arguments schema of a tool declared in the templates is not loaded. Tools
+// without a handler only log a warning, the model gets an error as result when it calls them.
+func checkTools(promptBuilder *prompt.PromptBuilder, validator *validation.ResponseSchemaValidator, registry *tools.Registry) error {
+	for _, tool := range promptBuilder.Tools() {
+		if _, err := validator.JSONSchema(tool.Arguments); err != nil {
+			return fmt.Errorf("arguments of tool %s: %w", tool.Name, err)
+		}
+
+		if registry == nil {
+			log.WithField("tool", tool.Name).Warn("No handler for tool, tools are not enabled")
+			continue
+		}
+		if _, ok := registry.Lookup(tool.Name); !ok {
+			log.WithField("tool", tool.Name).Warn("No handler registered for tool")
+		}
+	}
+
+	return nil
+}
+
+// runTool runs a tool call and returns its result. Failures are returned to the model as result,
+// so that it can correct its arguments or answer without the tool.
+func (s *QueryService) runTool(ctx context.Context, tools []prompt.Tool, call prompt.ToolCall, timeout time.Duration) string {
+	result, err := s.callTool(ctx, tools, call, timeout)
+	if err != nil {
+		return toolError(err)
+	}
+
+	return result
+}
+
+// toolError is the result the model gets for a failed tool call.
+func toolError(err error) string {
+	return fmt.Sprintf("error: %v", err)
+}
+
+// callTool validates the arguments of the call and runs the tool's handler in a sandbox.
+func (s *QueryService) callTool(ctx context.Context, tools []prompt.Tool, call prompt.ToolCall, timeout time.Duration) (string, error) {
+	result, err := s.validateAndCallTool(ctx, tools, call, timeout)
+	if err != nil {
+		log.WithField("tool", call.Name).Warnf("Tool call failed: %v", err)
+		toolCalls.Inc(call.Name, "error")
+		return "", err
+	}
+	toolCalls.Inc(call.Name, "ok")
+
+	return result, nil
+}
+
+func (s *QueryService) validateAndCallTool(ctx context.Context, tools []prompt.Tool, call prompt.ToolCall, timeout time.Duration) (string, error) {
+	i := slices.IndexFunc(tools, func(tool prompt.Tool) bool { return tool.Name == call.Name })
+	if i < 0 {
+		return "", fmt.Errorf("unknown tool %s", call.Name)
+	}
+
+	if err := s.Validator.Validate(tools[i].ArgumentsSchema, call.Arguments); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	if s.Tools == nil {
+		return "", fmt.Errorf("no handler for tool %s", call.Name)
+	}
+	handler, ok := s.Tools.Lookup(call.Name)
+	if !ok {
+		return "", fmt.Errorf("no handler for tool %s", call.Name)
+	}
+
+	return sandbox(ctx, handler, call.Arguments, timeout)
+}
+
+// sandbox runs the handler with its own deadline and turns panics into errors. The result of a
+// handler that ignores its context is dropped once the deadline passed.
+func sandbox(ctx context.Context, handler tools.Handler, arguments json.RawMessage, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		timeout = defaultToolTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	done := make(chan outcome, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: fmt.Errorf("tool panicked: %v", r)}
+			}
+		}()
+
+		result, err := handler.Call(ctx, arguments)
+		done <- outcome{result: result, err: err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("tool did not finish: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/model"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/tools"
+	validation "github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/validation/schematypes"
+	"github.com/yreinhar/llm-go-blueprint/pkg/service"
+)
+
+// scriptedLLM returns the given responses in order and records the requests.
+type scriptedLLM struct {
+	responses []string
+	requests  []prompt.PromptRequest
+}
+
+func (m *scriptedLLM) Name() string { return "local" }
+
+func (m *scriptedLLM) CallModel(ctx context.Context, request prompt.PromptRequest) ([]byte, error) {
+	m.requests = append(m.requests, request)
+	if len(m.requests) > len(m.responses) {
+		return nil, fmt.Errorf("unexpected call %d", len(m.requests))
+	}
+	return []byte(m.responses[len(m.requests)-1]), nil
+}
+
+func toolCallResponse(id, name, arguments string) string {
+	encoded, _ := json.Marshal(arguments)
+	return fmt.Sprintf(`{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[`+
+		`{"id":%q,"type":"function","function":{"name":%q,"arguments":%s}}]}}]}`, id, name, encoded)
+}
+
+func answerResponse(content string) string {
+	encoded, _ := json.Marshal(content)
+	return fmt.Sprintf(`{"choices":[{"message":{"role":"assistant","content":%s}}]}`, encoded)
+}
+
+func newToolService(t *testing.T, llm model.Llm, maxSteps int) *service.QueryService {
+	validator, err := validation.NewResponseSchemaValidator([]string{
+		"schemas/personResponse.cue",
+		"schemas/weatherArguments.cue",
+	})
+	require.NoError(t, err)
+
+	request := prompt.PromptRequest{
+		Model:    "local",
+		Messages: []prompt.Message{{Role: "user", Content: "Who lives in Berlin?"}},
+		Tools:    []prompt.Tool{{Name: "get_weather", ArgumentsSchema: "weatherArguments"}},
+	}
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "prompt", "local", "chat").Return(request, nil)
+
+	registry := tools.NewRegistry()
+	require.NoError(t, registry.Register("get_weather", tools.Typed(
+		func(ctx context.Context, arguments schematypes.WeatherArguments) (string, error) {
+			return "sunny, 21 degrees " + arguments.Unit + " in " + arguments.City, nil
+		},
+	)))
+
+	return &service.QueryService{
+		LlmModel:      llm,
+		Validator:     validator,
+		PromptBuilder: mockPromptBuilder,
+		Tools:         registry,
+		MaxToolSteps:  maxSteps,
+	}
+}
+
+func TestNewQueryServiceToolArgumentsSchema(t *testing.T) {
+	templates := []string{"prompts/promptTemplateWeather.yaml"}
+
+	_, err := service.NewQueryService("LlamaLocal", []string{"schemas/personResponse.cue"}, templates)
+	assert.EqualError(t, err, "arguments of tool get_weather: schema not found: weatherArguments")
+
+	_, err = service.NewQueryService("LlamaLocal", []string{"schemas/personResponse.cue", "schemas/weatherArguments.cue"}, templates)
+	assert.NoError(t, err)
+}
+
+func TestQueryServiceToolLoop(t *testing.T) {
+	llm := &scriptedLLM{responses: []string{
+		toolCallResponse("call-1", "get_weather", `{"city":"Berlin","unit":"celsius"}`),
+		answerResponse(`{"name":"Ada","age":36}`),
+	}}
+	queryService := newToolService(t, llm, 0)
+
+	got, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"Ada","age":36}`, got.Response)
+
+	require.Len(t, llm.requests, 2)
+	messages := llm.requests[1].Messages
+	require.Len(t, messages, 3)
+	assert.Equal(t, "assistant", messages[1].Role)
+	assert.Equal(t, "get_weather", messages[1].ToolCalls[0].Name)
+	assert.Equal(t, prompt.Message{Role: "tool", ToolCallID: "call-1", Content: "sunny, 21 degrees celsius in Berlin"}, messages[2])
+
+	// The conversation of the first call is not changed by later steps.
+	assert.Len(t, llm.requests[0].Messages, 1)
+}
+
+func TestQueryServiceToolErrorsAreReturnedToModel(t *testing.T) {
+	llm := &scriptedLLM{responses: []string{
+		toolCallResponse("call-1", "get_weather", `{"city":"Berlin"}`),
+		toolCallResponse("call-2", "get_time", `{}`),
+		answerResponse(`{"name":"Ada","age":36}`),
+	}}
+	queryService := newToolService(t, llm, 0)
+
+	_, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+	require.NoError(t, err)
+
+	require.Len(t, llm.requests, 3)
+	invalid := llm.requests[1].Messages[2]
+	assert.Contains(t, invalid.Content, "error: invalid arguments")
+	unknown := llm.requests[2].Messages[4]
+	assert.Equal(t, "error: unknown tool get_time", unknown.Content)
+}
+
+func TestQueryServiceToolStepsExceeded(t *testing.T) {
+	call := toolCallResponse("call-1", "get_weather", `{"city":"Berlin","unit":"celsius"}`)
+	llm := &scriptedLLM{responses: []string{call, call, call}}
+	queryService := newToolService(t, llm, 2)
+
+	_, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+	assert.ErrorIs(t, err, service.ErrToolStepsExceeded)
+	assert.Len(t, llm.requests, 3)
+}
+
+func TestQueryServiceUndeclaredToolCalls(t *testing.T) {
+	llm := &scriptedLLM{responses: []string{
+		toolCallResponse("call-1", "get_weather", `{"city":"Berlin","unit":"celsius"}`),
+	}}
+	queryService := newToolService(t, llm, 0)
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "prompt", "local", "chat").Return(prompt.PromptRequest{Model: "local"}, nil)
+	queryService.PromptBuilder = mockPromptBuilder
+
+	_, err := queryService.ProcessPrompt(context.Background(), "prompt", "personResponse", "chat")
+	assert.ErrorContains(t, err, "model called tools although none were declared")
+	assert.Len(t, llm.requests, 1)
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/redact"
+)
+
+// Outcomes of an agent run.
+const (
+	OutcomeAnswered    = "answered"
+	OutcomeStepLimit   = "step_limit"
+	OutcomeTokenBudget = "token_budget"
+	OutcomeTimeout     = "timeout"
+	OutcomeCanceled    = "canceled"
+	OutcomeBlocked     = "blocked"
+	OutcomeFailed      = "failed"
+)
+
+// Step kinds of a transcript.
+const (
+	StepModel = "model"
+	StepTool  = "tool"
+)
+
+// Transcript is the full record of an agent run: every model call and every tool call in order.
+type Transcript struct {
+	ID            string           `json:"id"`
+	Task          string           `json:"task"`
+	Input         string           `json:"input"`
+	Model         string           `json:"model,omitempty"`
+	PromptVersion string           `json:"promptVersion,omitempty"`
+	StartedAt     time.Time        `json:"startedAt"`
+	FinishedAt    time.Time        `json:"finishedAt"`
+	Outcome       string           `json:"outcome"`
+	Error         string           `json:"error,omitempty"`
+	TotalTokens   int              `json:"totalTokens"`
+	Steps         []TranscriptStep `json:"steps"`
+}
+
+// TranscriptStep is a single model or tool call of an agent run.
+type TranscriptStep struct {
+	Kind  string `json:"kind"`
+	Model string `json:"model,omitempty"`
+	// Content is the answer of the model or the result of the tool.
+	Content string `json:"content,omitempty"`
+	// ToolCalls are the tools the model asked for.
+	ToolCalls  []prompt.ToolCall `json:"toolCalls,omitempty"`
+	Tool       string            `json:"tool,omitempty"`
+	ToolCallID string            `json:"toolCallId,omitempty"`
+	Arguments  json.RawMessage   `json:"arguments,omitempty"`
+	Tokens     int               `json:"tokens,omitempty"`
+	StartedAt  time.Time         `json:"startedAt"`
+	DurationMs int64             `json:"durationMs"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// masked returns a copy of the transcript with personal data masked in all texts.
+func (t *Transcript) masked(r *redact.Redactor) *Transcript {
+	masked := *t
+	masked.Input = r.Mask(t.Input)
+	masked.Error = r.Mask(t.Error)
+	masked.Steps = make([]TranscriptStep, len(t.Steps))
+	for i, step := range t.Steps {
+		step.Content = r.Mask(step.Content)
+		step.Error = r.Mask(step.Error)
+		step.Arguments = maskJSON(r, step.Arguments)
+		step.ToolCalls = slices.Clone(step.ToolCalls)
+		for j, call := range step.ToolCalls {
+			step.ToolCalls[j].Arguments = maskJSON(r, call.Arguments)
+		}
+		masked.Steps[i] = step
+	}
+
+	return &masked
+}
+
+func maskJSON(r *redact.Redactor, data json.RawMessage) json.RawMessage {
+	if data == nil {
+		return nil
+	}
+	return json.RawMessage(r.Mask(string(data)))
+}
+
+// TranscriptStore persists the transcripts of agent runs for audit.
+type TranscriptStore interface {
+	Save(transcript *Transcript) error
+}
+
+// FileTranscriptStore writes every transcript as JSON file named after its id.
+type FileTranscriptStore struct {
+	dir string
+}
+
+// NewFileTranscriptStore creates a store in the given directory, the directory is created if it
+// does not exist.
+func NewFileTranscriptStore(dir string) (*FileTranscriptStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating transcript directory: %w", err)
+	}
+
+	return &FileTranscriptStore{dir: dir}, nil
+}
+
+// Dir returns the directory the transcripts are written to.
+func (s *FileTranscriptStore) Dir() string {
+	return s.dir
+}
+
+func (s *FileTranscriptStore) Save(transcript *Transcript) error {
+	data, err := json.MarshalIndent(transcript, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding transcript: %w", err)
+	}
+
+	path := filepath.Join(s.dir, transcript.ID+".json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("writing transcript: %w", err)
+	}
+
+	return nil
+}
+
+func newTranscriptID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// addStep appends the step and fills in its duration.
+func (t *Transcript) addStep(step TranscriptStep) {
+	step.DurationMs = time.Since(step.StartedAt).Milliseconds()
+	t.Steps = append(t.Steps, step)
+}
+
+// ranTools reports whether a tool was called; tools may have side effects, so the run is not
+// repeated with another model afterwards.
+func (t *Transcript) ranTools() bool {
+	for _, step := range t.Steps {
+		if step.Kind == StepTool {
+			return true
+		}
+	}
+
+	return false
+}
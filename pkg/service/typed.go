@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	validation "github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+)
+
+// TypedResult is a validated model response decoded into a Go value.
+type TypedResult[T any] struct {
+	Value T
+	Result
+}
+
+// Register binds the Go type T to a response schema so that Query can be used with T. It fails if
+// responses valid against the schema would not decode into T, e.g. because of a missing field or
+// a type mismatch, so incompatible types are found at startup instead of on the first response.
+func Register[T any](s *QueryService, schema string) error {
+	t := reflect.TypeFor[T]()
+
+	checker, ok := s.Validator.(validation.TypeChecker)
+	if !ok {
+		return fmt.Errorf("validator cannot check type %s against schema %s", t, schema)
+	}
+	if err := checker.CheckType(schema, t); err != nil {
+		return err
+	}
+
+	s.typesMu.Lock()
+	defer s.typesMu.Unlock()
+
+	if existing, ok := s.typeSchemas[t]; ok && existing != schema {
+		return fmt.Errorf("type %s is already registered for schema %s", t, existing)
+	}
+	if s.typeSchemas == nil {
+		s.typeSchemas = make(map[reflect.Type]string)
+	}
+	s.typeSchemas[t] = schema
+
+	return nil
+}
+
+// Query processes the input like ProcessPrompt, validates the response against the schema
+// registered for T and decodes it into T.
+func Query[T any](ctx context.Context, s *QueryService, input, task string, opts ...QueryOption) (*TypedResult[T], error) {
+	t := reflect.TypeFor[T]()
+
+	s.typesMu.RLock()
+	schema, ok := s.typeSchemas[t]
+	s.typesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no response schema registered for type %s", t)
+	}
+
+	result, err := s.ProcessPrompt(ctx, input, schema, task, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &TypedResult[T]{Result: *result}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(result.Response)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&typed.Value); err != nil {
+		return nil, fmt.Errorf("failed to decode response into %s: %w", t, err)
+	}
+
+	return typed, nil
+}
@@ -0,0 +1,82 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yreinhar/llm-go-blueprint/pkg/llm/prompt"
+	validation "github.com/yreinhar/llm-go-blueprint/pkg/llm/validation"
+	"github.com/yreinhar/llm-go-blueprint/pkg/service"
+)
+
+type person struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func newTypedService(t *testing.T, response string) (*service.QueryService, *MockLLM) {
+	validator, err := validation.NewResponseSchemaValidator([]string{"schemas/personResponse.cue"})
+	require.NoError(t, err)
+
+	mockPromptBuilder := new(MockPromptBuilder)
+	mockPromptBuilder.On("BuildPromptRequest", "Who is Ada?", "local", "chat").Return(prompt.PromptRequest{Model: "local"}, nil)
+
+	mockLLM := new(MockLLM)
+	mockLLM.On("Name").Return("local")
+	mockLLM.On("CallModel", prompt.PromptRequest{Model: "local"}).Return([]byte(response), nil)
+
+	return &service.QueryService{
+		LlmModel:      mockLLM,
+		Validator:     validator,
+		PromptBuilder: mockPromptBuilder,
+	}, mockLLM
+}
+
+func TestQueryTyped(t *testing.T) {
+	queryService, _ := newTypedService(t, `{"name":"Ada Lovelace","age":36}`)
+	require.NoError(t, service.Register[person](queryService, "personResponse"))
+
+	got, err := service.Query[person](context.Background(), queryService, "Who is Ada?", "chat")
+	require.NoError(t, err)
+	assert.Equal(t, person{Name: "Ada Lovelace", Age: 36}, got.Value)
+	assert.Equal(t, "local", got.Model)
+}
+
+func TestQueryTypedInvalidResponse(t *testing.T) {
+	queryService, _ := newTypedService(t, `{"name":"Ada Lovelace","age":360}`)
+	require.NoError(t, service.Register[person](queryService, "personResponse"))
+
+	_, err := service.Query[person](context.Background(), queryService, "Who is Ada?", "chat")
+	assert.ErrorIs(t, err, service.ErrValidationFailed)
+}
+
+func TestQueryTypedUnregistered(t *testing.T) {
+	queryService, mockLLM := newTypedService(t, `{}`)
+
+	_, err := service.Query[person](context.Background(), queryService, "Who is Ada?", "chat")
+	assert.ErrorContains(t, err, "no response schema registered")
+	mockLLM.AssertNotCalled(t, "CallModel")
+}
+
+func TestRegisterIncompatibleType(t *testing.T) {
+	queryService, _ := newTypedService(t, `{}`)
+
+	type animal struct {
+		Species string `json:"species"`
+	}
+	err := service.Register[animal](queryService, "personResponse")
+	assert.ErrorContains(t, err, "personResponse.name: no matching field")
+	assert.ErrorContains(t, err, "personResponse.species: field Species is not in the schema")
+
+	require.NoError(t, service.Register[person](queryService, "personResponse"))
+	assert.Error(t, service.Register[person](queryService, "otherResponse"))
+}
+
+func TestRegisterRequiresTypeChecker(t *testing.T) {
+	queryService := &service.QueryService{Validator: new(MockValidator)}
+
+	err := service.Register[person](queryService, "personResponse")
+	assert.ErrorContains(t, err, "cannot check type")
+}